@@ -0,0 +1,56 @@
+package gledki
+
+import (
+	"errors"
+	"maps"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Alias makes name a logical name for the template path, so the application
+// can render and include templates by names, which do not change when the
+// template tree is reorganized: after `t.Alias("home", "pages/index")`,
+// `t.Execute(w, "home")` and `${include home}` use "pages/index". An alias is
+// resolved to the full path of its template, so the template is compiled and
+// cached only once, no matter by which name it is used. Names and paths are
+// normalized – "./home.htm" is the same as "home". Aliases of aliases are not
+// resolved. Aliases are shared by all views. Register them before the first
+// [Gledki.Execute], like the filters.
+func (t *Gledki) Alias(name, path string) error {
+	name, path = t.normalizeName(name), t.normalizeName(path)
+	if name == "" || path == "" || name == path {
+		return errors.New("alias and path must be different and not empty")
+	}
+	b := t.base()
+	if b.aliases == nil {
+		b.aliases = make(map[string]string)
+	}
+	b.aliases[name] = path
+	return nil
+}
+
+// Aliases returns a copy of the registered aliases – name => path.
+func (t *Gledki) Aliases() map[string]string {
+	return maps.Clone(t.base().aliases)
+}
+
+// alias returns the path for name if name is an alias.
+func (t *Gledki) alias(name string) (string, bool) {
+	aliases := t.base().aliases
+	if len(aliases) == 0 {
+		return "", false
+	}
+	p, ok := aliases[t.normalizeName(name)]
+	return p, ok
+}
+
+// normalizeName returns name cleaned, with slashes as separators and without
+// the extension of the templates.
+func (t *Gledki) normalizeName(name string) string {
+	if name == "" {
+		return ""
+	}
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "./")
+	return t.trimExt(name)
+}
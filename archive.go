@@ -0,0 +1,118 @@
+package gledki
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ArchivedPage is a rendered page, passed to an [Archive].
+type ArchivedPage struct {
+	// Full path to the main template.
+	Path string `json:"path"`
+	// Hash of the data, the page was rendered with. See [Gledki.Hasher].
+	StashHash string `json:"stash_hash"`
+	// Version of the snapshot with the templates. See [Gledki.Snapshot].
+	Snapshot string `json:"snapshot,omitempty"`
+	// When the page was rendered.
+	Time time.Time `json:"time"`
+	// The rendered page.
+	Output []byte `json:"output"`
+}
+
+// Archive stores rendered pages for audit and compliance – to know what
+// exactly the customers were shown. Implement it to store the pages in a
+// database or an object storage. See [DirArchive].
+type Archive interface {
+	Store(page *ArchivedPage) error
+}
+
+// DirArchive is an [Archive], which stores every page as a JSON file in the
+// directory, named after the date, under the directory DirArchive.
+type DirArchive string
+
+// Store writes page to a file like
+// `<DirArchive>/2024-12-31/235959.999999999-<StashHash[:16]>.json`.
+func (d DirArchive) Store(page *ArchivedPage) error {
+	dir := filepath.Join(string(d), page.Time.Format(time.DateOnly))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	name := spf("%s-%s.json", page.Time.Format("150405.000000000"), page.StashHash[:min(16, len(page.StashHash))])
+	return os.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// archiving returns true if the page, being rendered, is to be archived.
+func (t *Gledki) archiving() bool {
+	if t.Archive == nil {
+		return false
+	}
+	n := t.base().pages.Add(1)
+	return t.ArchiveSample <= 1 || n%uint64(t.ArchiveSample) == 1
+}
+
+// archive stores the rendered page in the Archive in a goroutine.
+func (t *Gledki) archive(e *execution, path string, output []byte) {
+	page := &ArchivedPage{Path: path, StashHash: t.stashHash(e), Time: time.Now(), Output: output, Snapshot: t.Snapshot}
+	b := t.base()
+	b.archiveWG.Add(1)
+	go func(a Archive) {
+		defer b.archiveWG.Done()
+		if err := a.Store(page); err != nil {
+			t.Logger.Errorf("archiving %s: %v", path, err)
+		}
+	}(t.Archive)
+}
+
+// WaitArchive waits for the rendered pages to be stored in the
+// [Gledki.Archive]. Call it before the application exits.
+func (t *Gledki) WaitArchive() {
+	t.base().archiveWG.Wait()
+}
+
+// stashHash returns the hex encoded hash of the data for the execution and
+// the Stash. The keys are sorted, so the hash does not depend on the order of
+// the map. Functions are hashed only by their type.
+func (t *Gledki) stashHash(e *execution) string {
+	h := t.Hasher()
+	merged := make(Stash, len(t.Stash)+len(e.data))
+	for k, v := range t.Stash {
+		merged[k] = v
+	}
+	for k, v := range e.data {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		switch v := merged[k].(type) {
+		case []byte:
+			fmt.Fprintf(h, "%s=%s\n", k, v)
+		case TagFunc, TagFuncCtx:
+			fmt.Fprintf(h, "%s=%T\n", k, v)
+		default:
+			fmt.Fprintf(h, "%s=%v\n", k, v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// teeWriter returns w and a buffer, receiving a copy of everything written
+// to w.
+func teeWriter(w io.Writer) (io.Writer, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return io.MultiWriter(w, &buf), &buf
+}
@@ -0,0 +1,191 @@
+/*
+Package assets fingerprints static files for cache busting. The URL of an
+asset contains the hash of its content, so it can be cached forever by the
+browsers and the CDNs and changes when the file changes:
+
+	a := assets.New("./static", "/static/")
+	a.Register(tpls)
+	http.Handle("/static/", a.Handler())
+
+Then `${asset css/site.css}` in a template is replaced with
+"/static/css/site.1a2b3c4d.css" and `${sri css/site.css}` with the
+subresource integrity hash of the file for the integrity attribute:
+
+	<link rel="stylesheet" href="${asset css/site.css}" integrity="${sri css/site.css}">
+*/
+package assets
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+// Prefix is the prefix of the tags for the URLs, handled by
+// [Assets.Register].
+const Prefix = "asset "
+
+// SRIPrefix is the prefix of the tags for the subresource integrity hashes,
+// handled by [Assets.Register].
+const SRIPrefix = "sri "
+
+// HashLength is the number of hexadecimal digits of the hash in the URLs.
+var HashLength = 8
+
+// Assets computes the fingerprinted URLs for the files in a directory and
+// serves them. It is safe for concurrent use.
+type Assets struct {
+	dir    string
+	prefix string
+	mu     sync.RWMutex
+	// path => fingerprinted file
+	files map[string]file
+}
+
+// file is a fingerprinted file. A file is hashed again when its size or
+// modification time change.
+type file struct {
+	hash string
+	// subresource integrity, "sha384-…"
+	integrity string
+	size      int64
+	modTime   time.Time
+}
+
+// New returns Assets for the files in dir, served under the URL prefix,
+// e.g. "/static/".
+func New(dir, prefix string) *Assets {
+	return &Assets{dir: dir, prefix: strings.TrimRight(prefix, "/") + "/", files: make(map[string]file)}
+}
+
+// URL returns the URL for the file path, relative to the directory of a,
+// with the hash of its content before the extension.
+func (a *Assets) URL(name string) (string, error) {
+	name = cleanName(name)
+	hash, err := a.hash(name)
+	if err != nil {
+		return "", err
+	}
+	ext := path.Ext(name)
+	return a.prefix + strings.TrimSuffix(name, ext) + "." + hash + ext, nil
+}
+
+// Integrity returns the subresource integrity hash of the file path, relative
+// to the directory of a, like "sha384-…".
+func (a *Assets) Integrity(name string) (string, error) {
+	f, err := a.file(cleanName(name))
+	return f.integrity, err
+}
+
+func cleanName(name string) string {
+	return path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+}
+
+// hash returns the hash of the file name for its URL.
+func (a *Assets) hash(name string) (string, error) {
+	f, err := a.file(name)
+	return f.hash, err
+}
+
+// file returns the hashes of the file name, computing them if the file is new
+// or changed.
+func (a *Assets) file(name string) (file, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return file{}, fmt.Errorf("asset %s: invalid path", name)
+	}
+	full := filepath.Join(a.dir, filepath.FromSlash(name))
+	info, err := os.Stat(full)
+	if err != nil {
+		return file{}, fmt.Errorf("asset %s: %w", name, err)
+	}
+	a.mu.RLock()
+	f, ok := a.files[name]
+	a.mu.RUnlock()
+	if ok && f.size == info.Size() && f.modTime.Equal(info.ModTime()) {
+		return f, nil
+	}
+	fh, err := os.Open(full)
+	if err != nil {
+		return file{}, fmt.Errorf("asset %s: %w", name, err)
+	}
+	defer fh.Close()
+	h, sri := sha256.New(), sha512.New384()
+	if _, err = io.Copy(io.MultiWriter(h, sri), fh); err != nil {
+		return file{}, fmt.Errorf("asset %s: %w", name, err)
+	}
+	f = file{hash: hex.EncodeToString(h.Sum(nil))[:HashLength],
+		integrity: "sha384-" + base64.StdEncoding.EncodeToString(sri.Sum(nil)),
+		size:      info.Size(), modTime: info.ModTime()}
+	a.mu.Lock()
+	a.files[name] = f
+	a.mu.Unlock()
+	return f, nil
+}
+
+// Register makes `${asset path}` tags in the templates of t write the URL for
+// path and `${sri path}` tags – its subresource integrity hash. A missing
+// asset makes [gledki.Gledki.Execute] return an error.
+func (a *Assets) Register(t *gl.Gledki) {
+	for prefix, f := range map[string]func(string) (string, error){Prefix: a.URL, SRIPrefix: a.Integrity} {
+		t.HandlePrefix(prefix, func(w io.Writer, tag string) (int, error) {
+			value, err := f(strings.TrimSpace(strings.TrimPrefix(tag, prefix)))
+			if err != nil {
+				return 0, err
+			}
+			return io.WriteString(w, value)
+		})
+	}
+}
+
+// split returns the name of the file without the hash and the hash from the
+// URL path name. If there is no file for the name without the hash, name is
+// returned as is.
+func (a *Assets) split(name string) (string, string) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	i := strings.LastIndexByte(base, '.')
+	if i < 0 || len(base)-i-1 != HashLength {
+		return name, ""
+	}
+	if strings.Trim(base[i+1:], "0123456789abcdef") != "" {
+		return name, ""
+	}
+	if _, err := a.hash(base[:i] + ext); err != nil {
+		return name, ""
+	}
+	return base[:i] + ext, base[i+1:]
+}
+
+// Handler serves the files under the URL prefix of a. The hash is removed
+// from the requested path to find the file. The files with the current hash
+// are cached forever. The files without or with an old hash are served with
+// "Cache-Control: no-cache".
+func (a *Assets) Handler() http.Handler {
+	return http.StripPrefix(strings.TrimSuffix(a.prefix, "/"), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			name, hash := a.split(path.Clean(strings.TrimPrefix(r.URL.Path, "/")))
+			current, err := a.hash(name)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if hash == current {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+			http.ServeFile(w, r, filepath.Join(a.dir, filepath.FromSlash(name)))
+		}))
+}
@@ -0,0 +1,95 @@
+package assets
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+func TestAssets(t *testing.T) {
+	static, root := t.TempDir(), t.TempDir()
+	css := filepath.Join(static, "css", "site.css")
+	_ = os.MkdirAll(filepath.Dir(css), 0750)
+	_ = os.WriteFile(css, []byte("body{}"), 0600)
+	_ = os.WriteFile(filepath.Join(static, "app.deadbeef.js"), []byte("go()"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(`<link href="${asset css/site.css}">`), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CacheTemplates = false
+	a := New(static, "/static/")
+	a.Register(tpls)
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	m := regexp.MustCompile(`^<link href="(/static/css/site\.([0-9a-f]{8})\.css)">$`).FindStringSubmatch(b.String())
+	if m == nil {
+		t.Fatalf("Wrong output: %s", b.String())
+	}
+	url, hash := m[1], m[2]
+
+	h := a.Handler()
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+	rec := get(url)
+	if rec.Code != 200 || rec.Body.String() != "body{}" ||
+		!strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("The asset should be cached forever: %d %s %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if rec = get("/static/app.deadbeef.js"); rec.Code != 200 || rec.Body.String() != "go()" {
+		t.Fatalf("A file with a hash-like name should be served: %d", rec.Code)
+	}
+	if rec = get("/static/css/none.css"); rec.Code != 404 {
+		t.Fatalf("A missing asset should not be found: %d", rec.Code)
+	}
+
+	// A changed file gets a new URL and the old one is not cached.
+	_ = os.WriteFile(css, []byte("body{color:red}"), 0600)
+	_ = os.Chtimes(css, time.Now(), time.Now().Add(time.Second))
+	if next, _ := a.URL("css/site.css"); next == url || !strings.HasPrefix(next, "/static/css/site.") {
+		t.Fatalf("The URL should change with the content: %s", next)
+	}
+	if rec = get(url); rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("An old hash %s should not be cached: %v", hash, rec.Header())
+	}
+	if _, err := a.URL("../secret"); err == nil {
+		t.Fatal("Paths outside the directory should be rejected")
+	}
+	_ = os.WriteFile(filepath.Join(root, "bad.htm"), []byte(`${asset none.css}`), 0600)
+	if _, err := tpls.Execute(&b, "bad"); err == nil {
+		t.Fatal("A missing asset should be an error")
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	static, root := t.TempDir(), t.TempDir()
+	_ = os.WriteFile(filepath.Join(static, "app.js"), []byte("go()"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		`<script src="${asset app.js}" integrity="${sri app.js}"></script>`), 0600)
+	tpls, _ := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	tpls.CacheTemplates = false
+	a := New(static, "/static")
+	a.Register(tpls)
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	sum := sha512.Sum384([]byte("go()"))
+	sri := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	if !strings.Contains(b.String(), `integrity="`+sri+`"`) || !strings.Contains(b.String(), `src="/static/app.`) {
+		t.Fatalf("Wrong output: %s", b.String())
+	}
+}
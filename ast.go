@@ -0,0 +1,246 @@
+package gledki
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// NodeKind tells what a [Node] represents.
+type NodeKind int
+
+const (
+	// TextNode is literal text, copied as is to the output.
+	TextNode NodeKind = iota
+	// TagNode is a placeholder, replaced with a value from the [Stash].
+	TagNode
+	// DirectiveNode is an `include` or `wrapper` directive, processed by
+	// [Gledki.Compile].
+	DirectiveNode
+	// CommentNode is a note for template authors like `${# note}`, removed by
+	// [Gledki.Compile].
+	CommentNode
+)
+
+// Node is a node in the syntax tree of a template, produced by [Gledki.Parse]
+// and [Gledki.AST].
+type Node struct {
+	Kind NodeKind
+	// Byte offset of the node in the parsed text.
+	Pos int
+	// The node as found in the text, including the tags.
+	Raw string
+	// The literal text for TextNode and the content between the tags for
+	// TagNode and DirectiveNode, e.g. "title", "include partials/footer".
+	Text string
+	// The name of a directive: "include" or "wrapper".
+	Name string
+	// The argument of a directive – path to a template file, as written.
+	Arg string
+	// Set for `include?` directives – optional includes, which are replaced
+	// with a comment if they fail. See [Gledki.OnIncludeError].
+	Optional bool
+	// Full path to the included file. Set only by [Gledki.AST].
+	Path string
+	// Nodes of the compiled included file. Set only by [Gledki.AST].
+	Nodes []Node
+}
+
+// Matches the content of a directive tag. The paths may contain letters and
+// digits in any script, like "partials/подвал", and backslashes as separators,
+// like "partials\\footer".
+var directiveRe = regexp.MustCompile(`^(include\??|wrapper)\s+((?:[\pL\pN_]+::)?[/\\.\-\pL\pM\pN_]+)$`)
+
+// Starts the content of a comment tag.
+const commentPrefix = "#"
+
+// Parse splits text into nodes using [Gledki.Tags] as delimiters. A start tag
+// without an end tag is treated as literal text, like fasttemplate does.
+func (t *Gledki) Parse(text string) []Node {
+	var nodes []Node
+	start, end := t.Tags[0], t.Tags[1]
+	pos := 0
+	for {
+		i := strings.Index(text[pos:], start)
+		if i < 0 {
+			break
+		}
+		tagPos := pos + i
+		j := strings.Index(text[tagPos+len(start):], end)
+		if j < 0 {
+			break
+		}
+		if i > 0 {
+			nodes = append(nodes, Node{Kind: TextNode, Pos: pos,
+				Raw: text[pos:tagPos], Text: text[pos:tagPos]})
+		}
+		tagEnd := tagPos + len(start) + j + len(end)
+		n := Node{Kind: TagNode, Pos: tagPos, Raw: text[tagPos:tagEnd],
+			Text: text[tagPos+len(start) : tagEnd-len(end)]}
+		if m := directiveRe.FindStringSubmatch(n.Text); m != nil {
+			n.Kind, n.Arg = DirectiveNode, strings.ReplaceAll(m[2], `\`, "/")
+			n.Name, n.Optional = strings.CutSuffix(m[1], "?")
+		} else if strings.HasPrefix(n.Text, commentPrefix) {
+			n.Kind = CommentNode
+		}
+		nodes = append(nodes, n)
+		pos = tagEnd
+	}
+	if pos < len(text) {
+		nodes = append(nodes, Node{Kind: TextNode, Pos: pos, Raw: text[pos:],
+			Text: text[pos:]})
+	}
+	return nodes
+}
+
+// stripComments removes the comment tags from text. Comments, which are alone
+// on their line, are removed together with the line.
+func (t *Gledki) stripComments(text string) string {
+	nodes := t.Parse(text)
+	if !slices.ContainsFunc(nodes, func(n Node) bool { return n.Kind == CommentNode }) {
+		return text
+	}
+	var b strings.Builder
+	for i, n := range nodes {
+		if n.Kind != CommentNode {
+			b.WriteString(n.Raw)
+			continue
+		}
+		// drop the indentation before the comment and the line break after it
+		before := b.String()
+		line := before[strings.LastIndexByte(before, '\n')+1:]
+		if strings.TrimSpace(line) != "" || i+1 >= len(nodes) ||
+			nodes[i+1].Kind != TextNode {
+			continue
+		}
+		next := nodes[i+1].Raw
+		rest := strings.TrimPrefix(strings.TrimPrefix(next, "\r"), "\n")
+		if rest == next {
+			continue
+		}
+		b.Reset()
+		b.WriteString(before[:len(before)-len(line)])
+		nodes[i+1].Raw = rest
+	}
+	return b.String()
+}
+
+// Marks the whitespace before or after a tag for removal: `${- title -}`.
+const trimMarker = "-"
+
+// cutTrimMarkers returns the content of a tag without the trim markers and
+// whether there were markers on the left and on the right. A marker must be
+// separated from the content by whitespace.
+func cutTrimMarkers(content string) (string, bool, bool) {
+	left := strings.HasPrefix(content, trimMarker) &&
+		strings.TrimSpace(content[len(trimMarker):]) != content[len(trimMarker):]
+	if left {
+		content = content[len(trimMarker):]
+	}
+	right := strings.HasSuffix(content, trimMarker) && len(content) > len(trimMarker) &&
+		strings.TrimSpace(content[:len(content)-len(trimMarker)]) != content[:len(content)-len(trimMarker)]
+	if right {
+		content = content[:len(content)-len(trimMarker)]
+	}
+	return strings.TrimSpace(content), left, right
+}
+
+// trimMarkers removes the whitespace, including line breaks, before the tags
+// with a trim marker on the left and after the tags with a trim marker on the
+// right. The markers are removed too, so `${- include partials/footer -}` is a
+// normal include directive after that.
+func (t *Gledki) trimMarkers(text string) string {
+	nodes := t.Parse(text)
+	if !slices.ContainsFunc(nodes, func(n Node) bool {
+		_, left, right := cutTrimMarkers(n.Text)
+		return n.Kind != TextNode && (left || right)
+	}) {
+		return text
+	}
+	var b strings.Builder
+	trimNext := false
+	for _, n := range nodes {
+		if n.Kind == TextNode {
+			if trimNext {
+				n.Raw = strings.TrimLeftFunc(n.Raw, unicode.IsSpace)
+			}
+			b.WriteString(n.Raw)
+			trimNext = false
+			continue
+		}
+		content, left, right := cutTrimMarkers(n.Text)
+		if left {
+			before := strings.TrimRightFunc(b.String(), unicode.IsSpace)
+			b.Reset()
+			b.WriteString(before)
+		}
+		if left || right {
+			n.Raw = t.Tags[0] + content + t.Tags[1]
+		}
+		b.WriteString(n.Raw)
+		trimNext = right
+	}
+	return b.String()
+}
+
+// AST compiles (if needed) the template and returns its syntax tree. The
+// wrapper is already applied. The include directives are kept as nodes with
+// the nodes of the included files as children. Positions are relative to the
+// compiled text of each file with its include directives in place.
+func (t *Gledki) AST(path string) ([]Node, error) {
+	c, err := t.compileMain(path)
+	if err != nil {
+		return nil, err
+	}
+	return t.nodes(c), nil
+}
+
+func (t *Gledki) nodes(c *compiledFile) []Node {
+	var nodes []Node
+	offset := 0
+	for _, s := range c.segments {
+		for _, n := range t.Parse(s.text) {
+			n.Pos += offset
+			if s.file != nil {
+				n.Path, n.Nodes = s.file.path, t.nodes(s.file)
+			}
+			nodes = append(nodes, n)
+		}
+		offset += len(s.text)
+	}
+	return nodes
+}
+
+// Placeholders returns the sorted unique names of all placeholders in the
+// compiled template path, including the included files. Filters are stripped,
+// so `${title | upper}` gives "title".
+func (t *Gledki) Placeholders(path string) ([]string, error) {
+	nodes, err := t.AST(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var walk func(nodes []Node)
+	walk = func(nodes []Node) {
+		for _, n := range nodes {
+			switch n.Kind {
+			case TagNode:
+				if n.Text == literalStartTag {
+					continue
+				}
+				key, _, _ := strings.Cut(n.Text, "|")
+				seen[strings.TrimSpace(key)] = true
+			case DirectiveNode:
+				walk(n.Nodes)
+			}
+		}
+	}
+	walk(nodes)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
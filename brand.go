@@ -0,0 +1,42 @@
+package gledki
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+Brand writes a copy of the templates under the active roots to the directory
+dir with the values from profile – brand name, colors, paths to logos –
+substituted for their placeholders. All other placeholders are kept, so the
+copy is a template tree for one brand in a white-label product. dir must not
+exist.
+
+If bundle is false, the files are copied one by one with their directives, so
+the result can be used as a root or a theme. If bundle is true, the templates
+are compiled first, so every file is self-contained, like in a snapshot, saved
+by [Gledki.SaveSnapshot].
+*/
+func (t *Gledki) Brand(dir string, profile Stash, bundle bool) (err error) {
+	if _, err = os.Stat(dir); err == nil {
+		return fmt.Errorf("directory '%s' already exists", dir)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("brand: %v", r)
+		}
+	}()
+	return t.eachTemplate(func(path string) error {
+		var text string
+		var err error
+		if bundle {
+			text, err = t.Compile(path)
+		} else {
+			text, err = t.LoadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		return writeTemplate(dir, path, t.FtExecStringStd(text, profile))
+	})
+}
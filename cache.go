@@ -0,0 +1,88 @@
+package gledki
+
+import (
+	"maps"
+	"sync"
+)
+
+// fileCache holds the contents of the template files, read from disk. It is
+// safe for concurrent use and can be shared between several Gledki
+// instances. It is reference-counted – the files are dropped, when the last
+// instance releases it.
+type fileCache struct {
+	mu    sync.RWMutex
+	files filesMap
+	refs  int
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{files: make(filesMap, 5), refs: 1}
+}
+
+func (c *fileCache) get(path string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	text, ok := c.files[path]
+	return text, ok
+}
+
+func (c *fileCache) set(path, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[path] = text
+}
+
+func (c *fileCache) delete(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, path)
+}
+
+func (c *fileCache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.files)
+}
+
+func (c *fileCache) snapshot() filesMap {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.files)
+}
+
+func (c *fileCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.files)
+}
+
+func (c *fileCache) acquire() *fileCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs++
+	return c
+}
+
+func (c *fileCache) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs--
+	if c.refs <= 0 {
+		c.files = make(filesMap)
+	}
+}
+
+// ShareFiles makes t use the same cache of template files as other. This way
+// many instances (themes, tenants) with the same or overlapping roots read
+// every file from disk only once and keep only one copy of it in memory. The
+// files are keyed by their full paths, so the roots of the two instances do
+// not have to be the same. [Gledki.ClearCache] clears the shared cache for all
+// the instances.
+func (t *Gledki) ShareFiles(other *Gledki) {
+	t, other = t.base(), other.base()
+	if t.files == other.files {
+		return
+	}
+	t.files.release()
+	t.files = other.files.acquire()
+}
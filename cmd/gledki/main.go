@@ -0,0 +1,314 @@
+/*
+Command gledki is a helper for working with [gledki] templates without
+writing a Go program.
+
+Usage:
+
+	gledki theme new <name> --from <baseRoot> [--ext .htm,.html] [--link] [template ...]
+	gledki brand <dir> --from <root> --profile <profile.json> [--ext .htm,.html] [--bundle]
+	gledki gen --from <root> --pkg <package> [--name <bundle>] [--ext .htm,.html] [-o file.go]
+	gledki render <template> --root <root> [--data data.json] [--ext .htm,.html]
+	gledki lint <root> [--ext .htm,.html]
+	gledki list <root> [--ext .htm,.html]
+	gledki deps <template> --root <root> [--ext .htm,.html]
+
+`theme new` creates the directory <name> with stub copies (or symbolic links
+with --link) of the listed templates from <baseRoot>, which the theme author
+wants to override. Then it prints which templates of the base theme are
+shadowed by the new theme.
+
+`brand` creates the directory <dir> with a copy of the templates from <root>,
+in which the values from the JSON object in <profile.json> are substituted for
+their placeholders – e.g. {"brand": {"name": "Acme", "color": "#c00"}} for
+${brand.name} and ${brand.color}. With --bundle the templates are compiled
+first, so every file is self-contained. Run it for every brand of a
+white-label product at build time.
+
+`gen` compiles all templates under <root> and writes a Go file for the
+package <package> (to the standard output without -o), which embeds them and
+registers them with [gledki.RegisterBundle] under the name <bundle> – by
+default <package>. The application reads them with [gledki.Gledki.UseBundle].
+Use it with `go generate`:
+
+	//go:generate gledki gen --from ./templates --pkg views -o templates_gen.go
+
+`render` writes <template> to the standard output with the values from the
+JSON object in <data.json>, like `brand` does. `lint` checks all templates
+under <root> with [gledki.Gledki.Lint] and prints the problems – it fails if
+there are any. `list` prints the templates under <root> and `deps`
+prints the files, which <template> depends on. Roots can be given several
+times – the first ones take precedence, like in [gledki.New].
+*/
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gl "github.com/kberov/gledki"
+)
+
+const usage = `Usage:
+	gledki theme new <name> --from <baseRoot> [--ext .htm,.html] [--link] [template ...]
+	gledki brand <dir> --from <root> --profile <profile.json> [--ext .htm,.html] [--bundle]
+	gledki gen --from <root> --pkg <package> [--name <bundle>] [--ext .htm,.html] [-o file.go]
+	gledki render <template> --root <root> [--data data.json] [--ext .htm,.html]
+	gledki lint <root> [--ext .htm,.html]
+	gledki list <root> [--ext .htm,.html]
+	gledki deps <template> --root <root> [--ext .htm,.html]
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command in args, writes its output to stdout and the
+// errors to stderr, and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	err := command(args, stdout, stderr)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	}
+	fmt.Fprintln(stderr, err.Error())
+	return 1
+}
+
+func command(args []string, out, errOut io.Writer) error {
+	switch {
+	case len(args) >= 3 && args[0] == "theme" && args[1] == "new":
+		return themeNew(args[2], args[3:], out, errOut)
+	case len(args) >= 2 && args[0] == "brand":
+		return brand(args[1], args[2:], out, errOut)
+	case len(args) >= 1 && args[0] == "gen":
+		return gen(args[1:], out, errOut)
+	case len(args) >= 2 && args[0] == "render":
+		return render(args[1], args[2:], out, errOut)
+	case len(args) >= 2 && args[0] == "lint":
+		return lint(args[1], args[2:], out, errOut)
+	case len(args) >= 2 && args[0] == "list":
+		return list(args[1], args[2:], out, errOut)
+	case len(args) >= 2 && args[0] == "deps":
+		return deps(args[1], args[2:], out, errOut)
+	}
+	return fmt.Errorf("%s", usage)
+}
+
+func themeNew(name string, args []string, out, errOut io.Writer) error {
+	flags := newFlagSet("theme new", errOut)
+	from := flags.String("from", "", "the base root with the templates to override")
+	ext := flags.String("ext", ".htm", "the extensions of the template files, separated by commas")
+	link := flags.Bool("link", false, "create symbolic links instead of copies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("--from is required\n%s", usage)
+	}
+	tpls, err := gl.New([]string{*from}, strings.Split(*ext, ","), [2]string{"${", "}"}, false)
+	if err != nil {
+		return err
+	}
+	report, err := tpls.ScaffoldTheme(*from, name, flags.Args(), *link)
+	if err != nil {
+		return err
+	}
+	printReport(out, report)
+	return nil
+}
+
+func printReport(out io.Writer, r *gl.ShadowReport) {
+	for _, path := range r.Shadowed {
+		fmt.Fprintf(out, "overrides\t%s\n", path)
+	}
+	for _, path := range r.OnlyTheme {
+		fmt.Fprintf(out, "new\t%s\n", path)
+	}
+	for _, path := range r.OnlyBase {
+		fmt.Fprintf(out, "inherited\t%s\n", path)
+	}
+}
+
+func brand(dir string, args []string, out, errOut io.Writer) error {
+	flags := newFlagSet("brand", errOut)
+	from := flags.String("from", "", "the root with the templates")
+	profile := flags.String("profile", "", "JSON file with the values for the brand")
+	ext := flags.String("ext", ".htm", "the extensions of the template files, separated by commas")
+	bundle := flags.Bool("bundle", false, "compile the templates")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *profile == "" {
+		return fmt.Errorf("--from and --profile are required\n%s", usage)
+	}
+	stash, err := readJSON(*profile)
+	if err != nil {
+		return err
+	}
+	tpls, err := gl.New([]string{*from}, strings.Split(*ext, ","), [2]string{"${", "}"}, false)
+	if err != nil {
+		return err
+	}
+	tpls.CacheTemplates = false
+	if err = tpls.Brand(dir, stash, *bundle); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "created\t%s\n", dir)
+	return nil
+}
+
+func gen(args []string, out, errOut io.Writer) error {
+	flags := newFlagSet("gen", errOut)
+	from := flags.String("from", "", "the root with the templates")
+	pkg := flags.String("pkg", "", "the package of the generated file")
+	name := flags.String("name", "", "the name of the bundle for gledki.Gledki.UseBundle (default: the package)")
+	ext := flags.String("ext", ".htm", "the extensions of the template files, separated by commas")
+	file := flags.String("o", "", "the generated file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *pkg == "" {
+		return fmt.Errorf("--from and --pkg are required\n%s", usage)
+	}
+	tpls, err := gl.New([]string{*from}, strings.Split(*ext, ","), [2]string{"${", "}"}, false)
+	if err != nil {
+		return err
+	}
+	tpls.CacheTemplates = false
+	*name = cmp.Or(*name, *pkg)
+	if *file == "" {
+		return tpls.GenerateGo(out, *pkg, *name)
+	}
+	var b strings.Builder
+	if err = tpls.GenerateGo(&b, *pkg, *name); err != nil {
+		return err
+	}
+	if err = os.WriteFile(*file, []byte(b.String()), 0640); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "created\t%s\n", *file)
+	return nil
+}
+
+// newFlagSet returns a flag set, which prints its errors and usage to errOut.
+func newFlagSet(name string, errOut io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	return flags
+}
+
+// rootsFlag collects the roots from repeated --root flags.
+type rootsFlag []string
+
+func (r *rootsFlag) String() string { return strings.Join(*r, ",") }
+
+func (r *rootsFlag) Set(root string) error {
+	*r = append(*r, root)
+	return nil
+}
+
+// newTemplates parses args with flags and returns Gledki for the roots and
+// the extensions in them. If roots is nil, they are taken from --root.
+func newTemplates(flags *flag.FlagSet, args, roots []string) (*gl.Gledki, error) {
+	ext := flags.String("ext", ".htm", "the extensions of the template files, separated by commas")
+	var rootFlags rootsFlag
+	if roots == nil {
+		flags.Var(&rootFlags, "root", "a root with the templates, can be repeated")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if roots == nil {
+		if roots = rootFlags; len(roots) == 0 {
+			return nil, fmt.Errorf("--root is required\n%s", usage)
+		}
+	}
+	tpls, err := gl.New(roots, strings.Split(*ext, ","), [2]string{"${", "}"}, false)
+	if err != nil {
+		return nil, err
+	}
+	tpls.CacheTemplates = false
+	return tpls, nil
+}
+
+func render(template string, args []string, out, errOut io.Writer) error {
+	flags := newFlagSet("render", errOut)
+	data := flags.String("data", "", "JSON file with the values for the placeholders")
+	tpls, err := newTemplates(flags, args, nil)
+	if err != nil {
+		return err
+	}
+	if *data != "" {
+		values, err := readJSON(*data)
+		if err != nil {
+			return err
+		}
+		tpls.MergeStash(values)
+	}
+	_, err = tpls.Execute(out, template)
+	return err
+}
+
+func lint(root string, args []string, out, errOut io.Writer) error {
+	tpls, err := newTemplates(newFlagSet("lint", errOut), args, []string{root})
+	if err != nil {
+		return err
+	}
+	problems := tpls.Lint()
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Fprintln(out, "ok")
+	return nil
+}
+
+func list(root string, args []string, out, errOut io.Writer) error {
+	tpls, err := newTemplates(newFlagSet("list", errOut), args, []string{root})
+	if err != nil {
+		return err
+	}
+	paths, err := tpls.Templates()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Fprintln(out, path)
+	}
+	return nil
+}
+
+func deps(template string, args []string, out, errOut io.Writer) error {
+	tpls, err := newTemplates(newFlagSet("deps", errOut), args, nil)
+	if err != nil {
+		return err
+	}
+	paths, err := tpls.Dependencies(template)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Fprintln(out, path)
+	}
+	return nil
+}
+
+// readJSON reads the JSON object in file into a Stash with dotted keys.
+func readJSON(file string) (gl.Stash, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	stash, err := gl.StashFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return stash, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+)
+
+// newTheme writes files – paths and contents – to a new temporary directory
+// and returns it.
+func newTheme(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, text := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// runTest runs the command in args and checks its exit code and that its
+// output contains stdout and stderr.
+func runTest(t *testing.T, args []string, code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut strings.Builder
+	if got := run(args, &out, &errOut); got != code {
+		t.Fatalf("Wrong exit code %d, expected %d\nstdout: %s\nstderr: %s", got, code, out.String(), errOut.String())
+	}
+	if !strings.Contains(out.String(), stdout) {
+		t.Fatalf("stdout should contain %q:\n%s", stdout, out.String())
+	}
+	if !strings.Contains(errOut.String(), stderr) {
+		t.Fatalf("stderr should contain %q:\n%s", stderr, errOut.String())
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBrand(t *testing.T) {
+	root := newTheme(t, map[string]string{
+		"page.htm":            "<h1>${brand.name}</h1>${include partials/footer}",
+		"partials/footer.htm": "<footer style=\"color:${brand.color}\">${year}</footer>",
+		"profile.json":        `{"brand": {"name": "Acme", "color": "#c00"}}`,
+		"broken.json":         `{"brand": `,
+	})
+	profile := filepath.Join(root, "profile.json")
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+		// the expected files in the created directory
+		files map[string]string
+	}{
+		{
+			name:   "copies",
+			args:   []string{"--from", root, "--profile", profile},
+			stdout: "created\t",
+			files: map[string]string{
+				"page.htm":            "<h1>Acme</h1>${include partials/footer}",
+				"partials/footer.htm": "<footer style=\"color:#c00\">${year}</footer>",
+			},
+		},
+		{
+			name:   "bundle",
+			args:   []string{"--from", root, "--profile", profile, "--bundle"},
+			stdout: "created\t",
+			files: map[string]string{
+				"page.htm": "<h1>Acme</h1><footer style=\"color:#c00\">${year}</footer>",
+			},
+		},
+		{
+			name:   "no profile",
+			args:   []string{"--from", root},
+			code:   1,
+			stderr: "--from and --profile are required",
+		},
+		{
+			name:   "broken profile",
+			args:   []string{"--from", root, "--profile", filepath.Join(root, "broken.json")},
+			code:   1,
+			stderr: "broken.json",
+		},
+		{
+			name:   "missing profile",
+			args:   []string{"--from", root, "--profile", filepath.Join(root, "missing.json")},
+			code:   1,
+			stderr: "missing.json",
+		},
+		{
+			name:   "unknown flag",
+			args:   []string{"--from", root, "--profile", profile, "--color"},
+			code:   1,
+			stderr: "flag provided but not defined: -color",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "acme")
+			runTest(t, append([]string{"brand", dir}, tc.args...), tc.code, tc.stdout, tc.stderr)
+			for path, text := range tc.files {
+				if got := readFile(t, filepath.Join(dir, path)); got != text {
+					t.Fatalf("Wrong %s:\n%s", path, got)
+				}
+			}
+		})
+	}
+	// The directory must not exist.
+	runTest(t, []string{"brand", root, "--from", root, "--profile", profile}, 1, "", "already exists")
+}
+
+func TestThemeNew(t *testing.T) {
+	base := newTheme(t, map[string]string{
+		"layout.htm":          "<html>${content}</html>",
+		"page.htm":            "${wrapper layout}<h1>${title}</h1>",
+		"partials/footer.htm": "<footer>${year}</footer>",
+	})
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+		// the expected files in the theme
+		files []string
+	}{
+		{
+			name:   "copies",
+			args:   []string{"--from", base, "layout", "partials/footer.htm"},
+			stdout: "overrides\tlayout.htm\noverrides\tpartials/footer.htm\ninherited\tpage.htm\n",
+			files:  []string{"layout.htm", "partials/footer.htm"},
+		},
+		{
+			name:   "links",
+			args:   []string{"--from", base, "--link", "page"},
+			stdout: "overrides\tpage.htm\n",
+			files:  []string{"page.htm"},
+		},
+		{
+			name:   "no base",
+			args:   []string{"layout"},
+			code:   1,
+			stderr: "--from is required",
+		},
+		{
+			name:   "outside the theme",
+			args:   []string{"--from", base, "../layout"},
+			code:   1,
+			stderr: "invalid argument",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			theme := filepath.Join(t.TempDir(), "dark")
+			runTest(t, append([]string{"theme", "new", theme}, tc.args...), tc.code, tc.stdout, tc.stderr)
+			for _, path := range tc.files {
+				if got, want := readFile(t, filepath.Join(theme, path)), readFile(t, filepath.Join(base, path)); got != want {
+					t.Fatalf("Wrong %s:\n%s", path, got)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(theme), "layout.htm")); err == nil {
+				t.Fatal("No file should be written outside the theme")
+			}
+		})
+	}
+	// Existing files are not overwritten.
+	theme := newTheme(t, map[string]string{"layout.htm": "dark"})
+	runTest(t, []string{"theme", "new", theme, "--from", base, "layout"}, 1, "", "already exists")
+}
+
+// pages are the templates of a small site for the tests.
+var pages = map[string]string{
+	"layout.htm":          "<html>${content}</html>",
+	"page.htm":            "${wrapper layout}<h1>${title}</h1>${include partials/footer}",
+	"partials/footer.htm": "<footer>${year}</footer>",
+	"data.json":           `{"title": "Hello", "year": 2026}`,
+}
+
+func TestGen(t *testing.T) {
+	root := newTheme(t, pages)
+	file := filepath.Join(t.TempDir(), "templates_gen.go")
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "stdout",
+			args:   []string{"--from", root, "--pkg", "views"},
+			stdout: "package views\n",
+		},
+		{
+			name:   "file",
+			args:   []string{"--from", root, "--pkg", "views", "-o", file},
+			stdout: "created\t" + file,
+		},
+		{
+			name:   "no package",
+			args:   []string{"--from", root},
+			code:   1,
+			stderr: "--from and --pkg are required",
+		},
+		{
+			name:   "invalid package",
+			args:   []string{"--from", root, "--pkg", "my-views"},
+			code:   1,
+			stderr: "invalid package name 'my-views'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runTest(t, append([]string{"gen"}, tc.args...), tc.code, tc.stdout, tc.stderr)
+		})
+	}
+	if text := readFile(t, file); !strings.Contains(text, `gledki.RegisterBundle("views", bundle)`) {
+		t.Fatalf("Wrong generated file:\n%s", text)
+	}
+}
+
+func TestGenBundle(t *testing.T) {
+	root := newTheme(t, pages)
+	// A bundle can be registered only once, so its name is unique for -count.
+	name := "views-" + filepath.Base(filepath.Dir(root))
+	var out, errOut strings.Builder
+	if code := run([]string{"gen", "--from", root, "--pkg", "views", "--name", name}, &out, &errOut); code != 0 {
+		t.Fatalf("Wrong exit code %d: %s", code, errOut.String())
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "templates_gen.go", out.String(), 0)
+	if err != nil || !strings.Contains(out.String(), `gledki.RegisterBundle("`+name+`", bundle)`) {
+		t.Fatalf("Wrong generated file: %v\n%s", err, out.String())
+	}
+	// What the init function of the generated file does.
+	lit := f.Scope.Lookup("bundle").Decl.(*ast.ValueSpec).Values[0].(*ast.BasicLit)
+	bundle, _ := strconv.Unquote(lit.Value)
+	gl.RegisterBundle(name, bundle)
+	// The templates are served from the bundle without their files.
+	tpls, err := gl.New([]string{t.TempDir()}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CompiledNextToTemplates = true
+	defer tpls.Close()
+	if err := tpls.UseBundle(name); err != nil {
+		t.Fatalf("Error UseBundle: %s", err.Error())
+	}
+	tpls.MergeStash(gl.Stash{"title": "Hello", "year": "2026"})
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<html><h1>Hello</h1><footer>2026</footer></html>" {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	root := newTheme(t, pages)
+	theme := newTheme(t, map[string]string{"layout.htm": "<body>${content}</body>"})
+	data := filepath.Join(root, "data.json")
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "data",
+			args:   []string{"page", "--root", root, "--data", data},
+			stdout: "<html><h1>Hello</h1><footer>2026</footer></html>",
+		},
+		{
+			name:   "roots",
+			args:   []string{"page", "--root", theme, "--root", root, "--data", data},
+			stdout: "<body><h1>Hello</h1><footer>2026</footer></body>",
+		},
+		{
+			name:   "no root",
+			args:   []string{"page"},
+			code:   1,
+			stderr: "--root is required",
+		},
+		{
+			name:   "missing template",
+			args:   []string{"missing", "--root", root},
+			code:   1,
+			stderr: "missing.htm",
+		},
+		{
+			name:   "missing data",
+			args:   []string{"page", "--root", root, "--data", filepath.Join(root, "missing.json")},
+			code:   1,
+			stderr: "missing.json",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runTest(t, append([]string{"render"}, tc.args...), tc.code, tc.stdout, tc.stderr)
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	root := newTheme(t, pages)
+	runTest(t, []string{"lint", root}, 0, "ok\n", "")
+	broken := newTheme(t, map[string]string{
+		"page.htm":    "${include missing}\n${wrapper bare}",
+		"bare.htm":    "<html></html>",
+		"partial.htm": "${title",
+	})
+	var out, errOut strings.Builder
+	if code := run([]string{"lint", broken}, &out, &errOut); code != 1 {
+		t.Fatalf("Wrong exit code %d", code)
+	}
+	if errOut.String() != "3 problems found\n" {
+		t.Fatalf("Wrong stderr: %s", errOut.String())
+	}
+	for _, problem := range []string{
+		filepath.Join(broken, "page.htm") + ":1: unreachable: ",
+		filepath.Join(broken, "page.htm") + ":2: missing-content: ",
+		filepath.Join(broken, "partial.htm") + ":1: delimiters: ",
+	} {
+		if !strings.Contains(out.String(), problem) {
+			t.Fatalf("stdout should contain %q:\n%s", problem, out.String())
+		}
+	}
+	runTest(t, []string{"lint"}, 1, "", "Usage:")
+}
+
+func TestList(t *testing.T) {
+	root := newTheme(t, pages)
+	runTest(t, []string{"list", root}, 0, "layout.htm\npage.htm\npartials/footer.htm\n", "")
+	runTest(t, []string{"list", root, "--ext", ".json"}, 0, "data.json\n", "")
+	runTest(t, []string{"list", root, "--root", root}, 1, "", "flag provided but not defined: -root")
+}
+
+func TestDeps(t *testing.T) {
+	root := newTheme(t, pages)
+	deps := filepath.Join(root, "layout.htm") + "\n" + filepath.Join(root, "partials", "footer.htm") + "\n"
+	runTest(t, []string{"deps", "page", "--root", root}, 0, deps, "")
+	runTest(t, []string{"deps", "missing", "--root", root}, 1, "", "missing.htm")
+	runTest(t, []string{"deps", "page"}, 1, "", "--root is required")
+}
+
+func TestUsage(t *testing.T) {
+	runTest(t, nil, 1, "", "Usage:")
+	runTest(t, []string{"brand"}, 1, "", "Usage:")
+	runTest(t, []string{"brand", "dir", "-h"}, 0, "", "-profile")
+}
@@ -0,0 +1,239 @@
+package gledki
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+WriteBundle compiles all templates under [Gledki.Roots] and writes them as a
+zip archive to w – the same compiled text, which is otherwise stored next to
+every template. Compile the templates at build time and ship one bundle,
+loaded with [Gledki.ReadBundle], instead of scattering compiled files through
+the tree. The templates are named by their paths, relative to the roots, so
+the bundle can be used with the roots at other places. Shadowed templates and
+templates with a wrapper from the Stash (see [Gledki.Compile]) are skipped.
+Unlike a snapshot, saved by [Gledki.SaveSnapshot], the bundle is not meant for
+rendering pages like they were in the past.
+*/
+func (t *Gledki) WriteBundle(w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bundle: %v", r)
+		}
+	}()
+	zw := zip.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, root := range t.Roots {
+		paths, err := t.listTemplates(root)
+		if err != nil {
+			return err
+		}
+		for _, rel := range paths {
+			if seen[rel] {
+				continue
+			}
+			seen[rel] = true
+			meta, text, layout, err := t.compileSource(t.Roots, t.findPath(t.Roots, rel))
+			if err != nil {
+				return err
+			}
+			if layout != "" {
+				continue
+			}
+			f, err := zw.Create(rel)
+			if err != nil {
+				return err
+			}
+			if _, err = io.WriteString(f, formatFrontMatter(meta, text)+text); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+/*
+ReadBundle reads a bundle, written by [Gledki.WriteBundle], and makes t use
+the compiled templates from it instead of compiling them again. The compiled
+templates, which are not in the bundle, are kept in the previous
+[Gledki.CompiledStore]. Call it before [Gledki.Execute] and before creating
+views.
+*/
+func (t *Gledki) ReadBundle(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("bundle: %w", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("bundle: %w", err)
+	}
+	s := &bundleStore{compiled: make(map[string]string, len(zr.File)), next: t.store()}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if !fs.ValidPath(f.Name) {
+			return fmt.Errorf("bundle: invalid name '%s'", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+		text, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return fmt.Errorf("bundle: %w", err)
+		}
+		s.compiled[t.bundlePath(f.Name)] = string(text)
+	}
+	t.CompiledStore = s
+	return nil
+}
+
+// bundlePath returns the full path for the template name from a bundle – in
+// the first root, where the template file exists, or in the first root. The
+// templates from the bundle are found there by Gledki.findPath without
+// looking for their files.
+func (t *Gledki) bundlePath(name string) string {
+	name = filepath.FromSlash(name)
+	for _, root := range t.Roots {
+		if fullPath := filepath.Join(root, name); isReadable(fullPath) {
+			return fullPath
+		}
+	}
+	if len(t.Roots) == 0 {
+		return name
+	}
+	return filepath.Join(t.Roots[0], name)
+}
+
+// bundled reports whether the compiled template for fullPath is in a bundle,
+// read by Gledki.ReadBundle.
+func (t *Gledki) bundled(fullPath string) bool {
+	s, ok := t.CompiledStore.(*bundleStore)
+	for ok {
+		s.mu.RLock()
+		_, found := s.compiled[fullPath]
+		s.mu.RUnlock()
+		if found {
+			return true
+		}
+		s, ok = s.next.(*bundleStore)
+	}
+	return false
+}
+
+var (
+	bundlesMu sync.RWMutex
+	// name => bundle, see RegisterBundle
+	bundles = make(map[string]string)
+)
+
+// RegisterBundle makes the bundle, written by [Gledki.WriteBundle], available
+// under name for [Gledki.UseBundle]. It is called in the init function of the
+// Go files, generated by [Gledki.GenerateGo]. If RegisterBundle is called
+// twice with the same name, it panics.
+func RegisterBundle(name, bundle string) {
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	if _, dup := bundles[name]; dup {
+		panic("gledki: RegisterBundle called twice for bundle " + name)
+	}
+	bundles[name] = bundle
+}
+
+// UseBundle reads the bundle, registered under name with [RegisterBundle],
+// like [Gledki.ReadBundle] does. Every instance chooses the bundles it uses,
+// so the bundles of several packages do not mix.
+func (t *Gledki) UseBundle(name string) error {
+	bundlesMu.RLock()
+	bundle, ok := bundles[name]
+	bundlesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("bundle: '%s' is not registered", name)
+	}
+	return t.ReadBundle(strings.NewReader(bundle))
+}
+
+/*
+GenerateGo writes to w a Go file for the package pkg, which embeds the bundle
+of all templates (see [Gledki.WriteBundle]) and registers it under name with
+[RegisterBundle] at init. After [Gledki.UseBundle] with the name the compiled
+templates are served without any access to the file system and errors in the
+templates are caught at build time. The roots must exist, but may be empty.
+Use it with `go generate`:
+
+	//go:generate gledki gen --from ./templates --pkg views -o templates_gen.go
+
+and in the application:
+
+	tpls, err := gledki.New(roots, []string{".htm"}, tags, false)
+	…
+	err = tpls.UseBundle("views")
+*/
+func (t *Gledki) GenerateGo(w io.Writer, pkg, name string) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name '%s'", pkg)
+	}
+	if name == "" {
+		return errors.New("the name of the bundle is required")
+	}
+	var bundle bytes.Buffer
+	if err := t.WriteBundle(&bundle); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, `// Code generated by gledki gen. DO NOT EDIT.
+
+package %s
+
+import "github.com/kberov/gledki"
+
+func init() {
+	gledki.RegisterBundle(%q, bundle)
+}
+
+const bundle = %q
+`, pkg, name, bundle.Bytes())
+	return err
+}
+
+// bundleStore is the CompiledStore with the templates from a bundle. The
+// templates, which are not in the bundle, are kept in next.
+type bundleStore struct {
+	mu       sync.RWMutex
+	compiled map[string]string
+	next     CompiledStore
+}
+
+func (s *bundleStore) Get(key string) (string, bool) {
+	s.mu.RLock()
+	text, ok := s.compiled[key]
+	s.mu.RUnlock()
+	if ok {
+		return text, true
+	}
+	return s.next.Get(key)
+}
+
+func (s *bundleStore) Set(key, text string) error {
+	return s.next.Set(key, text)
+}
+
+// Delete drops the template from the bundle too, so it is compiled again
+// after [Gledki.Invalidate].
+func (s *bundleStore) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.compiled, key)
+	s.mu.Unlock()
+	return s.next.Delete(key)
+}
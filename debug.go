@@ -0,0 +1,56 @@
+package gledki
+
+import (
+	"html"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// debugging tells if the comments of Gledki.Debug are written around the
+// output of the template fullPath with the front matter meta – only if its
+// output mode is HTML, so JSON, text, etc. stay valid.
+func (t *Gledki) debugging(fullPath string, meta map[string]string) bool {
+	if !t.Debug {
+		return false
+	}
+	f := t.OutputModes[t.outputMode(fullPath, meta)]
+	return f != nil && reflect.ValueOf(f).Pointer() == reflect.ValueOf(html.EscapeString).Pointer()
+}
+
+// debugComments returns the HTML comments, written around the output of the
+// template fullPath if Gledki.Debug is set – `<!-- begin partials/_header
+// (theme) -->` and `<!-- end partials/_header -->`. The root is shown by its
+// name or by the name of its directory.
+func (t *Gledki) debugComments(fullPath string) (string, string) {
+	name, root := fullPath, ""
+	if rel, ok := strings.CutPrefix(fullPath, defaultsPrefix); ok {
+		name, root = rel, "defaults"
+	} else if rel, ok := strings.CutPrefix(fullPath, memoryPrefix); ok {
+		name, root = rel, "memory"
+	} else {
+		for _, r := range t.activeRoots() {
+			if rel, err := filepath.Rel(r, fullPath); err == nil && filepath.IsLocal(rel) {
+				name, root = rel, t.rootName(r)
+				break
+			}
+		}
+	}
+	name = html.EscapeString(filepath.ToSlash(t.trimExt(name)))
+	begin := "<!-- begin " + name
+	if root != "" {
+		begin += " (" + html.EscapeString(root) + ")"
+	}
+	return begin + " -->", "<!-- end " + name + " -->"
+}
+
+// rootName returns the name of root, given to it like "theme::./templates",
+// or the name of its directory.
+func (t *Gledki) rootName(root string) string {
+	for name, r := range t.namedRoots {
+		if r == root {
+			return name
+		}
+	}
+	return filepath.Base(root)
+}
@@ -0,0 +1,52 @@
+package gledki
+
+import "strings"
+
+// tagsPragma on the first line of a template sets the tags for it, like the
+// `tags` key in the front matter: "gledki:tags <% %>".
+const tagsPragma = "gledki:tags "
+
+// literalStartTag is the tag, which is replaced with Gledki.Tags[0]. It is put
+// in place of the literal start tags in templates with their own tags.
+const literalStartTag = "gledki:start-tag"
+
+// cutTagsPragma returns the tags from the pragma on the first line of text and
+// text without this line.
+func cutTagsPragma(text string) (string, string, bool) {
+	first, rest, _ := strings.Cut(text, "\n")
+	tags, ok := strings.CutPrefix(strings.TrimRight(first, "\r"), tagsPragma)
+	if !ok {
+		return "", text, false
+	}
+	return tags, rest, true
+}
+
+// convertTags returns text with the tags, set in its front matter, replaced
+// by Gledki.Tags, so the file is compiled and executed like all others. The
+// literal start tags of the instance in text are replaced with a tag, which
+// writes them, so `${}` in a template with tags `<% %>` is written as is.
+func (t *Gledki) convertTags(text string, meta map[string]string) string {
+	tags := strings.Fields(meta["tags"])
+	if len(tags) != 2 || tags[0] == t.Tags[0] && tags[1] == t.Tags[1] {
+		return text
+	}
+	literal := t.Tags[0] + literalStartTag + t.Tags[1]
+	var b strings.Builder
+	pos := 0
+	for {
+		i := strings.Index(text[pos:], tags[0])
+		if i < 0 {
+			break
+		}
+		start := pos + i + len(tags[0])
+		j := strings.Index(text[start:], tags[1])
+		if j < 0 {
+			break
+		}
+		b.WriteString(strings.ReplaceAll(text[pos:pos+i], t.Tags[0], literal))
+		b.WriteString(t.Tags[0] + text[start:start+j] + t.Tags[1])
+		pos = start + j + len(tags[1])
+	}
+	b.WriteString(strings.ReplaceAll(text[pos:], t.Tags[0], literal))
+	return b.String()
+}
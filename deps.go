@@ -0,0 +1,219 @@
+package gledki
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+)
+
+// executionKey is the key for the current execution in the context, passed to
+// TagFuncCtx values.
+type executionKey struct{}
+
+/*
+CompileContext is like [Gledki.Compile], but for use in a [TagFuncCtx] with the
+context it received. Partials, compiled this way, get the same caching,
+invalidation and strictness as the templates passed to [Gledki.Execute],
+because they are compiled the same way. In addition they are recorded as
+dependencies of the main template being executed, so they are listed by
+[Gledki.Dependencies] for it. Partials, compiled with [Gledki.Compile] in
+TagFuncs, are cached too, but are not recorded, because the main template is
+unknown.
+*/
+func (t *Gledki) CompileContext(ctx context.Context, path string) (string, error) {
+	c, err := t.compileMainContext(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if e, ok := ctx.Value(executionKey{}).(*execution); ok && e.path != c.path {
+		b := t.base()
+		b.mu.Lock()
+		if b.runtimeDeps == nil {
+			b.runtimeDeps = make(map[string]map[string]bool)
+		}
+		if b.runtimeDeps[e.path] == nil {
+			b.runtimeDeps[e.path] = make(map[string]bool)
+		}
+		b.runtimeDeps[e.path][c.path] = true
+		b.mu.Unlock()
+	}
+	return c.String(), nil
+}
+
+// Dependencies compiles (if needed) the template path and returns the sorted
+// full paths of all files it depends on – the included files, the wrappers
+// and the partials, compiled with [Gledki.CompileContext] during its
+// executions so far, with their dependencies. Use it to know which pages to
+// invalidate or purge from a CDN when a partial changes.
+func (t *Gledki) Dependencies(path string) ([]string, error) {
+	c, err := t.compileMain(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := t.activeRoots()
+	deps := make(map[string]bool)
+	var walkFile func(fullPath string) error
+	var walk func(c *compiledFile) error
+	walk = func(c *compiledFile) error {
+		if err := t.addWrappers(deps, roots, c.path, 0); err != nil {
+			return err
+		}
+		for _, s := range c.segments {
+			if s.file != nil && !s.file.placeholder() && !deps[s.file.path] {
+				deps[s.file.path] = true
+				if err := walk(s.file); err != nil {
+					return err
+				}
+			}
+		}
+		b := t.base()
+		b.mu.RLock()
+		runtime := slices.Collect(maps.Keys(b.runtimeDeps[c.path]))
+		b.mu.RUnlock()
+		for _, p := range runtime {
+			if err := walkFile(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	walkFile = func(fullPath string) error {
+		if deps[fullPath] {
+			return nil
+		}
+		deps[fullPath] = true
+		c, err := t.compile(context.Background(), roots, fullPath, 0)
+		if err != nil {
+			return err
+		}
+		return walk(c)
+	}
+	if err = walk(c); err != nil {
+		return nil, err
+	}
+	delete(deps, c.path)
+	return slices.Sorted(maps.Keys(deps)), nil
+}
+
+// addWrappers adds to deps the chain of wrappers of the file fullPath. The
+// wrappers are not referenced by the compiled templates, so they are found in
+// the source files.
+func (t *Gledki) addWrappers(deps map[string]bool, roots []string, fullPath string, depth int) error {
+	if depth > t.IncludeLimit {
+		return nil
+	}
+	text, err := t.loadFile(roots, fullPath)
+	if err != nil {
+		return err
+	}
+	for _, n := range t.Parse(t.trimMarkers(text)) {
+		if n.Kind != DirectiveNode || n.Name != "wrapper" {
+			continue
+		}
+		wrapper, err := t.findInRoots(roots, n.Arg)
+		if err != nil {
+			return err
+		}
+		deps[wrapper] = true
+		return t.addWrappers(deps, roots, wrapper, depth+1)
+	}
+	return nil
+}
+
+/*
+invalidate drops from memory the templates fullPaths and the compiled
+templates, which depend on them – include them, are wrapped by them or
+compiled them with CompileContext during their executions – and deletes the
+stored compiled templates of the dependents. The other compiled templates are
+kept. Returns the first error from the store.
+*/
+func (t *Gledki) invalidate(fullPaths ...string) error {
+	b := t.base()
+	b.wg.Wait()
+	dirty := make(map[string]bool, len(fullPaths))
+	for _, p := range fullPaths {
+		dirty[p] = true
+		b.files.delete(p)
+	}
+	b.mu.RLock()
+	compiled := slices.Collect(maps.Values(b.compiled))
+	runtime := make(map[string][]string, len(b.runtimeDeps))
+	for p, partials := range b.runtimeDeps {
+		runtime[p] = slices.Collect(maps.Keys(partials))
+	}
+	b.mu.RUnlock()
+	// The dependents of the dependents are found in the next rounds.
+	wrappers := make(map[*compiledFile]map[string]bool)
+	for found := true; found; {
+		found = false
+		for _, c := range compiled {
+			if !dirty[c.path] && t.dependsOn(c, dirty, runtime, wrappers) {
+				dirty[c.path], found = true, true
+			}
+		}
+	}
+	b.mu.Lock()
+	for _, m := range []compiledMap{b.compiled, b.evaluated} {
+		for key, c := range m {
+			if dirty[c.path] {
+				delete(m, key)
+			}
+		}
+	}
+	for p := range dirty {
+		delete(b.runtimeDeps, p)
+		delete(b.dynamicWrappers, p)
+	}
+	b.mu.Unlock()
+	var err error
+	for p := range dirty {
+		if f, ok := t.fragment(p); ok {
+			f.expire()
+		}
+		if !virtual(p) {
+			err = cmp.Or(err, t.store().Delete(p))
+		}
+	}
+	return err
+}
+
+// dependsOn returns true if c or a file it includes, is wrapped by or compiles
+// at runtime is dirty. wrappers caches the wrappers of the compiled files,
+// found in their sources. If they cannot be found, c is dirty too.
+func (t *Gledki) dependsOn(c *compiledFile, dirty map[string]bool, runtime map[string][]string,
+	wrappers map[*compiledFile]map[string]bool) bool {
+	if dirty[c.path] {
+		return true
+	}
+	for _, s := range c.segments {
+		if s.file != nil && t.dependsOn(s.file, dirty, runtime, wrappers) {
+			return true
+		}
+	}
+	if slices.ContainsFunc(runtime[c.path], func(p string) bool { return dirty[p] }) {
+		return true
+	}
+	if c.placeholder() {
+		return false
+	}
+	w, ok := wrappers[c]
+	if !ok {
+		w = make(map[string]bool)
+		// The templates without a source, like the ones put with
+		// Gledki.SetCompiled, have no wrappers.
+		if _, err := t.loadFile(c.roots, c.path); err == nil && t.addWrappers(w, c.roots, c.path, 0) != nil {
+			w = nil
+		}
+		wrappers[c] = w
+	}
+	if w == nil {
+		return true
+	}
+	for p := range w {
+		if dirty[p] {
+			return true
+		}
+	}
+	return false
+}
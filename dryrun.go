@@ -0,0 +1,116 @@
+package gledki
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// DryRun reports what [Gledki.Execute] would do. See [Gledki.ExecuteDryRun].
+type DryRun struct {
+	// Full path to the main template.
+	Path string
+	// Roots, the template is searched in.
+	Roots []string
+	// How the files were resolved, one step per line, indented by the depth of
+	// inclusion – e.g. "include partials/footer => /app/tpls/partials/footer.htm".
+	Trace []string
+	// The compiled template was found in memory.
+	Cached bool
+	// The compiled template was found on disk.
+	Stored bool
+	// Tags, which would be replaced. Sorted.
+	Replaced []string
+	// Tags without values, which would be replaced with nothing. Sorted.
+	Remaining []string
+}
+
+/*
+ExecuteDryRun reports what [Gledki.Execute] would do with the template path and
+data, looked up before the [Stash] – the resolution of the files, the cache
+decisions and which tags would be replaced and which would remain without
+value. Nothing is written and no TagFunc is called, so it is safe to use in
+admin tooling and for support debugging. The template is compiled and cached
+as by Execute. A tag is considered replaced, if it has a value, a constant, a
+prefix handler or it is a translation.
+*/
+func (t *Gledki) ExecuteDryRun(path string, data Stash) (*DryRun, error) {
+	roots := t.activeRoots()
+	fullPath := t.findPath(roots, path)
+	key, isDefault := t.cacheKey(roots, fullPath)
+	b := t.base()
+	b.mu.RLock()
+	_, cached := b.compiled[key]
+	b.mu.RUnlock()
+	r := &DryRun{Path: fullPath, Roots: roots, Cached: cached,
+		Stored: isDefault && t.CacheTemplates && t.isStored(fullPath)}
+	c, err := t.compile(context.Background(), roots, fullPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	step := "template " + path
+	if p, ok := t.alias(path); ok {
+		step += " (alias of " + p + ")"
+	}
+	t.trace(r, roots, c, step, 0)
+	replaced, remaining := make(map[string]bool), make(map[string]bool)
+	var walk func(nodes []Node)
+	walk = func(nodes []Node) {
+		for _, n := range nodes {
+			switch {
+			case n.Kind == DirectiveNode:
+				walk(n.Nodes)
+			case n.Kind == TagNode && t.resolvable(data, n.Text):
+				replaced[n.Text] = true
+			case n.Kind == TagNode:
+				remaining[n.Text] = true
+			}
+		}
+	}
+	walk(t.nodes(c))
+	r.Replaced = slices.Sorted(maps.Keys(replaced))
+	r.Remaining = slices.Sorted(maps.Keys(remaining))
+	return r, nil
+}
+
+// trace adds to r the resolution of c and its wrapper and included files.
+func (t *Gledki) trace(r *DryRun, roots []string, c *compiledFile, step string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	r.Trace = append(r.Trace, indent+step+" => "+c.path)
+	if text, err := t.loadFile(roots, c.path); err == nil {
+		for _, n := range t.Parse(text) {
+			if n.Kind == DirectiveNode && n.Name == "wrapper" {
+				r.Trace = append(r.Trace, indent+"  wrapper "+n.Arg+" => "+t.findPath(roots, n.Arg))
+			}
+		}
+	}
+	for _, s := range c.segments {
+		if s.file != nil {
+			t.trace(r, roots, s.file, strings.TrimSpace(strings.TrimSuffix(
+				strings.TrimPrefix(s.text, t.Tags[0]), t.Tags[1])), depth+1)
+		}
+	}
+}
+
+// resolvable tells if tag would be replaced with a value.
+func (t *Gledki) resolvable(data Stash, tag string) bool {
+	if key, pipe, ok := strings.Cut(tag, "|"); ok {
+		for _, name := range strings.Split(pipe, "|") {
+			if _, ok := t.filters[strings.TrimSpace(name)]; !ok {
+				return false
+			}
+		}
+		return t.resolvable(data, strings.TrimSpace(key))
+	}
+	if strings.HasPrefix(tag, "l10n ") && t.translations != nil || strings.HasPrefix(tag, "plural ") {
+		return true
+	}
+	if tag == literalStartTag {
+		return true
+	}
+	if _, ok := t.base().constants[tag]; ok {
+		return true
+	}
+	return t.lookup(&execution{data: data}, tag) != nil || t.prefixHandler(tag) != nil
+}
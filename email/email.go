@@ -0,0 +1,162 @@
+/*
+Package email renders emails from a pair of [gledki] templates – one for the
+HTML and one for the plain text version of the message, like `welcome.htm`
+and `welcome.txt`:
+
+	msg, err := email.Render(tpls, "welcome", gl.Stash{"name": "Ана"})
+
+The subject is taken from the front matter of the templates, if
+[gledki.Gledki.FrontMatter] is true,
+
+	---
+	subject: Welcome, ${name}!
+	---
+
+or from a comment like `${# subject: Welcome!}` in them – a comment cannot
+contain placeholders. The extension for the text template must be one of
+[gledki.Gledki.Ext].
+*/
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+
+	gl "github.com/kberov/gledki"
+)
+
+// HTMLExt is the extension of the templates for the HTML version of the
+// messages.
+var HTMLExt = ".htm"
+
+// TextExt is the extension of the templates for the plain text version of the
+// messages.
+var TextExt = ".txt"
+
+// Message is a rendered email.
+type Message struct {
+	// The subject with its placeholders replaced. It is not encoded – use
+	// [mime.QEncoding] for non-ASCII subjects in the headers.
+	Subject string
+	// The rendered templates. One of them is empty if its template does not
+	// exist.
+	Text, HTML string
+	// The value for the Content-Type header of the message, e.g.
+	// "multipart/alternative; boundary=…".
+	ContentType string
+	// The body of the message – a multipart/alternative body with the text
+	// and the HTML parts, encoded as quoted-printable.
+	Body []byte
+}
+
+// subjectKey is the key for the subject in the front matter and the prefix
+// of the comment with the subject.
+const subjectKey = "subject"
+
+// Render renders the templates name+HTMLExt and name+TextExt with data,
+// looked up before the [gledki.Stash], and returns the message. At least one
+// of the templates must exist. Placeholders in the subject are replaced with
+// the string values from data and the Stash.
+func Render(t *gl.Gledki, name string, data gl.Stash) (*Message, error) {
+	m := &Message{}
+	found := false
+	for _, part := range []struct {
+		path string
+		out  *string
+	}{{name + TextExt, &m.Text}, {name + HTMLExt, &m.HTML}} {
+		var b strings.Builder
+		_, err := t.ExecuteContext(gl.WithStash(context.Background(), data), &b, part.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("email %s: %w", part.path, err)
+		}
+		found = true
+		*part.out = b.String()
+		if m.Subject == "" {
+			if m.Subject, err = subject(t, part.path); err != nil {
+				return nil, fmt.Errorf("email %s: %w", part.path, err)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("email %s: no templates: %w", name, fs.ErrNotExist)
+	}
+	values := make(map[string]any, len(t.Stash)+len(data))
+	for _, stash := range []gl.Stash{t.Stash, data} {
+		for k, v := range stash {
+			if s, ok := v.(string); ok {
+				values[k] = s
+			}
+		}
+	}
+	m.Subject = strings.TrimSpace(t.FtExecStringStd(m.Subject, values))
+	return m, m.encode()
+}
+
+// subject returns the subject from the front matter of the template path or
+// from a `${# subject: …}` comment in it.
+func subject(t *gl.Gledki, path string) (string, error) {
+	meta, err := t.Meta(path)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := meta[subjectKey]; ok {
+		return s, nil
+	}
+	text, err := t.LoadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range t.Parse(text) {
+		if n.Kind != gl.CommentNode {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(n.Text, "#"), ":")
+		if ok && strings.TrimSpace(key) == subjectKey {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+// encode writes the parts of m to m.Body.
+func (m *Message) encode() error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct{ text, contentType string }{
+		{m.Text, "text/plain; charset=utf-8"}, {m.HTML, "text/html; charset=utf-8"},
+	} {
+		if part.text == "" {
+			continue
+		}
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err = qw.Write([]byte(part.text)); err != nil {
+			return err
+		}
+		if err = qw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	m.ContentType = "multipart/alternative; boundary=" + w.Boundary()
+	m.Body = body.Bytes()
+	return nil
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+)
+
+func newTemplates(t *testing.T, files map[string]string) *gl.Gledki {
+	root := t.TempDir()
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tpls, err := gl.New([]string{root}, []string{".htm", ".txt"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CacheTemplates = false
+	tpls.FrontMatter = true
+	return tpls
+}
+
+func TestRender(t *testing.T) {
+	tpls := newTemplates(t, map[string]string{
+		"welcome.htm": "---\nsubject: Welcome to ${site}, ${name}!\n---\n<p>Здравей, ${name}!</p>",
+		"welcome.txt": "Здравей, ${name}!",
+	})
+	tpls.Stash["site"] = "Гледки"
+	msg, err := Render(tpls, "welcome", gl.Stash{"name": "Ана"})
+	if err != nil {
+		t.Fatalf("Error Render: %s", err.Error())
+	}
+	if msg.Subject != "Welcome to Гледки, Ана!" {
+		t.Fatalf("Wrong subject: %s", msg.Subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.ContentType)
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Wrong Content-Type: %s", msg.ContentType)
+	}
+	r := multipart.NewReader(strings.NewReader(string(msg.Body)), params["boundary"])
+	var parts []string
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Wrong body: %s", err.Error())
+		}
+		data, _ := io.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Type")+": "+string(data))
+	}
+	expected := "text/plain; charset=utf-8: Здравей, Ана!|text/html; charset=utf-8: <p>Здравей, Ана!</p>"
+	if strings.Join(parts, "|") != expected {
+		t.Fatalf("\nexpected:%s\ngot:%s", expected, strings.Join(parts, "|"))
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	tpls := newTemplates(t, map[string]string{
+		"reset.txt": "${# subject: Reset your password}Open ${link}",
+	})
+	msg, err := Render(tpls, "reset", gl.Stash{"link": "https://example.com/r"})
+	if err != nil {
+		t.Fatalf("Error Render: %s", err.Error())
+	}
+	if msg.Subject != "Reset your password" || msg.Text != "Open https://example.com/r" ||
+		msg.HTML != "" || strings.Contains(string(msg.Body), "text/html") {
+		t.Fatalf("Wrong message: %+v", msg)
+	}
+	if _, err = Render(tpls, "none", nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected a missing file error, got: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package gledki
+
+import (
+	"encoding/json"
+	"html"
+	"path/filepath"
+	"text/template"
+)
+
+// Escaper escapes a value for the output format of a template.
+type Escaper func(string) string
+
+// Raw is a value for the [Stash], which is never escaped. Use it for trusted
+// HTML, JSON, etc. if [Gledki.AutoEscape] is set.
+type Raw string
+
+// OutputModes maps the extensions of the templates to the escapers for their
+// output format. A nil Escaper means no escaping. It is the default value for
+// [Gledki.OutputModes].
+var OutputModes = map[string]Escaper{
+	".htm":  html.EscapeString,
+	".html": html.EscapeString,
+	".xml":  html.EscapeString,
+	".json": EscapeJSON,
+	".js":   template.JSEscapeString,
+	".txt":  nil,
+}
+
+// EscapeJSON escapes s for use in a JSON string, between the quotes.
+func EscapeJSON(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+// escaper returns the Escaper for the output mode of the template c or nil.
+// The mode is taken from the front matter of the template or inferred from
+// its extension. If there is another extension before Gledki.Ext, it wins, so
+// "user.json.htm" is JSON, while "page.htm" is HTML.
+func (t *Gledki) escaper(c *compiledFile) Escaper {
+	if !t.AutoEscape {
+		return nil
+	}
+	return t.OutputModes[t.outputMode(c.path, c.meta)]
+}
+
+// outputMode returns the output mode of the template fullPath with the front
+// matter meta as an extension from Gledki.OutputModes. See Gledki.escaper.
+func (t *Gledki) outputMode(fullPath string, meta map[string]string) string {
+	if mode, ok := meta["mode"]; ok {
+		return mode
+	}
+	name := t.trimExt(fullPath)
+	if _, ok := t.OutputModes[filepath.Ext(name)]; ok && name != fullPath {
+		return filepath.Ext(name)
+	}
+	return filepath.Ext(fullPath)
+}
@@ -3,20 +3,19 @@ package gledki_test
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 
 	gl "github.com/kberov/gledki"
-	"github.com/labstack/gommon/log"
 )
 
 var Roots = []string{"testdata/tpls"}
-var filesExt = ".htm"
-var logger *log.Logger
+var filesExt = []string{".htm"}
 var tagsPair = [2]string{"${", "}"}
 
 //var out strings.Builder
 
-func Example_New() {
+func ExampleNew() {
 	tpls, err := gl.New(Roots, filesExt, tagsPair, false)
 	if err != nil {
 		fmt.Print("Error:", err.Error())
@@ -27,19 +26,19 @@ func Example_New() {
 	Ext: %#v
 	Tags: %#v
 	IncludeLimit: %d (default)
-	Logger: %T from "github.com/labstack/gommon/log"
+	Logger: %T (default)
 `, tpls.Stash, tpls.Ext,
 		tpls.Tags, tpls.IncludeLimit, tpls.Logger)
 	// Output:
 	// A gledki object properties:
 	//	Stash: gledki.Stash{}
-	//	Ext: ".htm"
+	//	Ext: []string{".htm"}
 	//	Tags: [2]string{"${", "}"}
 	//	IncludeLimit: 3 (default)
-	//	Logger: *log.Logger from "github.com/labstack/gommon/log"
+	//	Logger: *gledki.StdLogger (default)
 }
 
-func Example_New_err() {
+func ExampleNew_err() {
 	// New may return various errors
 	if _, err := gl.New([]string{"/ala/bala"}, filesExt, tagsPair, false); err != nil {
 		fmt.Println(err.Error())
@@ -56,7 +55,8 @@ func ExampleGledki_Execute_simple() {
 		fmt.Print("Error:", err.Error())
 		os.Exit(1)
 	}
-	tpls.Logger.SetLevel(log.DEBUG)
+	tpls.Logger = gl.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr,
+		&slog.HandlerOptions{Level: slog.LevelDebug})))
 	// …
 	// Later… many times and with various data (string, []byte, gledki.TagFunc)
 	tpls.Stash = map[string]any{"generator": "Гледки"}
@@ -65,8 +65,8 @@ func ExampleGledki_Execute_simple() {
 	tpls.MergeStash(gl.Stash{
 		"title": "Hello",
 		"body": gl.TagFunc(func(w io.Writer, tag string) (int, error) {
-			// tmpls.Stash entries and even the entire Stash can be modified
-			// from within tmpls.TagFunc
+			// tpls.Stash entries and even the entire Stash can be modified
+			// from within gl.TagFunc
 			tpls.Stash["generator"] = "Something"
 			return w.Write([]byte("<p>Some complex callculations to construct the body.</p>"))
 		}),
@@ -0,0 +1,49 @@
+package gledki
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Exclude is the default value for [Gledki.Exclude].
+var Exclude []string
+
+// excluded reports whether the file or directory name, relative to a root,
+// matches one of Gledki.Exclude.
+func (t *Gledki) excluded(name string) bool {
+	name = filepath.ToSlash(name)
+	if name == "." || name == "" {
+		return false
+	}
+	for _, pattern := range t.Exclude {
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches the segments of a slash-separated name against the
+// segments of a pattern. A "**" segment matches zero or more segments, the
+// others are matched with path.Match.
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
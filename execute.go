@@ -0,0 +1,405 @@
+package gledki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// execution holds the state of a single call to Gledki.Execute and friends.
+type execution struct {
+	ctx context.Context
+	// values for this execution only, looked up before the Stash
+	data Stash
+	// tags without values, collected only if not nil, see Gledki.SelfTest
+	unresolved *[]string
+	// how many bytes the filters added (or removed, if negative) to the output
+	filtered int64
+	// escapes the string values for the output mode, see Gledki.AutoEscape
+	escape Escaper
+	// full path to the main template, see Gledki.CompileContext
+	path string
+	// the scopes, pushed with PushStash
+	scopes []Stash
+}
+
+// stashKey is the key for the data in a context. See WithStash.
+type stashKey struct{}
+
+// WithStash returns a copy of ctx, carrying data for [Gledki.ExecuteContext].
+// data is looked up before the [Stash], so a middleware can put the values for
+// the request like the current user in the context, instead of in the shared
+// Stash. TagFuncCtx values get it back with [StashFromContext].
+func WithStash(ctx context.Context, data Stash) context.Context {
+	return context.WithValue(ctx, stashKey{}, data)
+}
+
+// StashFromContext returns the data, put in ctx by [WithStash], or nil.
+func StashFromContext(ctx context.Context) Stash {
+	data, _ := ctx.Value(stashKey{}).(Stash)
+	return data
+}
+
+// newExecution returns the execution state for ctx with the data from it.
+func newExecution(ctx context.Context) *execution {
+	return &execution{ctx: ctx, data: StashFromContext(ctx)}
+}
+
+// ExecuteWithRoots is like [Gledki.Execute], but for this call only the
+// templates are searched first in extraRoots and then in the usual roots.
+// Useful for plugins, supplying their own partials. data is looked up before
+// the [Stash] and may be nil. The compiled templates are cached separately for
+// every set of roots, so they are not reused under the wrong roots.
+func (t *Gledki) ExecuteWithRoots(w io.Writer, path string, data Stash, extraRoots ...string) (int64, error) {
+	return t.ExecuteWithRootsContext(context.Background(), w, path, data, extraRoots...)
+}
+
+// ExecuteWithRootsContext is like [Gledki.ExecuteWithRoots], but passes ctx
+// to the TagFuncCtx values. If data is nil, the data from ctx is used. See
+// [WithStash].
+func (t *Gledki) ExecuteWithRootsContext(ctx context.Context, w io.Writer, path string, data Stash,
+	extraRoots ...string) (int64, error) {
+	return t.withMiddlewares(ctx, w, path, func(ctx context.Context, w io.Writer, path string) (int64, error) {
+		return t.executeWithRoots(ctx, w, path, data, extraRoots)
+	})
+}
+
+// executeWithRoots is ExecuteWithRootsContext without the middlewares.
+func (t *Gledki) executeWithRoots(ctx context.Context, w io.Writer, path string, data Stash,
+	extraRoots []string) (n int64, err error) {
+	ctx, span := t.startSpan(ctx, SpanExecute)
+	if span != nil {
+		span.SetAttribute(AttrTemplate, path)
+		defer func() {
+			span.SetAttribute(AttrBytes, n)
+			endSpan(span, err)
+		}()
+	}
+	roots := make([]string, 0, len(extraRoots)+len(t.Roots))
+	for _, root := range extraRoots {
+		found, err := t.findRoot(root)
+		if err != nil {
+			return 0, err
+		}
+		roots = append(roots, found)
+	}
+	roots = append(roots, t.activeRoots()...)
+	t.refreshSources()
+	c, err := t.compile(ctx, roots, t.findPath(roots, path), 0)
+	if err != nil {
+		return 0, err
+	}
+	e := newExecution(ctx)
+	if data != nil {
+		e.data = data
+	}
+	return t.run(e, w, c)
+}
+
+// run executes the compiled main template c. Returns the number of bytes
+// actually written to w, whatever the TagFuncs report.
+func (t *Gledki) run(e *execution, w io.Writer, c *compiledFile) (int64, error) {
+	if len(t.base().constants) > 0 {
+		c = t.evaluate(c)
+	}
+	e.escape = t.escaper(c)
+	e.path = c.path
+	e.ctx = context.WithValue(e.ctx, executionKey{}, e)
+	fw := t.newFlushingWriter(w)
+	if fw != nil {
+		w = fw
+	}
+	cw := &countingWriter{w: w}
+	w = cw
+	var buf *bytes.Buffer
+	archiving := t.archiving()
+	shadow := t.shadowing()
+	if archiving || shadow != nil {
+		w, buf = teeWriter(w)
+	}
+	if t.Strict && e.unresolved == nil {
+		e.unresolved = new([]string)
+	}
+	start := time.Now()
+	_, err := t.execute(e, w, c)
+	if fw != nil && err == nil {
+		err = fw.Flush()
+	}
+	if t.Strict && err == nil {
+		err = unknownTags(c.path, *e.unresolved)
+	}
+	length := cw.n
+	elapsed := time.Since(start)
+	if t.Metrics != nil {
+		t.Metrics.ObserveExecute(c.path, elapsed, length, err)
+	}
+	if archiving && err == nil {
+		t.archive(e, c.path, buf.Bytes())
+	}
+	if shadow != nil && err == nil {
+		t.renderShadow(shadow, e, c.path, buf.Bytes(), elapsed)
+	}
+	return length, err
+}
+
+// lookup returns the value for tag from the data of the execution e, its
+// scopes, pushed with PushStash, from the last one, the Stash or the
+// DefaultStash. Dotted tags, which are not found as keys, are resolved in nested
+// values in the same order. e may be nil.
+func (t *Gledki) lookup(e *execution, tag string) any {
+	var data Stash
+	var scopes []Stash
+	if e != nil {
+		data, scopes = e.data, e.scopes
+	}
+	if v, ok := data[tag]; ok {
+		return v
+	}
+	for _, scope := range slices.Backward(scopes) {
+		if v, ok := scope[tag]; ok {
+			return v
+		}
+	}
+	if v, ok := t.Stash[tag]; ok {
+		return v
+	}
+	if v, ok := t.DefaultStash[tag]; ok {
+		return v
+	}
+	if v, ok := lookupPath(data, tag); ok {
+		return v
+	}
+	for _, scope := range slices.Backward(scopes) {
+		if v, ok := lookupPath(scope, tag); ok {
+			return v
+		}
+	}
+	if v, ok := lookupPath(t.Stash, tag); ok {
+		return v
+	}
+	v, _ := lookupPath(t.DefaultStash, tag)
+	return v
+}
+
+// execute writes the segments of c one by one to w, replacing the
+// placeholders with values from the Stash. Stops if the context is done.
+func (t *Gledki) execute(e *execution, w io.Writer, c *compiledFile) (int64, error) {
+	var length int64
+	tagFunc := t.stashTagFunc(e, c.path)
+	for _, s := range c.segments {
+		if err := e.ctx.Err(); err != nil {
+			return length, err
+		}
+		var n int64
+		var err error
+		if s.file == nil {
+			n, err = ftExecFunc(s.text, t.Tags[0], t.Tags[1], w, tagFunc)
+		} else if !s.file.placeholder() && t.debugging(s.file.path, s.file.meta) {
+			n, err = t.executeDebug(e, w, s.file)
+		} else {
+			n, err = t.executeIncluded(e, w, s.file)
+		}
+		length += n
+		if err != nil {
+			return length, err
+		}
+	}
+	return length, nil
+}
+
+// executeIncluded writes the output of the included file c – cached, if it is
+// a fragment, see Gledki.CacheFragment.
+func (t *Gledki) executeIncluded(e *execution, w io.Writer, c *compiledFile) (int64, error) {
+	if f, ok := t.fragment(c.path); ok {
+		return t.executeFragment(e, w, c, f)
+	}
+	return t.execute(e, w, c)
+}
+
+// executeDebug writes the output of the included file c between the comments,
+// marking its boundaries. See Gledki.Debug.
+func (t *Gledki) executeDebug(e *execution, w io.Writer, c *compiledFile) (int64, error) {
+	begin, end := t.debugComments(c.path)
+	n, err := io.WriteString(w, begin)
+	length := int64(n)
+	if err != nil {
+		return length, err
+	}
+	m, err := t.executeIncluded(e, w, c)
+	length += m
+	if err != nil {
+		return length, err
+	}
+	n, err = io.WriteString(w, end)
+	return length + int64(n), err
+}
+
+// executeFragment writes the cached output of c if it is still valid.
+// Otherwise renders c and caches the output.
+func (t *Gledki) executeFragment(e *execution, w io.Writer, c *compiledFile, f *fragment) (int64, error) {
+	f.mu.Lock()
+	output := f.output
+	if output == nil || f.ttl > 0 && time.Now().After(f.expires) {
+		var buf bytes.Buffer
+		filtered := e.filtered
+		if _, err := t.execute(e, &buf, c); err != nil {
+			f.mu.Unlock()
+			return 0, err
+		}
+		output = buf.Bytes()
+		f.output = output
+		f.filtered = e.filtered - filtered
+		f.expires = time.Now().Add(f.ttl)
+	} else {
+		e.filtered += f.filtered
+	}
+	f.mu.Unlock()
+	n, err := w.Write(output)
+	return int64(n), err
+}
+
+// stashTagFunc returns a TagFunc which writes the value for a tag from the
+// data for the execution or the Stash at the moment of its replacement, so
+// TagFuncs can modify the Stash for the tags which follow. Dotted tags, which
+// are not in the Stash, are resolved against nested maps and structs. Then a
+// prefix handler is tried, if one matches. Unknown tags are replaced with
+// nothing. Tags with a pipe like `${name | upper}` are passed through the
+// filters. `${l10n key}` tags are replaced with translations and
+// `${plural count item items}` with the form for the number. The size of
+// string and []byte values is checked against Gledki.MaxValueSize and they are
+// escaped if Gledki.AutoEscape is set. path is the template being executed and
+// is used only in error messages.
+func (t *Gledki) stashTagFunc(e *execution, path string) TagFunc {
+	var tagFunc TagFunc
+	tagFunc = func(w io.Writer, tag string) (n int, err error) {
+		if t.RecoverTagFuncs {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic in TagFunc for tag '%s' in %s: %v", tag, path, r)
+					t.Logger.Error(err)
+				}
+			}()
+		}
+		if tag == literalStartTag {
+			return io.WriteString(w, t.Tags[0])
+		}
+		if key, pipe, ok := strings.Cut(tag, "|"); ok {
+			return t.filter(e, w, tagFunc, strings.TrimSpace(key), pipe)
+		}
+		if key, ok := strings.CutPrefix(tag, "l10n "); ok && t.translations != nil {
+			return t.translate(e, w, tagFunc, strings.TrimSpace(key))
+		}
+		if args, ok := strings.CutPrefix(tag, "plural "); ok {
+			return t.plural(e, w, args)
+		}
+		v := t.lookup(e, tag)
+		switch v := v.(type) {
+		case nil:
+			if f := t.prefixHandler(tag); f != nil {
+				return f(e.ctx, w, tag)
+			}
+			if e.unresolved != nil {
+				*e.unresolved = append(*e.unresolved, tag)
+			}
+			return 0, nil
+		case []byte:
+			if err = t.checkSize(tag, path, len(v)); err != nil {
+				return 0, err
+			}
+			if e.escape != nil {
+				return w.Write([]byte(e.escape(string(v))))
+			}
+			return w.Write(v)
+		case string:
+			if err = t.checkSize(tag, path, len(v)); err != nil {
+				return 0, err
+			}
+			if e.escape != nil {
+				v = e.escape(v)
+			}
+			return w.Write([]byte(v))
+		case Raw:
+			if err = t.checkSize(tag, path, len(v)); err != nil {
+				return 0, err
+			}
+			return w.Write([]byte(v))
+		case TagFunc:
+			return v(w, tag)
+		case TagFuncCtx:
+			return v(e.ctx, w, tag)
+		default:
+			panic(spf("tag=%q contains unexpected value type=%#v. "+
+				"Expected []byte, string, Raw, TagFunc or TagFuncCtx", tag, v))
+		}
+	}
+	return tagFunc
+}
+
+// checkSize warns or returns an error if size exceeds t.MaxValueSize.
+func (t *Gledki) checkSize(tag, path string, size int) error {
+	if t.MaxValueSize <= 0 || size <= t.MaxValueSize {
+		return nil
+	}
+	err := fmt.Errorf("%w: %d bytes for tag '%s' in %s, limit is %d",
+		ErrValueTooLarge, size, tag, path, t.MaxValueSize)
+	if t.MaxValueSizeError {
+		return err
+	}
+	t.Logger.Warn(err)
+	return nil
+}
+
+// unknownTags returns an error, wrapping ErrUnknownTags and listing the unique
+// tags without values in the template path, or nil if there are none.
+func unknownTags(path string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+	return fmt.Errorf("%w in %s: %s", ErrUnknownTags, path, strings.Join(tags, ", "))
+}
+
+// RenderStats describes the output of [Gledki.ExecuteStats].
+type RenderStats struct {
+	// Full path to the main template.
+	Path string
+	// Bytes actually written to the writer, after the filters were applied.
+	// The same as the length, returned by [Gledki.Execute].
+	Written int64
+	// Bytes, which would be written without the filters.
+	Unfiltered int64
+}
+
+// ExecuteStats is like [Gledki.ExecuteContext], but returns the sizes of the
+// output before and after the filters. The sizes are counted even if the
+// execution stops with an error.
+func (t *Gledki) ExecuteStats(ctx context.Context, w io.Writer, path string) (*RenderStats, error) {
+	var stats *RenderStats
+	_, err := t.withMiddlewares(ctx, w, path, func(ctx context.Context, w io.Writer, path string) (int64, error) {
+		c, err := t.compileMain(path)
+		if err != nil {
+			return 0, err
+		}
+		e := newExecution(ctx)
+		n, err := t.run(e, w, c)
+		stats = &RenderStats{Path: c.path, Written: n, Unfiltered: n - e.filtered}
+		return n, err
+	})
+	return stats, err
+}
+
+// countingWriter counts the bytes, written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+/*
+Package fake generates plausible fake data for previewing [gledki] templates –
+names, dates, numbers and lorem ipsum text. The data is deterministic for a
+given seed, so previews and galleries look the same on every run.
+
+The kind of a value is inferred from the name of the placeholder:
+
+	tpls.Stash, _ = fake.ForTemplate(tpls, "book", 42)
+
+or declared explicitly with a schema:
+
+	stash := fake.FromSchema(fake.Schema{"book_author": fake.Name, "price": fake.Number}, 42)
+*/
+package fake
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+// Kind is the kind of a generated value.
+type Kind string
+
+// Kinds of values which can be generated.
+const (
+	Name      Kind = "name"
+	Email     Kind = "email"
+	URL       Kind = "url"
+	Date      Kind = "date"
+	Number    Kind = "number"
+	Lang      Kind = "lang"
+	Title     Kind = "title"
+	Paragraph Kind = "paragraph"
+	Words     Kind = "words"
+)
+
+// Schema maps placeholder names to the kinds of their values.
+type Schema map[string]Kind
+
+// kinds are guessed by these substrings of the placeholder names, checked in
+// this order.
+var guesses = []struct {
+	substr string
+	kind   Kind
+}{
+	{"email", Email}, {"url", URL}, {"href", URL}, {"link", URL},
+	{"date", Date}, {"time", Date}, {"year", Date},
+	{"name", Name}, {"author", Name}, {"user", Name},
+	{"price", Number}, {"count", Number}, {"isbn", Number}, {"id", Number},
+	{"lang", Lang}, {"title", Title},
+	{"body", Paragraph}, {"content", Paragraph}, {"text", Paragraph},
+	{"description", Paragraph},
+}
+
+var (
+	firstNames = []string{"Мария", "Иван", "Елена", "Георги", "Анна", "Петър", "Ana", "John", "Lucy", "Paul"}
+	lastNames  = []string{"Иванова", "Петров", "Георгиева", "Димитров", "Smith", "Brown", "Taylor"}
+	lorem      = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod " +
+		"tempor incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis nostrud " +
+		"exercitation ullamco laboris nisi aliquip ex ea commodo consequat")
+	langs = []string{"bg", "en"}
+	epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Guess returns the kind of value for a placeholder, guessed by its name.
+func Guess(name string) Kind {
+	lower := strings.ToLower(name)
+	for _, g := range guesses {
+		if strings.Contains(lower, g.substr) {
+			return g.kind
+		}
+	}
+	return Words
+}
+
+// Stash returns fake values for the given placeholder names with kinds,
+// guessed by [Guess].
+func Stash(names []string, seed uint64) gl.Stash {
+	schema := make(Schema, len(names))
+	for _, name := range names {
+		schema[name] = Guess(name)
+	}
+	return FromSchema(schema, seed)
+}
+
+// FromSchema returns fake values for the placeholders in schema. Every value
+// depends only on the seed and the name of the placeholder, so adding new
+// placeholders does not change the values of the others.
+func FromSchema(schema Schema, seed uint64) gl.Stash {
+	stash := make(gl.Stash, len(schema))
+	for name, kind := range schema {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		stash[name] = Value(kind, rand.New(rand.NewPCG(seed, h.Sum64())))
+	}
+	return stash
+}
+
+// ForTemplate returns fake values for all placeholders in the template path.
+// See [gledki.Gledki.Placeholders].
+func ForTemplate(t *gl.Gledki, path string, seed uint64) (gl.Stash, error) {
+	names, err := t.Placeholders(path)
+	if err != nil {
+		return nil, err
+	}
+	return Stash(names, seed), nil
+}
+
+// Value generates a single value of the given kind using r.
+func Value(kind Kind, r *rand.Rand) string {
+	switch kind {
+	case Name:
+		return pick(r, firstNames) + " " + pick(r, lastNames)
+	case Email:
+		return fmt.Sprintf("%s.%d@example.com", pick(r, lorem), r.IntN(1000))
+	case URL:
+		return fmt.Sprintf("https://example.com/%s/%s", pick(r, lorem), pick(r, lorem))
+	case Date:
+		return epoch.AddDate(0, 0, r.IntN(365*25)).Format(time.DateOnly)
+	case Number:
+		return fmt.Sprint(r.IntN(100000))
+	case Lang:
+		return pick(r, langs)
+	case Title:
+		return capitalize(words(r, 2+r.IntN(4)))
+	case Paragraph:
+		return capitalize(words(r, 20+r.IntN(30))) + "."
+	}
+	return words(r, 1+r.IntN(3))
+}
+
+func pick(r *rand.Rand, list []string) string {
+	return list[r.IntN(len(list))]
+}
+
+func words(r *rand.Rand, n int) string {
+	w := make([]string, n)
+	for i := range w {
+		w[i] = pick(r, lorem)
+	}
+	return strings.Join(w, " ")
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,59 @@
+package fake
+
+import (
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+)
+
+func TestGuess(t *testing.T) {
+	for name, kind := range map[string]Kind{
+		"book_author": Name, "user_email": Email, "created_at_date": Date,
+		"book_price": Number, "lang": Lang, "title": Title, "body": Paragraph,
+		"something": Words,
+	} {
+		if got := Guess(name); got != kind {
+			t.Errorf("%s: expected %s, got %s", name, kind, got)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	names := []string{"title", "body", "book_author", "lang", "book_price"}
+	first := Stash(names, 42)
+	again := Stash(append(names, "generator"), 42)
+	other := Stash(names, 43)
+	same := true
+	for _, name := range names {
+		if first[name] != again[name] {
+			t.Fatalf("%s: values for the same seed differ: %s, %s", name, first[name], again[name])
+		}
+		same = same && first[name] == other[name]
+	}
+	if same {
+		t.Fatal("values for different seeds should differ")
+	}
+}
+
+func TestForTemplate(t *testing.T) {
+	tpls, err := gl.New([]string{"../testdata/tpls"}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	stash, err := ForTemplate(tpls, "view", 1)
+	if err != nil {
+		t.Fatalf("Error ForTemplate: %s", err.Error())
+	}
+	tpls.Stash = stash
+	var out strings.Builder
+	if _, err = tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), stash["title"].(string)) {
+		t.Fatalf("Output should contain the fake title: %s", out.String())
+	}
+	if _, err = ForTemplate(tpls, "nosuchfile", 1); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+}
@@ -0,0 +1,50 @@
+package gledki
+
+import "io"
+
+// flushingWriter flushes the underlying writer every time at least every bytes
+// were written to it since the last flush. See Gledki.FlushEvery.
+type flushingWriter struct {
+	w       io.Writer
+	flush   func() error
+	every   int
+	pending int
+}
+
+// newFlushingWriter returns w wrapped in a flushingWriter if t.FlushEvery is
+// set and w can be flushed – it implements [net/http.Flusher] or has a
+// `Flush() error` method like [bufio.Writer]. Otherwise returns nil.
+func (t *Gledki) newFlushingWriter(w io.Writer) *flushingWriter {
+	if t.FlushEvery <= 0 {
+		return nil
+	}
+	fw := &flushingWriter{w: w, every: t.FlushEvery}
+	switch f := w.(type) {
+	case interface{ Flush() }:
+		fw.flush = func() error { f.Flush(); return nil }
+	case interface{ Flush() error }:
+		fw.flush = f.Flush
+	default:
+		return nil
+	}
+	return fw
+}
+
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.pending += n
+	if err == nil && f.pending >= f.every {
+		err = f.Flush()
+	}
+	return n, err
+}
+
+// Flush flushes the underlying writer if anything was written since the last
+// flush.
+func (f *flushingWriter) Flush() error {
+	if f.pending == 0 {
+		return nil
+	}
+	f.pending = 0
+	return f.flush()
+}
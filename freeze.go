@@ -0,0 +1,48 @@
+package gledki
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+// FreezeAfterStartup makes [New] call [Gledki.Freeze], if it is asked to load
+// the files. Use it in production to make the behavior of the application
+// immune to accidental edits of the templates on the host.
+var FreezeAfterStartup = false
+
+// ErrFrozen is returned or wrapped by the methods, which need to read template
+// files from disk or to drop them from memory, after [Gledki.Freeze].
+var ErrFrozen = errors.New("templates are frozen")
+
+/*
+Freeze loads all template files under the roots – including the conditional
+and the named ones – and in [Gledki.DefaultsFS]. From then on it rejects any
+further reads from the file system and the templates are served exclusively
+from memory. Compiled files are neither read from disk nor stored. Templates,
+compiled before Freeze, are kept. [Gledki.ClearCache] and [Gledki.Invalidate]
+return [ErrFrozen]. Freezing a view freezes the instance it was created from
+and all its views.
+*/
+func (t *Gledki) Freeze() error {
+	b := t.base()
+	roots := slices.Clone(b.Roots)
+	for _, r := range b.conditionalRoots {
+		roots = append(roots, r.path)
+	}
+	roots = append(roots, slices.Collect(maps.Values(b.namedRoots))...)
+	slices.Sort(roots)
+	if err := b.loadFiles(slices.Compact(roots)); err != nil {
+		return err
+	}
+	if err := b.loadDefaults(); err != nil {
+		return err
+	}
+	b.wg.Wait()
+	b.isFrozen.Store(true)
+	return nil
+}
+
+func (t *Gledki) frozen() bool {
+	return t.base().isFrozen.Load()
+}
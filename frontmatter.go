@@ -0,0 +1,142 @@
+package gledki
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FrontMatter is the default value for [Gledki.FrontMatter]. The front matter
+// is not read by default, so templates, which start with a literal "---" or
+// "+++" line – e.g. plain text emails – are not changed.
+var FrontMatter = false
+
+// Delimiters of the front matter: "---" for YAML and "+++" for TOML.
+var frontMatterDelims = []string{"---", "+++"}
+
+// cutFrontMatter returns the values from the front matter at the start of
+// text and text without it. The front matter is a block of lines like
+// `title: Home` (YAML) or `title = "Home"` (TOML) between two lines with only
+// "---" or "+++". Only flat keys with scalar values are supported. Lines
+// starting with "#" are comments. If there is no front matter, returns nil and
+// text.
+func cutFrontMatter(text string) (map[string]string, string) {
+	for _, delim := range frontMatterDelims {
+		rest, ok := cutLine(text, delim)
+		if !ok {
+			continue
+		}
+		meta := make(map[string]string)
+		for rest != "" {
+			if after, ok := cutLine(rest, delim); ok {
+				if len(meta) == 0 {
+					meta = nil
+				}
+				return meta, after
+			}
+			var line string
+			line, rest, _ = strings.Cut(rest, "\n")
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if delim == "+++" {
+				key, value, ok = strings.Cut(line, "=")
+			}
+			if ok {
+				value = strings.TrimSpace(value)
+				meta[strings.TrimSpace(key)] = strings.Trim(value, `"'`)
+			}
+		}
+		// no closing delimiter – not front matter
+		return nil, text
+	}
+	return nil, text
+}
+
+// formatFrontMatter returns meta as YAML front matter for text or "" if meta
+// is empty. It is put in the compiled files on disk, so the values are
+// available without reading the source files. If text itself starts like
+// front matter, an empty front matter is returned, so text is kept intact,
+// when the compiled file is read.
+func formatFrontMatter(meta map[string]string, text string) string {
+	if len(meta) == 0 {
+		if _, rest := cutFrontMatter(text); rest != text {
+			return "---\n---\n"
+		}
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, key := range slices.Sorted(maps.Keys(meta)) {
+		b.WriteString(key + ": " + meta[key] + "\n")
+	}
+	b.WriteString("---\n")
+	return b.String()
+}
+
+// cutLine returns text after its first line if the line is line.
+func cutLine(text, line string) (string, bool) {
+	first, rest, found := strings.Cut(text, "\n")
+	if strings.TrimRight(first, "\r") != line {
+		return text, false
+	}
+	if !found {
+		return "", true
+	}
+	return rest, true
+}
+
+/*
+Meta compiles (if needed) the template path and returns a copy of the values
+from its front matter – an optional block at the start of the file, which is
+removed from the output, if [Gledki.FrontMatter] is true:
+
+	---
+	title: Home
+	layout: layouts/site
+	mode: .txt
+	---
+	<p>${content}</p>
+
+The values are strings. Some keys are used by gledki itself:
+  - layout – the wrapper of the template, the same as `${wrapper layouts/site}`,
+    or the default for `${wrapper ${layout}}`, see [Gledki.Compile];
+  - mode – the output mode as an extension from [Gledki.OutputModes], used
+    instead of the extension of the file, see [Gledki.AutoEscape].
+  - tags – the tags for this template, separated by space, like `<% %>`, for
+    templates which contain literal `${}`, e.g. with client-side
+    templates. Without other front matter, the tags can be set with a
+    pragma on the first line: `gledki:tags <% %>`. The tags are converted to
+    [Gledki.Tags] during the compilation, so the included files and the
+    wrappers may use other tags.
+
+Returns nil if the template has no front matter.
+*/
+func (t *Gledki) Meta(path string) (map[string]string, error) {
+	c, err := t.compileMain(path)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(c.meta), nil
+}
+
+// frontMatter removes the front matter or the tags pragma from text and
+// returns it with the values from it. The tags of the template are converted
+// to Gledki.Tags. If there is a layout, a wrapper directive is put in its
+// place, unless the wrapper is taken from the Stash.
+func (t *Gledki) frontMatter(text string) (map[string]string, string) {
+	var meta map[string]string
+	if t.FrontMatter {
+		meta, text = cutFrontMatter(text)
+	}
+	if tags, rest, ok := cutTagsPragma(text); ok && meta == nil {
+		meta, text = map[string]string{"tags": tags}, rest
+	}
+	text = t.convertTags(text, meta)
+	if layout := meta["layout"]; layout != "" && !t.hasDynamicWrapper(text) {
+		text = t.Tags[0] + "wrapper " + layout + t.Tags[1] + "\n" + text
+	}
+	return meta, text
+}
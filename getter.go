@@ -0,0 +1,141 @@
+package gledki
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// TemplateGetter returns the templates from a database or another storage. See
+// [GetterFS].
+type TemplateGetter interface {
+	// GetTemplate returns the text of the template name – a slash-separated
+	// path with the extension, like "partials/footer.htm" – and its version,
+	// which changes every time the template is changed, e.g. a counter or the
+	// time of the last update in nanoseconds. It returns an error, wrapping
+	// fs.ErrNotExist, if there is no such template.
+	GetTemplate(name string) (text string, version int64, err error)
+}
+
+// TemplateGetterFunc is a function, used as a [TemplateGetter].
+type TemplateGetterFunc func(name string) (text string, version int64, err error)
+
+// GetTemplate calls f(name).
+func (f TemplateGetterFunc) GetTemplate(name string) (string, int64, error) {
+	return f(name)
+}
+
+/*
+GetterFS returns an fs.FS with the templates from g, to be used as
+[Gledki.DefaultsFS] – e.g. for templates, edited in a CMS and stored in
+Postgres or MySQL:
+
+	tpls.DefaultsFS = gledki.GetterFS(gledki.TemplateGetterFunc(
+		func(name string) (text string, version int64, err error) {
+			err = db.QueryRow("SELECT text, version FROM templates WHERE name = $1", name).
+				Scan(&text, &version)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fs.ErrNotExist
+			}
+			return text, version, err
+		}))
+	tpls.DefaultsTTL = 10 * time.Second
+
+[Gledki.Compile] and [Gledki.Execute] work as with files. The version of a
+template is reported as its modification time, so after DefaultsTTL (see also
+[Gledki.SetTTL]) the templates, whose version changed, are loaded and compiled
+again with the templates, which include or are wrapped by them. The other
+compiled templates are kept. A version of 0 means unknown – then the text is
+compared. The templates cannot be listed, so [Gledki.Freeze] does not load them
+in advance.
+*/
+func GetterFS(g TemplateGetter) fs.FS {
+	return getterFS{g}
+}
+
+// getterFS is the fs.FS, returned by GetterFS.
+type getterFS struct {
+	g TemplateGetter
+}
+
+// Open opens the template name. The root "." is an empty directory.
+func (f getterFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return TemplateFile(name, nil, time.Time{}), nil
+	}
+	text, version, err := f.g.GetTemplate(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	var modTime time.Time
+	if version != 0 {
+		modTime = time.Unix(0, version)
+	}
+	return TemplateFile(name, []byte(text), modTime), nil
+}
+
+// TemplateFile returns a read-only fs.File with the text data of the template
+// name – a slash-separated path – and its modification time for the file
+// systems, which cannot list the templates, like [GetterFS]. For the name "."
+// it returns an empty directory.
+func TemplateFile(name string, data []byte, modTime time.Time) fs.File {
+	if name == "." {
+		return &templateFile{info: templateInfo{name: ".", dir: true}}
+	}
+	return &templateFile{Reader: bytes.NewReader(data),
+		info: templateInfo{name: path.Base(name), size: int64(len(data)), modTime: modTime}}
+}
+
+// templateFile is an opened template or the root directory.
+type templateFile struct {
+	*bytes.Reader
+	info templateInfo
+}
+
+func (f *templateFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *templateFile) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *templateFile) Close() error { return nil }
+
+// ReadDir returns no entries – the templates cannot be listed.
+func (f *templateFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if n > 0 {
+		return nil, io.EOF
+	}
+	return nil, nil
+}
+
+// templateInfo describes a template or the root directory.
+type templateInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i templateInfo) Name() string       { return i.name }
+func (i templateInfo) Size() int64        { return i.size }
+func (i templateInfo) ModTime() time.Time { return i.modTime }
+func (i templateInfo) IsDir() bool        { return i.dir }
+func (i templateInfo) Sys() any           { return nil }
+
+func (i templateInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
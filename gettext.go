@@ -0,0 +1,357 @@
+package gledki
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// poEntry is a message from a .po file, being parsed.
+type poEntry struct {
+	ctxt, id string
+	strs     []string
+	fuzzy    bool
+}
+
+// loadPO parses the gettext catalog file and adds its messages to the
+// catalog for lang.
+func (t *Gledki) loadPO(lang, file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	c := t.catalog(lang)
+	var e poEntry
+	// the string, continued by lines, starting with a quote
+	var last *string
+	flush := func() error {
+		defer func() { e, last = poEntry{}, nil }()
+		if e.fuzzy || len(e.strs) == 0 {
+			return nil
+		}
+		if e.id == "" {
+			return c.parseHeader(e.strs[0])
+		}
+		key := e.id
+		if e.ctxt != "" {
+			key = e.ctxt + "." + e.id
+		}
+		if e.strs[0] != "" {
+			c.messages[key] = e.strs
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(fh)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		keyword, value, _ := strings.Cut(line, " ")
+		switch {
+		case line == "":
+			err = flush()
+		case strings.HasPrefix(line, "#,"):
+			e.fuzzy = e.fuzzy || strings.Contains(line, "fuzzy")
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, `"`):
+			if last == nil {
+				return fmt.Errorf("line %d: unexpected string", lineNo)
+			}
+			var s string
+			if s, err = strconv.Unquote(line); err == nil {
+				*last += s
+			}
+		case keyword == "msgctxt":
+			if len(e.strs) > 0 {
+				err = flush()
+			}
+			e.ctxt, err = unquotePO(value, err)
+			last = &e.ctxt
+		case keyword == "msgid":
+			if len(e.strs) > 0 {
+				err = flush()
+			}
+			e.id, err = unquotePO(value, err)
+			last = &e.id
+		case keyword == "msgid_plural":
+			// the plural form of the msgid is not needed for the lookup
+			var plural string
+			plural, err = unquotePO(value, err)
+			last = &plural
+		case keyword == "msgstr" || strings.HasPrefix(keyword, "msgstr["):
+			var s string
+			s, err = unquotePO(value, err)
+			e.strs = append(e.strs, s)
+			last = &e.strs[len(e.strs)-1]
+		default:
+			return fmt.Errorf("line %d: unknown keyword '%s'", lineNo, keyword)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func unquotePO(value string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return strconv.Unquote(value)
+}
+
+// loadMO parses the binary gettext catalog file and adds its messages to the
+// catalog for lang.
+func (t *Gledki) loadMO(lang, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if len(data) < 20 {
+		return errors.New("file is too short")
+	}
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(data) {
+	case 0x950412de:
+		order = binary.LittleEndian
+	case 0xde120495:
+		order = binary.BigEndian
+	default:
+		return errors.New("not a .mo file")
+	}
+	count := int(order.Uint32(data[8:]))
+	origTable, transTable := int(order.Uint32(data[12:])), int(order.Uint32(data[16:]))
+	str := func(table, i int) (string, error) {
+		pos := table + i*8
+		if pos+8 > len(data) {
+			return "", errors.New("corrupted file")
+		}
+		length, offset := int(order.Uint32(data[pos:])), int(order.Uint32(data[pos+4:]))
+		if offset+length > len(data) {
+			return "", errors.New("corrupted file")
+		}
+		return string(data[offset : offset+length]), nil
+	}
+	c := t.catalog(lang)
+	for i := 0; i < count; i++ {
+		orig, err := str(origTable, i)
+		if err != nil {
+			return err
+		}
+		trans, err := str(transTable, i)
+		if err != nil {
+			return err
+		}
+		if orig == "" {
+			if err = c.parseHeader(trans); err != nil {
+				return err
+			}
+			continue
+		}
+		// msgid\x00msgid_plural
+		key, _, _ := strings.Cut(orig, "\x00")
+		// msgctxt\x04msgid
+		if ctxt, id, ok := strings.Cut(key, "\x04"); ok {
+			key = ctxt + "." + id
+		}
+		c.messages[key] = strings.Split(trans, "\x00")
+	}
+	return nil
+}
+
+// parseHeader finds the Plural-Forms in the header of a catalog and sets the
+// plural rule of c.
+func (c *catalog) parseHeader(header string) error {
+	for _, line := range strings.Split(header, "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Plural-Forms") {
+			continue
+		}
+		_, expr, ok := strings.Cut(value, "plural=")
+		if !ok {
+			return fmt.Errorf("no plural expression in '%s'", line)
+		}
+		rule, err := parsePluralRule(strings.TrimSuffix(strings.TrimSpace(expr), ";"))
+		if err != nil {
+			return err
+		}
+		c.plural = rule
+	}
+	return nil
+}
+
+// pluralParser parses the C-like expression of a gettext plural rule, e.g.
+// "(n%10==1 && n%100!=11 ? 0 : n != 0 ? 1 : 2)", into a function.
+type pluralParser struct {
+	s   string
+	pos int
+}
+
+func parsePluralRule(expr string) (func(n int) int, error) {
+	p := &pluralParser{s: expr}
+	f, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected '%s' in plural rule '%s'", p.s[p.pos:], expr)
+	}
+	return f, nil
+}
+
+func (p *pluralParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept consumes the first of ops, found at the current position.
+func (p *pluralParser) accept(ops ...string) string {
+	p.skipSpaces()
+	for _, op := range ops {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *pluralParser) ternary() (func(int) int, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.accept("?") == "" {
+		return cond, err
+	}
+	yes, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept(":") == "" {
+		return nil, fmt.Errorf("missing ':' in plural rule '%s'", p.s)
+	}
+	no, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return func(n int) int {
+		if cond(n) != 0 {
+			return yes(n)
+		}
+		return no(n)
+	}, nil
+}
+
+// binary operators by increasing precedence
+var pluralOps = [][]string{
+	{"||"}, {"&&"}, {"==", "!="}, {"<=", ">=", "<", ">"}, {"+", "-"}, {"*", "/", "%"},
+}
+
+func (p *pluralParser) binary(level int) (func(int) int, error) {
+	if level == len(pluralOps) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.accept(pluralOps[level]...)
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = pluralOp(op, left, right)
+	}
+}
+
+func pluralOp(op string, l, r func(int) int) func(int) int {
+	b := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return func(n int) int { return b(l(n) != 0 || r(n) != 0) }
+	case "&&":
+		return func(n int) int { return b(l(n) != 0 && r(n) != 0) }
+	case "==":
+		return func(n int) int { return b(l(n) == r(n)) }
+	case "!=":
+		return func(n int) int { return b(l(n) != r(n)) }
+	case "<=":
+		return func(n int) int { return b(l(n) <= r(n)) }
+	case ">=":
+		return func(n int) int { return b(l(n) >= r(n)) }
+	case "<":
+		return func(n int) int { return b(l(n) < r(n)) }
+	case ">":
+		return func(n int) int { return b(l(n) > r(n)) }
+	case "+":
+		return func(n int) int { return l(n) + r(n) }
+	case "-":
+		return func(n int) int { return l(n) - r(n) }
+	case "*":
+		return func(n int) int { return l(n) * r(n) }
+	case "/":
+		return func(n int) int {
+			if d := r(n); d != 0 {
+				return l(n) / d
+			}
+			return 0
+		}
+	}
+	return func(n int) int {
+		if d := r(n); d != 0 {
+			return l(n) % d
+		}
+		return 0
+	}
+}
+
+func (p *pluralParser) unary() (func(int) int, error) {
+	if p.accept("!") != "" {
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n int) int {
+			if f(n) == 0 {
+				return 1
+			}
+			return 0
+		}, nil
+	}
+	if p.accept("(") != "" {
+		f, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(")") == "" {
+			return nil, fmt.Errorf("missing ')' in plural rule '%s'", p.s)
+		}
+		return f, nil
+	}
+	if p.accept("n") != "" {
+		return func(n int) int { return n }, nil
+	}
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, fmt.Errorf("unexpected '%s' in plural rule '%s'", p.s[p.pos:], p.s)
+	}
+	v, err := strconv.Atoi(p.s[start:p.pos])
+	return func(int) int { return v }, err
+}
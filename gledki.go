@@ -22,24 +22,35 @@ See the tests and sample templates for usage examples.
 package gledki
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
-	"regexp"
-	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/labstack/gommon/log"
 	"github.com/valyala/fasttemplate"
 )
 
 // TagFunc is an alias for [fasttemplate.TagFunc].
 type TagFunc = fasttemplate.TagFunc
 
+// TagFuncCtx is like [TagFunc], but receives also the context, passed to
+// [Gledki.ExecuteContext]. Use it for TagFuncs which query databases or call
+// other services and have to honor deadlines and cancellation. It can be used
+// as a value in the [Stash] only by [Gledki.Execute] and
+// [Gledki.ExecuteContext]. The values for the request, passed with
+// [WithStash], are available via [StashFromContext].
+type TagFuncCtx func(ctx context.Context, w io.Writer, tag string) (int, error)
+
 // path => slurped file content
 type filesMap map[string]string
 
@@ -48,45 +59,291 @@ type filesMap map[string]string
 //   - []byte - the fastest value type
 //   - string - convenient value type
 //   - TagFunc - flexible value type
+//   - TagFuncCtx - flexible value type, receiving a context.Context
+//   - Raw - a string, which is never escaped, see Gledki.AutoEscape
+//
+// Values of nested Stash, maps with string keys and exported fields of structs
+// are reachable from the templates via dotted tags like `${user.name}`.
 type Stash map[string]any
 
 // Gledki manages files and data for fasttemplate.
 type Gledki struct {
 	// A map for replacement into templates
 	Stash Stash
+	// Values for every execution like "generator", "site_name" or "year",
+	// looked up after the Stash, so they are overridden by it and by the data
+	// for the execution. Set it once, before the first [Gledki.Execute], and
+	// do not modify it later – it is shared by the views and the clones
+	// without copying. Default: nil.
+	DefaultStash Stash
 	// file name => file contents
-	files filesMap
+	files *fileCache
+	// the instance, this one is a view of, see Gledki.WithRoots
+	origin *Gledki
+	// guards compiled, evaluated, fragments and tagFragments
+	mu sync.RWMutex
 	// compiled templates
-	compiled filesMap
-	// File extension of the templates, for example: ".htm".
-	Ext string
+	compiled compiledMap
+	// compiled templates with constants already replaced
+	evaluated compiledMap
+	// rarely changing values, set via Gledki.SetConstants
+	constants Stash
+	// included files with cached output, see Gledki.CacheFragment
+	fragments map[string]*fragment
+	// key => cached output of a TagFunc, see Gledki.CachedTagFunc
+	tagFragments map[string]*fragment
+	// File extensions of the templates, for example: [".htm", ".html"]. A
+	// path without extension is searched with each of them in order, in every
+	// root. The first one is used for the files created by Gledki.
+	Ext []string
 	// Root folders, where template files reside, for example
 	// ["./templates","example.com","themeX"]. They will be wallked up in the
 	// order they are provided to find the template file, passed to
 	// [Gledki.Execute]. The first found is used.
 	Roots []string
+	// Where the relative roots, passed to [Gledki.WithRoots],
+	// [Gledki.AddRoot], etc. are searched. The roots, passed to [New], are
+	// searched with the global [RootResolution]. Default: [RootResolution].
+	RootResolution Resolution
+	// name => root, see New
+	namedRoots map[string]string
+	// roots, used only when their conditions are met, sorted by weight
+	conditionalRoots []conditionalRoot
+	// handlers for tags with a prefix, see Gledki.HandlePrefix
+	prefixHandlers []prefixHandler
+	// see Gledki.Use
+	middlewares []middleware
+	// see Gledki.Alias
+	aliases map[string]string
+	// name => text, see Gledki.AddString
+	memory map[string]string
+	// full path => `${wrapper ${key}}` in it, see Gledki.Compile
+	dynamicWrappers map[string]dynamicWrapper
+	// filters for tags with pipes, see Gledki.RegisterFilter
+	filters map[string]Filter
+	// see Gledki.LoadTranslations
+	translations translations
+	// language for the translations, see Gledki.SetLocale
+	locale string
 	// Pair of Tags, for example:  "${", "}".
 	Tags [2]string
+	// Set to true to read the front matter at the start of the templates. See
+	// [Gledki.Meta]. Default: [FrontMatter].
+	FrontMatter bool
+	// Suffix, appended to the extension of compiled templates. Default:
+	// [CompiledSuffix].
+	CompiledSuffix string
+	// Where the compiled templates are kept between the runs of the
+	// application. Default: nil – on disk, in CompiledDir or next to the
+	// templates.
+	CompiledStore CompiledStore
+	// The directory for the compiled templates. The compiled files of every
+	// root are kept in a subdirectory, named after the hash of the root, so
+	// the roots may be read-only – e.g. for a tool, installed in a read-only
+	// location. Default: [CompiledDir] or, if it is empty,
+	// [UserCompiledDir].
+	CompiledDir string
+	// Set to true to store the compiled templates next to the templates, with
+	// CompiledSuffix appended to their names, instead of in CompiledDir.
+	// Default: [CompiledNextToTemplates].
+	CompiledNextToTemplates bool
+	// see Gledki.compiledDir
+	userDirOnce sync.Once
+	userDir     string
+	// How many times the temporary file with a compiled template is renamed
+	// again, if renaming it to the compiled file on disk fails. Default:
+	// [RenameRetries].
+	RenameRetries int
+	// The biggest allowed difference between the clock of the application
+	// and the modification time of a file, written in a root, before
+	// [Gledki.Ready] reports a clock skew. Default: [MaxClockSkew].
+	MaxClockSkew time.Duration
+	// Set to false to disable caching of compiled templates both in memory and
+	// on disk. Default: [CacheTemplates].
+	CacheTemplates bool
+	// Set to true to recover from panics in TagFuncs. The panic is logged and
+	// returned by [Gledki.Execute] as an error with the tag name and the
+	// template path. Default: false.
+	RecoverTagFuncs bool
 	// How deeply files can be included into each other.
 	// Default: 3 starting from 0 in the main template.
 	IncludeLimit int
-	// To wait while the compiled template is being stored.
+	// The number of files, read concurrently when all templates are loaded by
+	// [Gledki.Freeze]. Default: [LoadWorkers].
+	LoadWorkers int
+	// Maximal size in bytes of a string or []byte value from the Stash. Larger
+	// values are still written, but a warning is logged. Use it to catch bugs
+	// in the data layer, like a blob stuffed into ${body}, before they blow up
+	// the size of the responses. Default: 0 - no limit.
+	MaxValueSize int
+	// Set to true to not write values, larger than MaxValueSize, and stop the
+	// execution with an error, wrapping [ErrValueTooLarge]. Default: false.
+	MaxValueSizeError bool
+	// Set to true to make [Gledki.Execute] return an error, wrapping
+	// [ErrUnknownTags] and listing all tags without values, instead of
+	// silently replacing them with nothing. The output is written anyway. Use
+	// it in tests to catch keys, a handler forgot to set. Default: false.
+	Strict bool
+	// To wait while the compiled templates are being stored. Only the one of
+	// the base instance is used. See Gledki.Close.
 	wg sync.WaitGroup
-	// Any logger defining Debug, Error, Info, Warn... See tmpls.Logger.
+	// the compiled templates, waiting to be stored, see Gledki.enqueueStore
+	stores storeQueue
+	// Where to store the rendered pages for audit. They are stored in
+	// goroutines, so the rendering is not slowed down. See
+	// [Gledki.WaitArchive]. Default: nil - no archiving.
+	Archive Archive
+	// Store only one of every ArchiveSample rendered pages. Default: 0 - every
+	// page.
+	ArchiveSample int
+	// Directory, where the snapshots, saved by [Gledki.SaveSnapshot], are
+	// stored.
+	SnapshotsDir string
+	// Version of the snapshot, the current templates are saved as. It is
+	// stored in the archived pages. See [Gledki.RenderAsOf].
+	Snapshot string
+	// see Gledki.Freeze
+	isFrozen atomic.Bool
+	// see Gledki.CacheHealth
+	health cacheHealth
+	// see Gledki.RootHealth
+	roots rootHealth
+	// warns once that the directory of the executable is not known, see
+	// Gledki.findRoot
+	binDirWarning sync.Once
+	// Set to true to keep serving the templates from the caches in memory if
+	// their root disappears at runtime, until it is back. Templates, which
+	// are not cached yet, cannot be loaded. Default: false – Gledki.Execute
+	// returns an error, wrapping [ErrRootMissing]. See [Gledki.RootHealth].
+	KeepServingOnRootLoss bool
+	// number of rendered pages, used for sampling
+	pages atomic.Uint64
+	// to wait while the rendered pages are being archived
+	archiveWG sync.WaitGroup
+	// see Gledki.StartShadow
+	shadow atomic.Pointer[shadowing]
+	// Templates, used only if they are not found in any of the Roots – the
+	// classic "default theme in the binary, customizations on disk"
+	// deployment. A file in a root overrides the file with the same relative
+	// path in DefaultsFS. The full paths of the default templates are
+	// prefixed with "defaults:", e.g. "defaults:partials/footer.htm". They are
+	// compiled only in memory. Example:
+	//
+	//	//go:embed templates
+	//	var templates embed.FS
+	//	t.DefaultsFS, err = fs.Sub(templates, "templates")
+	//
+	// Any fs.FS can be used, e.g. an afero.Fs, wrapped with afero.NewIOFS. If
+	// [New] is given no roots, all templates are read from DefaultsFS and
+	// nothing is written to disk. Default: nil.
+	DefaultsFS fs.FS
+	// After how long the templates from DefaultsFS are checked for changes –
+	// useful when DefaultsFS is backed by a database or a remote storage. See
+	// [Gledki.SetTTL]. Default: 0 - never.
+	DefaultsTTL time.Duration
+	// main template => partials, compiled during its execution, see
+	// Gledki.CompileContext
+	runtimeDeps map[string]map[string]bool
+	// see Gledki.SetTTL
+	sourcesMu sync.Mutex
+	sources   map[string]*source
+	ttls      map[string]time.Duration
+	// Called when a file in an `include` directive does not exist. See
+	// [MissingIncludeFunc]. Default: nil - the compilation fails.
+	OnMissingInclude MissingIncludeFunc
+	// Called when an optional include like `${include? partials/widget}`
+	// fails, e.g. the file is missing or cannot be compiled. The page is
+	// rendered with an HTML comment in place of the file. Default: nil - the
+	// error is logged as a warning.
+	OnIncludeError func(path string, err error)
+	// Called when a compiled template could not be stored on disk or in
+	// [Gledki.CompiledStore]. It is called in the goroutine, which stores the
+	// compiled templates, so it must be safe for concurrent use. Shared by all
+	// views. Default: nil - the error is only logged and reported by
+	// [Gledki.Ready] and [Gledki.CacheHealth].
+	OnStoreError func(fullPath string, err error)
+	// Returns the hash for fingerprints like [ArchivedPage.StashHash].
+	// Default: [Hasher].
+	Hasher func() hash.Hash
+	// Returns the keys for the cache of compiled templates. Shared by all
+	// views. Default: nil - [JoinedKey].
+	CacheKey CacheKeyFunc
+	// Set to true to minify the compiled templates with [MinifyHTML]. The
+	// compiled templates are cached, so this is done only once per file.
+	// Default: false.
+	Minify bool
+	// Set to true to write HTML comments like `<!-- begin partials/_header
+	// (theme) -->` and `<!-- end partials/_header -->` around the output of
+	// every included file and around the content of every wrapper, so one can
+	// find which file produced a piece of markup. The comments are written only
+	// for the templates in the HTML output mode, see [Gledki.OutputModes], so
+	// JSON, text, etc. stay valid. The templates, compiled in
+	// this mode, are cached only in memory. The comments around the wrapped
+	// content are removed by [Gledki.Minify]. For development only. Default:
+	// false.
+	Debug bool
+	// Set to true to escape the string and []byte values according to the
+	// output mode of the main template – HTML for ".htm", JSON for ".json",
+	// etc. The values are escaped after the filters. [Raw] values, TagFuncs,
+	// prefix handlers and translations are not escaped. See [OutputModes].
+	// Default: false.
+	AutoEscape bool
+	// Extension => Escaper. Default: [OutputModes].
+	OutputModes map[string]Escaper
+	// Called with the full path and the compiled text of every file, after
+	// the wrapper is applied and before the include directives are resolved.
+	// Returns the text to be cached and executed. Default: nil.
+	PostCompile func(fullPath, text string) string
+	// Number of bytes after which the output of [Gledki.Execute] is flushed,
+	// if the writer is an [net/http.Flusher] or has a `Flush() error` method.
+	// The output is written as the placeholders are replaced anyway, but the
+	// writers buffer it. Set it for very large pages like exports and
+	// reports to get the first bytes to the client sooner. The output is
+	// flushed also at the end. Default: 0 – never.
+	FlushEvery int
+	// Collects metrics about the compilation and the execution of the
+	// templates. See [Metrics]. Default: nil.
+	Metrics Metrics
+	// Starts the spans for the compilation and the execution of the
+	// templates, e.g. with OpenTelemetry. See [Tracer]. Default: nil.
+	Tracer Tracer
+	// Glob patterns for the files and directories, which are not loaded by
+	// [New] and [Gledki.Freeze] and not listed in bundles and by
+	// [Gledki.Shadows], for example: ["**/_drafts/**", "**/*.bak.htm"].
+	// The patterns are matched against the slash-separated paths, relative to
+	// the roots. "**" matches any number of directories. Excluded templates
+	// can still be executed and included, unless the templates are frozen.
+	// Default: [Exclude].
+	Exclude []string
+	// The wrappers, which may be chosen by a value from the Stash for a
+	// `${wrapper ${layout}}` directive, like "layouts/a". The layout from the
+	// front matter is always allowed. The compiled templates are cached for
+	// every wrapper, so the list keeps the cache bounded. Default: nil – only
+	// the layout from the front matter.
+	Layouts []string
+	// The logger for the warnings and errors. Default: a [StdLogger], writing
+	// to os.Stderr. See [NewSlogLogger] and [NewStdLogger].
 	Logger
-	// regex objects instantiated in New() and ready for use.
-	res map[string]*regexp.Regexp
 }
 
-const defaultLogHeader = `${prefix}:${time_rfc3339}:${level}:${short_file}:${line}`
-
-// CompiledSuffix is appended to the extension of compiled templates.
+// CompiledSuffix is appended to the extension of compiled templates. It is
+// the default value for [Gledki.CompiledSuffix].
 var CompiledSuffix = "c"
 
 var spf = fmt.Sprintf
 
+// ErrValueTooLarge is wrapped by the error, returned by [Gledki.Execute] when a
+// value from the Stash is larger than [Gledki.MaxValueSize] and
+// [Gledki.MaxValueSizeError] is set.
+var ErrValueTooLarge = errors.New("value too large")
+
+// ErrUnknownTags is wrapped by the error, returned by [Gledki.Execute] when
+// [Gledki.Strict] is set and some tags had no values.
+var ErrUnknownTags = errors.New("tags without values")
+
 // CacheTemplates can be set to false to disable caching of compiled templates
-// both in memory and on disk during development.
+// both in memory and on disk during development. It is the default value for
+// [Gledki.CacheTemplates].
 var CacheTemplates bool = true
 
 /*
@@ -94,35 +351,63 @@ New instantiates a new [Gledki] struct and returns a reference to it. Prepares
 [Stash] and loads all template files from disk under the given `roots` if
 `loadFiles` is true. Otherwise postpones the loading of the needed file until
 [Gledki.Compile] is invoked automatically in [Gledki.Execute].
+
+A root can be given a name like "theme::./templates/theme". A file in a named
+root can be included or used as a wrapper explicitly – `${include
+base::partials/_header}` uses the header from the root named "base", even if
+another root before it has one. The name can be used also in the paths, passed
+to [Gledki.Execute] and friends.
+
+ext must contain at least one extension like ".htm". Empty extensions are
+ignored.
 */
-func New(roots []string, ext string, tags [2]string, loadFiles bool) (*Gledki, error) {
+func New(roots []string, ext []string, tags [2]string, loadFiles bool) (*Gledki, error) {
+	ext = slices.DeleteFunc(slices.Clone(ext), func(e string) bool { return strings.TrimSpace(e) == "" })
+	if len(ext) == 0 {
+		return nil, errors.New("at least one extension of the template files is required")
+	}
 	t := &Gledki{
-		Stash:        make(Stash, 5),
-		compiled:     make(filesMap, 5),
-		files:        make(filesMap, 5),
-		Ext:          ext,
-		Tags:         tags,
-		IncludeLimit: 3,
-		Logger:       log.New("gledki"),
+		Stash:                   make(Stash, 5),
+		compiled:                make(compiledMap, 5),
+		evaluated:               make(compiledMap, 5),
+		fragments:               make(map[string]*fragment),
+		files:                   newFileCache(),
+		Ext:                     ext,
+		Tags:                    tags,
+		FrontMatter:             FrontMatter,
+		RootResolution:          RootResolution,
+		IncludeLimit:            3,
+		LoadWorkers:             LoadWorkers,
+		CompiledSuffix:          CompiledSuffix,
+		CompiledDir:             CompiledDir,
+		CompiledNextToTemplates: CompiledNextToTemplates,
+		RenameRetries:           RenameRetries,
+		MaxClockSkew:            MaxClockSkew,
+		CacheTemplates:          CacheTemplates,
+		Hasher:                  Hasher,
+		OutputModes:             maps.Clone(OutputModes),
+		Exclude:                 Exclude,
+		Logger:                  defaultLogger(),
 	}
 	if err := t.findRoots(roots); err != nil {
 		return nil, err
 	}
-	t.Logger.SetOutput(os.Stderr)
-	t.Logger.SetLevel(log.WARN)
-	t.Logger.SetHeader(defaultLogHeader)
 	if loadFiles {
-		if err := t.loadFiles(); err != nil {
+		if err := t.loadFiles(t.Roots); err != nil {
 			return nil, err
 		}
+		if FreezeAfterStartup {
+			if err := t.Freeze(); err != nil {
+				return nil, err
+			}
+		}
 	}
-	t.makeRegexes()
 	return t, nil
 }
 
 // Must is a convenient wrapper for [New], which returns only &Gledki or panics
 // in case of any error.
-func Must(roots []string, ext string, tags [2]string, loadFiles bool) *Gledki {
+func Must(roots []string, ext []string, tags [2]string, loadFiles bool) *Gledki {
 	gl, err := New(roots, ext, tags, loadFiles)
 	if err != nil {
 		panic(err.Error())
@@ -136,18 +421,45 @@ Compile composes a template and returns its content or an error. This means:
     [Gledki.Execute].
   - if the template contains `${wrapper some/file}`, the wrapper file is
     wrapped around it. Only one `wrapper` directive is allowed per file.
+    With `${wrapper ${layout}}` the path to the wrapper file is the value
+    for `layout` in the [Stash] or the layout from the front matter, so the
+    layout can be chosen at runtime, e.g. for A/B tests. A wrapper from the
+    Stash must be listed in [Gledki.Layouts], so the values from the
+    requests cannot choose any template. Such templates are compiled and
+    cached in memory for every wrapper. The wrapper file may have a wrapper
+    itself – up to Gledki.IncludeLimit wrappers.
+  - comments like `${# a note for the template authors}` are removed, so
+    they never reach the output like HTML comments do.
+  - the front matter is removed from the start of the file. See
+    [Gledki.Meta].
+  - the whitespace before a tag with a trim marker on the left like
+    `${- title}` and after a tag with a trim marker on the right like
+    `${include partials/footer -}` is removed, including the line breaks.
+    This is done before the wrapper is applied, so the markers work in all
+    directives and placeholders.
+  - the text is minified, if [Gledki.Minify] is set, and passed to
+    [Gledki.PostCompile], if it is not nil.
   - if the template contains any `${include some/file}` the files are
     loaded, wrapped (if there is a wrapper directive in them) and included
     at these places without rendering any placeholders. The inclusion
     is done recursively. See Gledki.IncludeLimit.
-  - The compiled template is stored in a private map[filename(string)]string,
-    attached to *Gledki for subsequent use during the same run of the
-    application. The content of the compiled template is stored on disk with a
-    suffix (see [CompiledSuffix]), attached to the extension of the file in the
-    same directory where the template file resides. The storing of the compiled
-    file is done concurently in a goroutine while being executed.
+    `${include? some/widget}` is an optional include – if the file cannot be
+    found or compiled, a comment is included instead and the error is
+    passed to [Gledki.OnIncludeError].
+  - The compiled template is stored in a private map, attached to *Gledki for
+    subsequent use during the same run of the application. Included files are
+    compiled separately and only referenced by the templates which include
+    them, so a partial, included in hundreds of pages, is kept only once in
+    memory. The content of the compiled template, with the include directives
+    kept in place, is stored on disk with a suffix (see
+    [Gledki.CompiledSuffix]), attached to the extension of the file, in
+    [Gledki.CompiledDir] or in the same directory where the template file
+    resides (see [Gledki.CompiledNextToTemplates]), or in
+    [Gledki.CompiledStore]. The storing of the
+    compiled file is done concurently in a goroutine while being executed.
   - On the next run of the application the compiled file is simply loaded
-    and its content retuned. All the steps above are skipped.
+    and only its include directives are resolved. All the steps above are
+    skipped.
 
 Panics in case the *Gledki.IncludeLimit is reached. If you have deeply nested
 included files you may need to set a bigger integer. This method is suitable
@@ -155,66 +467,247 @@ for use in a ft.TagFunc to preprare parts of the output to be replaced in the
 main template.
 */
 func (t *Gledki) Compile(path string) (string, error) {
-	path = t.toFullPath(path)
-	if text, e := t.loadCompiled(path); e == nil {
-		return text, nil
-	}
-	// t.Logger.Debugf("Compile('%s')", path)
-	text, err := t.LoadFile(path)
+	c, err := t.compileMain(path)
 	if err != nil {
 		return "", err
 	}
-	if text, err = t.wrap(text); err != nil {
-		return text, err
+	return c.String(), nil
+}
+
+// compileMain compiles the main template path with the roots, active for the
+// current Stash.
+func (t *Gledki) compileMain(path string) (*compiledFile, error) {
+	return t.compileMainContext(context.Background(), path)
+}
+
+// compileMainContext is compileMain with the context for the spans of
+// Gledki.Tracer.
+func (t *Gledki) compileMainContext(ctx context.Context, path string) (*compiledFile, error) {
+	t.refreshSources()
+	roots := t.activeRoots()
+	return t.compile(ctx, roots, t.findPath(roots, path), 0)
+}
+
+// compile returns the compiled template for fullPath. The included files and
+// wrappers are searched in roots. depth is the level of inclusion of the
+// template, starting from 0 in the main template. Only templates, compiled
+// with the default [Gledki.Roots], are stored on disk, because the result
+// depends on the roots.
+func (t *Gledki) compile(ctx context.Context, roots []string, fullPath string, depth int) (c *compiledFile, err error) {
+	ctx, span := t.startSpan(ctx, compileSpanName(depth))
+	if span != nil {
+		span.SetAttribute(AttrTemplate, fullPath)
+		defer func() { endSpan(span, err) }()
+	}
+	hit := false
+	if t.Metrics != nil && depth == 0 {
+		start := time.Now()
+		defer func() { t.Metrics.ObserveCompile(fullPath, time.Since(start), hit) }()
+	}
+	if err := t.checkRoot(roots, fullPath); err != nil {
+		return nil, err
+	}
+	b := t.base()
+	key, isDefault := t.keyFor(roots, fullPath)
+	baseKey := key
+	// Templates with a wrapper from the Stash are cached for every wrapper and
+	// only in memory.
+	if layout, ok, err := t.dynamicLayout(fullPath); ok {
+		if err != nil {
+			return nil, err
+		}
+		key, isDefault = baseKey+"\n"+layout, false
+	}
+	b.mu.RLock()
+	c, ok := b.compiled[key]
+	b.mu.RUnlock()
+	if span != nil {
+		span.SetAttribute(AttrCacheHit, ok)
+	}
+	if hit = ok; ok {
+		t.checkIncludeLimit(fullPath, depth+c.height)
+		return c, nil
+	}
+	t.checkIncludeLimit(fullPath, depth)
+	// Virtual templates have no files to store the compiled templates next to.
+	isDefault = isDefault && !virtual(fullPath)
+	text, err := "", errors.New("compiled with custom roots")
+	if isDefault {
+		text, err = t.loadCompiled(fullPath)
 	}
+	stored := err == nil
+	if span != nil {
+		span.SetAttribute(AttrStored, stored)
+	}
+	var meta map[string]string
+	if stored {
+		meta, text = cutFrontMatter(text)
+	} else {
+		var layout string
+		if meta, text, layout, err = t.compileSource(roots, fullPath); err != nil {
+			return nil, err
+		}
+		if layout != "" {
+			key, isDefault = baseKey+"\n"+layout, false
+		}
+	}
+	c = &compiledFile{path: fullPath, key: key, meta: meta, roots: roots}
+	if err = t.include(ctx, roots, c, text, depth); err != nil {
+		return nil, err
+	}
+	if t.CacheTemplates {
+		b.mu.Lock()
+		b.compiled[key] = c
+		b.mu.Unlock()
+		if !stored && isDefault && !t.frozen() {
+			t.enqueueStore(fullPath, formatFrontMatter(meta, text)+text)
+		}
+	}
+	return c, nil
+}
 
-	if text, err = t.include(text); err != nil {
-		return text, err
+// compileSource loads the template fullPath and returns its front matter and
+// its compiled text with the include directives in place – what is stored in
+// Gledki.CompiledStore. layout is the wrapper from the Stash if the template
+// has a `${wrapper ${key}}` directive.
+func (t *Gledki) compileSource(roots []string, fullPath string) (meta map[string]string,
+	text, layout string, err error) {
+	// t.Logger.Debugf("compile('%s')", fullPath)
+	if text, err = t.loadFile(roots, fullPath); err != nil {
+		return nil, "", "", err
 	}
-	if CacheTemplates {
-		t.compiled[path] = text
-		t.wg.Add(1)
-		go t.storeCompiled(path, t.compiled[path])
+	return t.compileText(roots, fullPath, text)
+}
+
+// compileText is like compileSource, but for the already loaded source of the
+// template fullPath.
+func (t *Gledki) compileText(roots []string, fullPath, source string) (meta map[string]string,
+	text, layout string, err error) {
+	meta, text = t.frontMatter(source)
+	if text, layout, _, err = t.resolveDynamicWrapper(fullPath, text, meta); err != nil {
+		return nil, "", "", err
 	}
-	return text, nil
+	text = t.trimMarkers(text)
+	if text, err = t.wrap(roots, fullPath, meta, text, 0); err != nil {
+		return nil, "", "", err
+	}
+	text = t.stripComments(text)
+	return meta, t.postCompile(fullPath, text), layout, nil
+}
+
+// Panics in case the t.IncludeLimit is reached.
+func (t *Gledki) checkIncludeLimit(fullPath string, depth int) {
+	if depth > t.IncludeLimit {
+		t.panicf("Limit of %d nested inclusions reached"+
+			" while trying to include %s", t.IncludeLimit, fullPath)
+	}
+}
+
+// panicf logs the formatted message as an error and panics with it.
+func (t *Gledki) panicf(format string, args ...any) {
+	msg := spf(format, args...)
+	t.Logger.Error(msg)
+	panic(msg)
 }
 
 func (t *Gledki) loadCompiled(fullPath string) (string, error) {
-	if text, ok := t.compiled[fullPath]; ok {
-		return text, nil
+	if !t.CacheTemplates {
+		return "", errors.New("caching of compiled templates is disabled")
+	}
+	if t.frozen() {
+		return "", ErrFrozen
 	}
 	// t.Logger.Debugf("loadCompiled('%s')", fullPath)
-	data, err := os.ReadFile(fullPath + CompiledSuffix)
-	if err != nil {
-		return "", fmt.Errorf("compiled file: %v", err)
+	text, ok := t.store().Get(fullPath)
+	if !ok {
+		return "", fmt.Errorf("compiled file: %s is not stored", fullPath)
 	}
-	t.compiled[fullPath] = string(data)
-	return t.compiled[fullPath], nil
+	return text, nil
 }
 
 func (t *Gledki) storeCompiled(fullPath, text string) {
-	defer t.wg.Done()
+	defer t.base().wg.Done()
 	// t.Logger.Debugf("storeCompiled('%s')", fullPath)
-	err := os.WriteFile(fullPath+CompiledSuffix, []byte(text), 0600)
+	err := t.store().Set(fullPath, text)
 	if err != nil {
-		t.Logger.Panic(err)
+		// Do not panic in a goroutine. See Gledki.Ready.
+		t.Logger.Error(err)
+		t.base().health.failed(err)
+		if f := t.base().OnStoreError; f != nil {
+			f(fullPath, err)
+		}
+		return
 	}
+	t.base().health.stored()
 }
 
-var ftExec = fasttemplate.Execute
+var ftExecFunc = fasttemplate.ExecuteFunc
 
 // Execute compiles (if needed) and executes the passed template using
 // [fasttemplate.Execute]. The path is resolved by prefixing the root folder
 // and attaching the extension, passed to [New], if the passed file is only a
 // base name. Example: `path := "view"` => `/home/user/app/templates/view.htm`.
+// Returns the number of bytes actually written to w, after the filters. See
+// [Gledki.ExecuteStats].
 func (t *Gledki) Execute(w io.Writer, path string) (int64, error) {
-	text, err := t.Compile(path)
+	return t.ExecuteContext(context.Background(), w, path)
+}
+
+// ExecuteContext is like [Gledki.Execute], but passes ctx to the [TagFuncCtx]
+// values in the [Stash] and the handlers, registered with
+// [Gledki.HandlePrefixCtx]. The data, put in ctx by [WithStash], is looked up
+// before the Stash. The execution stops with ctx.Err() if ctx is done before
+// all parts of the template are rendered. The middlewares, matching path, are
+// called around the execution. See [Gledki.Use].
+func (t *Gledki) ExecuteContext(ctx context.Context, w io.Writer, path string) (int64, error) {
+	return t.withMiddlewares(ctx, w, path, t.executeContext)
+}
+
+// executeContext is ExecuteContext without the middlewares.
+func (t *Gledki) executeContext(ctx context.Context, w io.Writer, path string) (n int64, err error) {
+	ctx, span := t.startSpan(ctx, SpanExecute)
+	if span != nil {
+		span.SetAttribute(AttrTemplate, path)
+		defer func() {
+			span.SetAttribute(AttrBytes, n)
+			endSpan(span, err)
+		}()
+	}
+	c, err := t.compileMainContext(ctx, path)
 	if err != nil {
 		return 0, err
 	}
-	length, err := ftExec(text, t.Tags[0], t.Tags[1], w, t.Stash)
-	t.wg.Wait()
-	return length, err
+	return t.run(newExecution(ctx), w, c)
+}
+
+/*
+SetConstants sets rarely changing values (site name, base URL…), which are
+replaced once in the compiled templates by [Gledki.Evaluate]. The result is
+kept in memory, so [Gledki.Execute] has to replace only the truly dynamic
+placeholders from the [Stash]. Constants take precedence over entries with the
+same keys in the Stash. Previously evaluated templates are dropped.
+*/
+func (t *Gledki) SetConstants(data Stash) {
+	t = t.base()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.constants = data
+	t.evaluated = make(compiledMap, 5)
+}
+
+// Evaluate compiles (if needed) the template and replaces in it the
+// placeholders, set via [Gledki.SetConstants]. All other placeholders are kept
+// untouched for [Gledki.Execute]. If no constants are set, it returns the same
+// as [Gledki.Compile].
+func (t *Gledki) Evaluate(path string) (string, error) {
+	c, err := t.compileMain(path)
+	if err != nil {
+		return "", err
+	}
+	if len(t.base().constants) == 0 {
+		return c.String(), nil
+	}
+	return t.evaluate(c).String(), nil
 }
 
 // FtExecStd is a wrapper around [fasttemplate.ExecuteStd]. Useful for preparing
@@ -239,36 +732,93 @@ func (t *Gledki) FtExecStringStd(template string, data Stash) string {
 	return fasttemplate.ExecuteStringStd(template, t.Tags[0], t.Tags[1], data)
 }
 
-func (t *Gledki) loadFiles() error {
-	for _, root := range t.Roots {
+// LoadWorkers is the number of files, read concurrently when all templates
+// are loaded by [New] and [Gledki.Freeze]. It is the default for
+// [Gledki.LoadWorkers].
+var LoadWorkers = 16
+
+// loadFiles reads all templates under roots with up to t.LoadWorkers
+// goroutines.
+// The roots are walked first, so an error while walking is returned before
+// any file is read. The errors while reading are joined.
+func (t *Gledki) loadFiles(roots []string) error {
+	var paths []string
+	for _, root := range roots {
 		if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-			if strings.HasSuffix(path, t.Ext) {
-				if _, err = t.LoadFile(path); err != nil {
-					return err
+			if rel, _ := filepath.Rel(root, path); t.excluded(rel) {
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
 				}
+				return nil
+			}
+			if err == nil && t.isSource(path) {
+				paths = append(paths, path)
 			}
 			return err
 		}); err != nil {
 			return err
 		}
 	}
-	return nil
+	jobs := make(chan string)
+	errs := make([]error, max(t.LoadWorkers, 1))
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if _, err := t.LoadFile(path); err != nil {
+					errs[i] = errors.Join(errs[i], err)
+				}
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+	return errors.Join(errs...)
 }
 
 // LoadFile is used to load a template from disk or from cache, if already
 // loaded before. Returns the template text or error if template cannot be
 // loaded.
 func (t *Gledki) LoadFile(path string) (string, error) {
-	path = t.toFullPath(path)
-	if text, ok := t.files[path]; ok && len(text) > 0 {
+	return t.loadFile(t.activeRoots(), path)
+}
+
+func (t *Gledki) loadFile(roots []string, path string) (string, error) {
+	path = t.findPath(roots, path)
+	if text, ok := t.memoryText(path); ok {
+		return text, nil
+	}
+	files := t.base().files
+	if text, ok := files.get(path); ok && len(text) > 0 {
 		return text, nil
 	}
-	data, err := os.ReadFile(path)
+	if t.frozen() {
+		return "", fmt.Errorf("%w: template file %s is not loaded", ErrFrozen, path)
+	}
+	var data []byte
+	var err error
+	if rel, ok := strings.CutPrefix(path, defaultsPrefix); ok && t.DefaultsFS != nil {
+		data, err = fs.ReadFile(t.DefaultsFS, rel)
+		if err == nil {
+			t.loaded(path)
+		}
+	} else if filepath.IsAbs(path) {
+		data, err = os.ReadFile(path)
+	} else {
+		// Found in no root - do not read it relative to the working directory.
+		err = &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("template file could not be read: %w", err)
 	}
-	t.files[path] = string(data)
-	return t.files[path], nil
+	text := string(data)
+	files.set(path, text)
+	return text, nil
 }
 
 /*
@@ -278,26 +828,202 @@ template file cannot be loaded.
 func (t *Gledki) MustLoadFile(path string) string {
 	partial, err := t.LoadFile(path)
 	if err != nil {
-		t.Logger.Panic(err)
+		t.panicf("%v", err)
 	}
 	return partial
 }
 
+// ClearCache drops all loaded and compiled templates from memory, so they will
+// be read again from disk on the next [Gledki.Compile]. If removeCompiled is
+// true, the compiled templates in [Gledki.CompiledDir] (or under
+// [Gledki.Roots] or in [Gledki.CompiledStore]) are deleted too. Returns the first error, which occurred while deleting files.
+func (t *Gledki) ClearCache(removeCompiled bool) error {
+	if t.frozen() {
+		return ErrFrozen
+	}
+	b := t.base()
+	b.wg.Wait()
+	b.files.clear()
+	b.mu.Lock()
+	b.compiled = make(compiledMap, 5)
+	b.evaluated = make(compiledMap, 5)
+	b.runtimeDeps = nil
+	b.dynamicWrappers = nil
+	b.mu.Unlock()
+	b.expireFragments()
+	if !removeCompiled {
+		return nil
+	}
+	return t.removeCompiled(t.Roots)
+}
+
+// removeCompiled deletes the compiled files, stored on disk under roots, or
+// the compiled templates for the files under roots from Gledki.CompiledDir or
+// Gledki.CompiledStore.
+func (t *Gledki) removeCompiled(roots []string) error {
+	nextToTemplates := t.CompiledStore == nil && t.compiledDir() == ""
+	for _, root := range slices.Compact(slices.Sorted(slices.Values(roots))) {
+		if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if nextToTemplates && t.isCompiled(path) {
+				err = os.Remove(path)
+			} else if !nextToTemplates && t.isSource(path) {
+				err = t.store().Delete(path)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate evicts a single template from memory and deletes its compiled
+// file from disk or from [Gledki.CompiledStore]. Templates, which include or are wrapped by it are not
+// touched - invalidate them too or use [Gledki.ClearCache].
+func (t *Gledki) Invalidate(path string) error {
+	if t.frozen() {
+		return ErrFrozen
+	}
+	t.base().wg.Wait()
+	path = t.toFullPath(path)
+	b := t.base()
+	b.files.delete(path)
+	b.mu.Lock()
+	for key, c := range b.compiled {
+		if c.path == path {
+			delete(b.compiled, key)
+			delete(b.evaluated, key)
+		}
+	}
+	delete(b.runtimeDeps, path)
+	delete(b.dynamicWrappers, path)
+	b.mu.Unlock()
+	if f, ok := t.fragment(path); ok {
+		f.expire()
+	}
+	return t.store().Delete(path)
+}
+
 // If the template is without extension, appends it. Then finds the first
-// matching file in the range of include paths and returns it.
+// matching file in the range of [Gledki.Roots] and returns it.
 func (t *Gledki) toFullPath(path string) string {
-	if !strings.HasSuffix(path, t.Ext) {
-		path = path + t.Ext
+	return t.findPath(t.Roots, path)
+}
+
+// Like toFullPath, but searches in the given roots. Aliases are resolved
+// first, see Gledki.Alias.
+func (t *Gledki) findPath(roots []string, path string) string {
+	if p, ok := t.alias(path); ok {
+		path = p
 	}
-	for _, root := range t.Roots {
-		foundPath := path
-		if !strings.HasPrefix(path, root) {
-			foundPath = filepath.Join(root, path)
+	if name, rel, ok := strings.Cut(path, namespaceSeparator); ok {
+		if root, ok := t.namedRoots[name]; ok {
+			roots, path = []string{root}, rel
 		}
-		if isReadable(foundPath) {
-			return foundPath
-		} else {
-			continue
+	}
+	paths := []string{path}
+	if !t.hasExt(path) {
+		paths = paths[:0]
+		for _, ext := range t.Ext {
+			paths = append(paths, path+ext)
+		}
+	}
+	for _, path := range paths {
+		if fullPath, ok := t.inMemory(path); ok {
+			return fullPath
+		}
+	}
+	for _, root := range roots {
+		for _, path := range paths {
+			foundPath := path
+			if !strings.HasPrefix(path, root) {
+				foundPath = filepath.Join(root, path)
+			}
+			if t.frozen() {
+				if _, ok := t.base().files.get(foundPath); ok {
+					return foundPath
+				}
+				continue
+			}
+			if t.bundled(foundPath) || isReadable(foundPath) {
+				return foundPath
+			}
+			// The file was loaded, but its root is gone. Do not fall through
+			// to another root, see Gledki.checkRoot.
+			if _, ok := t.base().files.get(foundPath); ok && !dirExists(root) {
+				return foundPath
+			}
+		}
+	}
+	for _, path := range paths {
+		if found := t.findDefault(path); found != path {
+			return found
+		}
+	}
+	return paths[0]
+}
+
+// hasExt reports whether path ends with one of Gledki.Ext.
+func (t *Gledki) hasExt(path string) bool {
+	for _, ext := range t.Ext {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCompiled reports whether path is a compiled template, stored on disk –
+// one of Gledki.Ext, followed by Gledki.CompiledSuffix.
+func (t *Gledki) isCompiled(path string) bool {
+	if t.CompiledSuffix == "" {
+		return false
+	}
+	for _, ext := range t.Ext {
+		if strings.HasSuffix(path, ext+t.CompiledSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSource reports whether path is a template file and not a compiled
+// template, even if the compiled templates end with one of Gledki.Ext too,
+// like "page.htm.tpl" for extensions ".htm" and ".tpl" and suffix ".tpl".
+func (t *Gledki) isSource(path string) bool {
+	return t.hasExt(path) && !t.isCompiled(path)
+}
+
+// withExt returns path with the first of Gledki.Ext appended, if it does not
+// end with one of them.
+func (t *Gledki) withExt(path string) string {
+	if t.hasExt(path) || len(t.Ext) == 0 {
+		return path
+	}
+	return path + t.Ext[0]
+}
+
+// withExtIn is like withExt, but appends the first of Gledki.Ext, with which
+// the file exists in dir.
+func (t *Gledki) withExtIn(dir, path string) string {
+	if !t.hasExt(path) {
+		for _, ext := range t.Ext {
+			if isReadable(filepath.Join(dir, path+ext)) {
+				return path + ext
+			}
+		}
+	}
+	return t.withExt(path)
+}
+
+// trimExt returns path without its extension if it is one of Gledki.Ext.
+func (t *Gledki) trimExt(path string) string {
+	for _, ext := range t.Ext {
+		if trimmed, ok := strings.CutSuffix(path, ext); ok {
+			return trimmed
 		}
 	}
 	return path
@@ -312,36 +1038,143 @@ func (t *Gledki) MergeStash(data Stash) {
 	}
 }
 
+// MergeStashDeep is like [Gledki.MergeStash], but nested Stash and
+// map[string]any values are merged recursively instead of overridden, so
+// layered defaults – site-wide, then section, then page – can be merged into
+// the Stash one after another. The nested values in the Stash are copied
+// before they are changed, so maps, shared with other Stashes, e.g. of
+// clones, are not modified.
+func (t *Gledki) MergeStashDeep(data Stash) {
+	for k, v := range data {
+		t.Stash[k] = mergeDeep(t.Stash[k], v)
+	}
+}
+
+// mergeDeep returns a copy of old with v merged into it, if both are Stash or
+// map[string]any values, or v otherwise.
+func mergeDeep(old, v any) any {
+	oldMap, ok := stashMap(old)
+	newMap, isMap := stashMap(v)
+	if !ok || !isMap {
+		return v
+	}
+	merged := maps.Clone(oldMap)
+	for k, item := range newMap {
+		merged[k] = mergeDeep(merged[k], item)
+	}
+	if _, ok := old.(Stash); ok {
+		return Stash(merged)
+	}
+	return merged
+}
+
+// stashMap returns v as a map if it is a Stash or map[string]any.
+func stashMap(v any) (map[string]any, bool) {
+	switch v := v.(type) {
+	case Stash:
+		return v, true
+	case map[string]any:
+		return v, true
+	}
+	return nil, false
+}
+
 // Tries to find existing absolute paths given the root paths. If the
 // provided roots are relative, the function expects the roots to be relative to
-// the Executable file or to the current working directory. If some of the
-// roots does not exist, this function returns an error.
+// the Executable file or to the current working directory, depending on
+// RootResolution. If some of the roots does not exist, this function returns
+// an error.
 func (t *Gledki) findRoots(roots []string) error {
 	for _, root := range roots {
-		if !filepath.IsAbs(root) {
-			byExe := filepath.Join(findBinDir(), root)
-			if dirExists(byExe) {
-				t.Roots = append(t.Roots, byExe)
-				continue
+		found, err := t.resolveRoot(root)
+		if err != nil {
+			return err
+		}
+		t.Roots = append(t.Roots, found)
+	}
+	return nil
+}
+
+// resolveRoot finds the path for root like findRoot. If root is prefixed by a
+// name like "theme::", the name is registered for the found path.
+func (t *Gledki) resolveRoot(root string) (string, error) {
+	name, dir, ok := strings.Cut(root, namespaceSeparator)
+	if !ok {
+		return t.findRoot(root)
+	}
+	found, err := t.findRoot(dir)
+	if err != nil {
+		return "", err
+	}
+	if t.namedRoots == nil {
+		t.namedRoots = make(map[string]string)
+	}
+	t.namedRoots[name] = found
+	return found, nil
+}
+
+// Separates the name of a root from a path in it.
+const namespaceSeparator = "::"
+
+// Resolution tells where the relative roots are searched. See
+// [Gledki.RootResolution].
+type Resolution int
+
+const (
+	// ResolveBoth searches the relative roots in the directory of the
+	// executable and then in the current working directory.
+	ResolveBoth Resolution = iota
+	// ResolveCwd searches the relative roots only in the current working
+	// directory – e.g. for `go test`, which builds the executable in a
+	// temporary directory.
+	ResolveCwd
+	// ResolveExe searches the relative roots only in the directory of the
+	// executable – e.g. for an application, which may be started from any
+	// directory.
+	ResolveExe
+)
+
+// RootResolution tells where [New] searches the relative roots and is the
+// default for [Gledki.RootResolution]. Absolute roots are used as they are. If
+// the directory of the executable is not known, e.g. for GOOS=js, the relative
+// roots are searched only in the current working directory with
+// [ResolveBoth] and cannot be found with [ResolveExe]. Default: [ResolveBoth].
+var RootResolution = ResolveBoth
+
+// Tries to find an existing absolute path for root. See Gledki.findRoots.
+func (t *Gledki) findRoot(root string) (string, error) {
+	if !filepath.IsAbs(root) {
+		var tried, binDir string
+		if t.RootResolution != ResolveCwd {
+			var err error
+			if binDir, err = findBinDir(); err != nil {
+				if t.RootResolution == ResolveExe {
+					return "", fmt.Errorf("gledki root directory '%s' cannot be searched next to the executable: %w", root, err)
+				}
+				t.base().binDirWarning.Do(func() {
+					t.Logger.Warnf("relative roots are searched only in the working directory: %v", err)
+				})
 			}
-			// Now try by CWD
-			byCwd, _ := filepath.Abs(root)
-			if dirExists(byCwd) {
-				t.Roots = append(t.Roots, byCwd)
-				continue
-			} else {
-				return fmt.Errorf("gledki root directory '%s' does not exist! You have to create it. ", byCwd)
+		}
+		if binDir != "" {
+			tried = filepath.Join(binDir, root)
+			if dirExists(tried) {
+				return tried, nil
 			}
 		}
-
-		if dirExists(root) {
-			t.Roots = append(t.Roots, root)
-			continue
-		} else {
-			return fmt.Errorf("Gledki root directory '%s' does not exist!", root)
+		if t.RootResolution != ResolveExe || binDir == "" {
+			// Now try by CWD
+			tried, _ = filepath.Abs(root)
+			if dirExists(tried) {
+				return tried, nil
+			}
 		}
+		return "", fmt.Errorf("gledki root directory '%s' does not exist! You have to create it. ", tried)
 	}
-	return nil
+	if dirExists(root) {
+		return root, nil
+	}
+	return "", fmt.Errorf("Gledki root directory '%s' does not exist!", root)
 }
 
 func dirExists(path string) bool {
@@ -361,117 +1194,121 @@ func isReadable(path string) bool {
 	return true
 }
 
-func findBinDir() string {
-	exe, err := os.Executable()
+// executable is os.Executable, replaced in tests.
+var executable = os.Executable
+
+// findBinDir returns the directory of the executable or an error if it is not
+// known, e.g. for GOOS=js.
+func findBinDir() (string, error) {
+	exe, err := executable()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return filepath.Dir(exe)
+	return filepath.Dir(exe), nil
 }
 
-// Replaces all occurances of `include path/to/template` in `text` with the
-// contents of the partial templates. Panics in case the t.IncludeLimit is
-// reached. If you have deeply nested included files you may need to set a
+// Splits text into segments at all occurances of `include path/to/template`
+// and appends them to c. The partial templates are compiled and referenced by
+// the segments in place of the directives. Panics in case the t.IncludeLimit
+// is reached. If you have deeply nested included files you may need to set a
 // bigger integer.
-func (t *Gledki) include(text string) (string, error) {
-	re := t.res["include"]
-	matches := re.FindAllStringSubmatch(text, -1)
-	howMany := len(matches)
-	if howMany > 0 {
-		// t.Logger.Debugf("include: %#v", matches)
-		stash := make(Stash, howMany)
-		for _, m := range matches {
-			if t.detectInludeRecursionLimit() {
-				t.Logger.Panicf("Limit of %d nested inclusions reached"+
-					" while trying to include %s", t.IncludeLimit, m[2])
-				//return text, nil
-			}
-			includedFileContent, err := t.LoadFile(m[2])
-			if err != nil {
-				t.Logger.Warnf("err:%s", err.Error())
-				return "", err
-			}
-			includedFileContent, err = t.wrap(strings.TrimSuffix(includedFileContent, "\n"))
-			if err != nil {
-				return "", err
-			}
-			stash[m[1]], err = t.include(includedFileContent)
-			if err != nil {
-				return "", err
-			}
+func (t *Gledki) include(ctx context.Context, roots []string, c *compiledFile, text string, depth int) error {
+	start := 0
+	for _, n := range t.Parse(text) {
+		if n.Kind != DirectiveNode || n.Name != "include" {
+			continue
+		}
+		// t.Logger.Debugf("include: %#v", n.Raw)
+		var included *compiledFile
+		fullPath, err := t.findInRoots(roots, n.Arg)
+		if err == nil && n.Optional {
+			included, err = t.compileOptional(ctx, roots, fullPath, depth+1)
+		} else if err == nil {
+			included, err = t.compile(ctx, roots, fullPath, depth+1)
 		}
-		// Replace ${include file/name.ext} with file content, but keep
-		// placeholders for the main Execute!
-		return t.FtExecStringStd(text, stash), nil
+		if err != nil && t.OnMissingInclude != nil && errors.Is(err, fs.ErrNotExist) {
+			included, err = t.missingInclude(fullPath, err)
+		}
+		if err != nil && n.Optional {
+			included, err = t.failedInclude(n.Arg, err)
+		}
+		if err != nil {
+			err = t.sourceError(roots, c.path, n.Raw, err)
+			t.Logger.Warnf("err:%s", err.Error())
+			return err
+		}
+		if n.Pos > start {
+			c.segments = append(c.segments, segment{text: text[start:n.Pos]})
+		}
+		c.segments = append(c.segments, segment{text: n.Raw, file: included})
+		c.height = max(c.height, included.height+1)
+		start = n.Pos + len(n.Raw)
 	}
-	return text, nil
+	if start < len(text) {
+		c.segments = append(c.segments, segment{text: text[start:]})
+	}
+	return nil
 }
 
 // If a template file contains `${wrap some/file}`, then `some/file` is loaded
 // and the content is put in it in place of `${content}`. This means that
 // `content` placeholder is special in wrapper templates and cannot be used as
 // a regular placeholder. Only one `wrapper` directive is allowed per file.
-// Returns the wrapped template text or the passed text with error.
-func (t *Gledki) wrap(text string) (string, error) {
+// Returns the wrapped template text or an error, also if the wrapper has no
+// `${content}` placeholder, because the text would be silently dropped. path
+// is the wrapped file and is used only in error messages. A wrapper may be
+// wrapped itself, so page → section layout → site layout chains are possible.
+// meta is the front matter of the file. depth is the number of wrappers around
+// the initial file so far. Panics in
+// case the t.IncludeLimit is reached, like for the nested inclusions.
+func (t *Gledki) wrap(roots []string, path string, meta map[string]string, text string, depth int) (string, error) {
 	text = strings.TrimSuffix(text, "\n")
-	re := t.res["wrap"]
-	// allow only one wrapper
-	match := re.FindStringSubmatch(text)
-	if len(match) > 0 {
-		// t.Logger.Debugf("wrapper: %#v", match)
-		wrapperFile, err := t.LoadFile(string(match[2]))
+	var wrappers []Node
+	for _, n := range t.Parse(text) {
+		if n.Kind == DirectiveNode && n.Name == "wrapper" {
+			wrappers = append(wrappers, n)
+		}
+	}
+	if len(wrappers) > 1 {
+		return "", t.sourceError(roots, path, wrappers[1].Raw,
+			fmt.Errorf("%d wrapper directives in %s – only one is allowed", len(wrappers), path))
+	}
+	for _, n := range wrappers {
+		// t.Logger.Debugf("wrapper: %#v", n.Raw)
+		fullPath, err := t.findInRoots(roots, n.Arg)
 		if err != nil {
+			return "", t.sourceError(roots, path, n.Raw, err)
+		}
+		if depth+1 > t.IncludeLimit {
+			t.panicf("Limit of %d nested wrappers reached"+
+				" while trying to wrap %s", t.IncludeLimit, path)
+		}
+		wrapperFile, err := t.loadFile(roots, fullPath)
+		if err != nil {
+			return "", t.sourceError(roots, path, n.Raw, err)
+		}
+		wrapperMeta, wrapperFile := t.frontMatter(wrapperFile)
+		wrapperFile = t.trimMarkers(wrapperFile)
+		if wrapperFile, err = t.wrap(roots, fullPath, wrapperMeta, wrapperFile, depth+1); err != nil {
 			return "", err
 		}
+		if !slices.ContainsFunc(t.Parse(wrapperFile), func(n Node) bool {
+			return n.Kind == TagNode && n.Text == "content"
+		}) {
+			return "", fmt.Errorf("wrapper %s has no %scontent%s placeholder",
+				t.findPath(roots, fullPath), t.Tags[0], t.Tags[1])
+		}
 		wrapperFile = strings.TrimSuffix(wrapperFile, "\n")
-		// remove the matched m[1] from text
-		text = strings.Replace(text, match[1], "", 1)
-		// replace content with text
-		text = t.FtExecStringStd(wrapperFile, map[string]any{"content": text})
+		// remove the directive and the line break after it from text
+		end := n.Pos + len(n.Raw)
+		end += len(text[end:]) - len(strings.TrimPrefix(strings.TrimPrefix(text[end:], "\r"), "\n"))
+		text = text[:n.Pos] + text[end:]
+		if t.debugging(path, meta) {
+			begin, end := t.debugComments(path)
+			text = begin + text + end
+		}
+		// replace content with text; allow only one wrapper
+		return t.FtExecStringStd(wrapperFile, map[string]any{"content": text}), nil
 	}
 	return text, nil
 }
-
-// frames = 1 : direct recursion - calls it self - fine.
-// frames < t.IncludeLimit : direct recursion - calls it self - still fine.
-// frames == t.IncludeLimit : indirect - some caller on t.IncludeLimit call
-// frame still calls the same function - too many recursion levels - stop.
-func (t *Gledki) detectInludeRecursionLimit() bool {
-	pcme, _, _, _ := runtime.Caller(1)
-	detailsme := runtime.FuncForPC(pcme)
-	pc, _, _, _ := runtime.Caller(1 + t.IncludeLimit)
-	details := runtime.FuncForPC(pc)
-	return (details != nil) && detailsme.Name() == details.Name()
-}
-
-// Make a map[names]*regexp.Regexp for internal use by directives'
-// implementations.
-func (t *Gledki) makeRegexes() {
-	t.res = make(map[string]*regexp.Regexp, 2)
-	t.res = map[string]*regexp.Regexp{
-		"wrap": regexp.MustCompile(spf(
-			`(?m:(\Q%s\Ewrapper\s+([/\.\-\w]+)\Q%s\E[\r]?[\n]?))`, t.Tags[0], t.Tags[1])),
-		"include": regexp.MustCompile(
-			spf(`\Q%s\E(include\s+([/\.\-\w]+))\Q%s\E`, t.Tags[0], t.Tags[1])),
-	}
-}
-
-// Logger is implemented by gommon/log on which we depend.
-type Logger interface {
-	Debug(args ...any)
-	Debugf(format string, args ...any)
-	DisableColor()
-	Error(args ...any)
-	Errorf(format string, args ...any)
-	Fatal(i ...any)
-	Fatalf(format string, args ...any)
-	Info(args ...any)
-	Infof(format string, args ...any)
-	Panic(i ...any)
-	Panicf(format string, args ...any)
-	SetHeader(h string)
-	SetLevel(v log.Lvl)
-	SetOutput(w io.Writer)
-	Warn(args ...any)
-	Warnf(format string, args ...any)
-}
@@ -1,27 +1,41 @@
 package gledki
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/fs"
+	"log"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
-
-	"github.com/labstack/gommon/log"
+	"testing/fstest"
+	"time"
 )
 
 var includePaths = []string{"./testdata/tpls", "./testdata/tpls/theme"}
-var filesExt = ".htm"
-var logger *log.Logger
+var filesExt = []string{".htm"}
+var logger Logger
 var tagsPair = [2]string{"${", "}"}
 var out strings.Builder
 
 // remove all compiled previously templates
 func init() {
-	sfx := filesExt + CompiledSuffix
+	sfx := filesExt[0] + CompiledSuffix
 	for _, dir := range includePaths {
 		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 			if strings.HasSuffix(path, sfx) {
@@ -30,11 +44,12 @@ func init() {
 			return err
 		})
 	}
+	// The tests check the compiled files next to the templates.
+	CompiledNextToTemplates = true
+	// The tests use front matter. See TestFrontMatterOff for the default.
+	FrontMatter = true
 	var lgbuf = bytes.NewBuffer([]byte(""))
-	logger = log.New("gledki")
-	logger.SetOutput(lgbuf)
-	logger.SetLevel(log.DEBUG)
-	logger.SetHeader(defaultLogHeader)
+	logger = NewStdLogger(log.New(lgbuf, "gledki: ", log.LstdFlags))
 }
 
 func TestNew(t *testing.T) {
@@ -45,7 +60,7 @@ func TestNew(t *testing.T) {
 	} else {
 		tpls.Logger = logger
 		t.Logf("\ngledki.New loads all files in %s", includePaths)
-		for k := range tpls.files {
+		for k := range tpls.files.files {
 			_ = k
 			//	t.Logf("file: %s", k)
 		}
@@ -56,12 +71,27 @@ func TestNew(t *testing.T) {
 	if err != nil {
 		t.Fatal("Eror New: ", err.Error())
 	}
-	if len(tpls.files) > 0 {
+	if tpls.files.len() > 0 {
 		t.Fatal("templates should not be loaded")
 	}
+	for _, ext := range [][]string{nil, {}, {"", " "}} {
+		if _, err = New(includePaths, ext, tagsPair, false); err == nil {
+			t.Fatalf("New should fail without extensions: %q", ext)
+		}
+	}
+	if tpls, err = New(includePaths, []string{"", ".htm"}, tagsPair, false); err != nil ||
+		!slices.Equal(tpls.Ext, []string{".htm"}) {
+		t.Fatalf("Empty extensions should be ignored: %q, %v", tpls.Ext, err)
+	}
+}
+
+func TestNewUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("running as root - every file is readable")
+	}
 	//Try to load nonreadable templates
 	os.Chmod(includePaths[0]+"/../tpls_bad/_noread.htm", 0300)
-	_, err = New([]string{includePaths[0] + "/../tpls_bad"}, filesExt, tagsPair, true)
+	_, err := New([]string{includePaths[0] + "/../tpls_bad"}, filesExt, tagsPair, true)
 	if err != nil {
 		t.Logf("Expected error from New: %s", err.Error())
 		os.Chmod(includePaths[0]+"/../tpls_bad/_noread.htm", 0400)
@@ -113,7 +143,7 @@ func TestExecute(t *testing.T) {
 	}
 
 	// Delete from t.compiled to load it from disk so this corner is covered too.
-	delete(tpls.compiled, tpls.toFullPath("view"))
+	delete(tpls.compiled, compiledKey(tpls, "view"))
 	out.Reset()
 	_, _ = tpls.Execute(&out, "view")
 	outstr = out.String()
@@ -225,172 +255,3724 @@ func TestAddExecuteFuncWithTheme(t *testing.T) {
 
 }
 
-func TestIncludeLimitPanic(t *testing.T) {
-	tpls, _ := New(includePaths, filesExt, tagsPair, false)
-	tpls.Stash = Stash{
-		"title":     "Possibly recursive inclusions",
-		"generator": "Gledki",
-		"included":  "included",
+func TestAddConditionalRoot(t *testing.T) {
+	tpls, _ := New(includePaths[:1], filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if err := tpls.AddConditionalRoot("/ala/bala", 1, nil); err == nil {
+		t.Fatal("No error - this is unexpected!")
 	}
-	level := 0
-	tpls.Stash["level"] = TagFunc(func(w io.Writer, tag string) (int, error) {
-		level++
-		return w.Write([]byte(spf("%d", level)))
+	err := tpls.AddConditionalRoot(includePaths[1], 1, func(stash Stash) bool {
+		return stash["lang"] == "bg"
 	})
-	var out strings.Builder
-	expectPanic(t, func() { _, _ = tpls.Execute(&out, "includes.htm") })
+	if err != nil {
+		t.Fatalf("Error AddConditionalRoot: %s", err.Error())
+	}
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	for _, lang := range []string{"bg", "en", "bg"} {
+		tpls.Stash["lang"] = lang
+		out.Reset()
+		if _, err = tpls.Execute(&out, "book"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		black := strings.Contains(out.String(), `<div class="black book">`) &&
+			strings.Contains(out.String(), `<title>black`)
+		if black != (lang == "bg") {
+			t.Fatalf("Theme should be used only for lang=bg. lang=%s:\n%s", lang, out.String())
+		}
+	}
+	// The default roots are used when the condition is not met.
+	tpls.Stash["lang"] = "en"
+	if len(tpls.activeRoots()) != 1 {
+		t.Fatalf("Wrong active roots: %v", tpls.activeRoots())
+	}
+	tpls.Stash["lang"] = "bg"
+	if roots := tpls.activeRoots(); len(roots) != 2 || !strings.HasSuffix(roots[0], "theme") {
+		t.Fatalf("Wrong active roots: %v", roots)
+	}
 }
 
-func TestOtherPanics(t *testing.T) {
+func TestExecuteWithRoots(t *testing.T) {
+	tpls, _ := New(includePaths[:1], filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	if _, err := tpls.ExecuteWithRoots(&out, "book", nil, "/ala/bala"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	for _, extra := range [][]string{{includePaths[1]}, nil, {includePaths[1]}} {
+		out.Reset()
+		_, err := tpls.ExecuteWithRoots(&out, "book", Stash{"title": "Заглавие"}, extra...)
+		if err != nil {
+			t.Fatalf("Error ExecuteWithRoots: %s", err.Error())
+		}
+		black := strings.Contains(out.String(), `<title>black Заглавие`)
+		if black != (len(extra) > 0) {
+			t.Fatalf("Extra roots should be used only when passed: %v\n%s", extra, out.String())
+		}
+	}
+	if tpls.Stash["title"] != data["title"] {
+		t.Fatal("The Stash should not be modified")
+	}
+	if len(tpls.compiled) < 2 {
+		t.Fatal("Templates should be cached separately for each set of roots")
+	}
+}
 
-	tpls, _ := New(includePaths, filesExt, tagsPair, false)
-	path := "/ff/a.htm"
-	tpls.compiled[path] = "bla"
-	tpls.wg.Add(1)
-	expectPanic(t, func() { tpls.storeCompiled(path, tpls.compiled[path]) })
-	expectPanic(t, func() { tpls.MustLoadFile(path) })
-	expectPanic(t, func() { Must([]string{"/aaa/bbb"}, filesExt, tagsPair, false) })
+func TestWithRoots(t *testing.T) {
+	tpls, _ := New(includePaths[:1], filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	if _, err := tpls.WithRoots([]string{"/ala/bala"}); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	black, _ := tpls.WithRoots([]string{includePaths[1], includePaths[0]})
+	black.Stash["title"] = "Заглавие"
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			view, theme := tpls, "<title>"+data["title"].(string)
+			if i%2 == 0 {
+				view, theme = black, "<title>black Заглавие"
+			}
+			var b bytes.Buffer
+			if _, err := view.Execute(&b, "book"); err != nil {
+				t.Errorf("Error executing Gledki.Execute: %s", err.Error())
+				return
+			}
+			if !strings.Contains(b.String(), theme) {
+				t.Errorf("output does not contain %s:\n%s", theme, b.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if tpls.Stash["title"] != data["title"] {
+		t.Fatal("The Stash should not be modified")
+	}
+	if black.files != nil || black.compiled != nil {
+		t.Fatal("Views should not have their own caches")
+	}
+	if len(tpls.compiled) < 4 {
+		t.Fatal("Templates should be cached separately for each set of roots")
+	}
+	_ = black.ClearCache(false)
+	if tpls.files.len() != 0 || len(tpls.compiled) != 0 {
+		t.Fatal("ClearCache on a view should clear the shared caches")
+	}
 }
 
-func TestIncludeLimitNoPanic(t *testing.T) {
-	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+type memArchive struct {
+	mu    sync.Mutex
+	pages []*ArchivedPage
+}
 
-	tpls.Stash = Stash{
-		"title":     "Possibly recursive inclusions",
-		"generator": "Gledki",
-		"included":  "included",
-	}
-	level := 0
-	tpls.Stash["level"] = TagFunc(func(w io.Writer, tag string) (int, error) {
-		level++
-		return w.Write([]byte(spf("%d", level)))
-	})
+func (a *memArchive) Store(page *ArchivedPage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pages = append(a.pages, page)
+	return nil
+}
 
-	tpls.IncludeLimit = 7
-	level = 0
-	out.Reset()
-	_, err := tpls.Execute(&out, "includes")
-	if err != nil {
-		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+func TestArchive(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	a := &memArchive{}
+	tpls.Archive = a
+	tpls.ArchiveSample = 2
+	var outputs []string
+	for i := range 4 {
+		tpls.Stash["title"] = spf("Заглавие %d", i%2)
+		out.Reset()
+		if _, err := tpls.Execute(&out, "view"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		outputs = append(outputs, out.String())
+	}
+	tpls.WaitArchive()
+	if len(a.pages) != 2 {
+		t.Fatalf("Every second page should be archived, got %d", len(a.pages))
+	}
+	for _, p := range a.pages {
+		if p.Path != tpls.toFullPath("view") || string(p.Output) != outputs[0] {
+			t.Fatalf("Wrong archived page: %#v", p)
+		}
+	}
+	if a.pages[0].StashHash != a.pages[1].StashHash || len(a.pages[0].StashHash) != 64 {
+		t.Fatal("Pages, rendered with the same data, should have the same hash")
+	}
+	tpls.Stash["title"] = "Друго"
+	if a.pages[0].StashHash == tpls.stashHash(&execution{}) {
+		t.Fatal("Different data should have a different hash")
 	}
-	outstr := out.String()
-	t.Log(outstr)
 
-	if !strings.Contains(outstr, "4 4") {
-		t.Fatalf("output does not contain expected value 4 4")
+	dir := t.TempDir()
+	tpls.Archive = DirArchive(dir)
+	tpls.ArchiveSample = 0
+	_, _ = tpls.Execute(&out, "view")
+	tpls.WaitArchive()
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("One page should be archived in %s, got %v", dir, files)
+	}
+	var page ArchivedPage
+	text, _ := os.ReadFile(files[0])
+	if err := json.Unmarshal(text, &page); err != nil || !strings.Contains(string(page.Output), "Друго") {
+		t.Fatalf("Wrong archived page in %s: %v", files[0], err)
 	}
 }
 
-func TestFtExecString(t *testing.T) {
+func TestStartShadow(t *testing.T) {
 	tpls, _ := New(includePaths, filesExt, tagsPair, false)
-	partial := `<div class="pager">${prev}${next}</div>`
-	out := tpls.FtExecString(partial, Stash{`prev`: `previous`})
-	if strings.Contains(out, "next") {
-		t.Fatal("String should not contain unused placeholder 'next'!")
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	if err := tpls.StartShadow(includePaths, 0, nil); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	var mu sync.Mutex
+	var diffs []*ShadowDiff
+	report := func(d *ShadowDiff) {
+		mu.Lock()
+		defer mu.Unlock()
+		diffs = append(diffs, d)
+	}
+	candidate := []string{includePaths[1], includePaths[0]}
+	if err := tpls.StartShadow(candidate, 100, report); err != nil {
+		t.Fatalf("Error StartShadow: %s", err.Error())
+	}
+	for range 2 {
+		out.Reset()
+		if _, err := tpls.Execute(&out, "view"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+	}
+	tpls.shadow.Load().wg.Wait()
+	if s := tpls.ShadowStats(); s.Rendered != 2 || s.Differed != 2 || s.Failed != 0 {
+		t.Fatalf("Wrong stats: %#v", s)
+	}
+	d := diffs[0]
+	if d.Equal() || d.Path != "view.htm" || string(d.Output) != out.String() ||
+		!strings.Contains(string(d.Candidate[d.Offset:]), "black") {
+		t.Fatalf("Wrong diff: %#v", d)
 	}
-}
 
-func TestErrors(t *testing.T) {
+	if err := tpls.StartShadow(includePaths, 50, nil); err != nil {
+		t.Fatalf("Error StartShadow: %s", err.Error())
+	}
+	for range 4 {
+		if _, err := tpls.Execute(&out, "view"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+	}
+	tpls.shadow.Load().wg.Wait()
+	if s := tpls.ShadowStats(); s.Rendered != 2 || s.Differed != 0 {
+		t.Fatalf("Half of the pages should be equal to the candidates: %#v", s)
+	}
+	// The candidate gets the data of the page, even if it is changed after
+	// the execution.
+	_ = tpls.StartShadow(includePaths, 100, nil)
+	page := Stash{"title": "Page"}
+	for range 4 {
+		if _, err := tpls.ExecuteWithRoots(&out, "view", page); err != nil {
+			t.Fatalf("Error executing Gledki.ExecuteWithRoots: %s", err.Error())
+		}
+		page["title"] = strconv.Itoa(out.Len())
+	}
+	tpls.shadow.Load().wg.Wait()
+	if s := tpls.ShadowStats(); s.Rendered != 4 || s.Differed != 0 {
+		t.Fatalf("The candidates should render the same data: %#v", s)
+	}
+	tpls.StopShadow()
+	if s := tpls.ShadowStats(); s != (ShadowStats{}) {
+		t.Fatalf("Stats after StopShadow: %#v", s)
+	}
+}
 
-	if _, err := New([]string{"/ala/bala/nica"}, filesExt, tagsPair, false); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "does not exist") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error: errstr")
+func TestRenderAsOf(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, text string) {
+		path = filepath.Join(root, path)
+		_ = os.MkdirAll(filepath.Dir(path), 0750)
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
 		}
-	} else {
-		t.Fatal("No error - this is unexpected!")
 	}
-	tpls, _ := New([]string{includePaths[0] + "/../tpls_bad"}, filesExt, tagsPair, false)
+	write("terms.htm", "${wrapper layout}Terms v1 for ${name}.")
+	write("layout.htm", "<main>${content}</main>")
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
 	tpls.Logger = logger
-	out.Reset()
-	if _, err := tpls.Execute(&out, "no_wrapper"); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "could not be read") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error: errstr")
+	defer tpls.wg.Wait()
+	if err := tpls.SaveSnapshot("v1"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	tpls.SnapshotsDir = filepath.Join(t.TempDir(), "snapshots")
+	for _, v := range []string{"", "../v1", ".."} {
+		if err := tpls.SaveSnapshot(v); err == nil {
+			t.Fatalf("No error for version '%s'", v)
 		}
-	} else {
+	}
+	if err := tpls.SaveSnapshot("v1"); err != nil {
+		t.Fatalf("Error SaveSnapshot: %s", err.Error())
+	}
+	if err := tpls.SaveSnapshot("v1"); err == nil {
+		t.Fatal("An existing snapshot should not be overwritten")
+	}
+	write("terms.htm", "${wrapper layout}Terms v2 for ${name}.")
+	_ = tpls.Invalidate("terms")
+	tpls.Stash["name"] = "Краси"
+	out.Reset()
+	_, _ = tpls.Execute(&out, "terms")
+	if out.String() != "<main>Terms v2 for Краси.</main>" {
+		t.Fatalf("Wrong current output: %s", out.String())
+	}
+	out.Reset()
+	_, err := tpls.RenderAsOf(&out, "terms", Stash{"name": "Иван"}, "v1")
+	if err != nil {
+		t.Fatalf("Error RenderAsOf: %s", err.Error())
+	}
+	if out.String() != "<main>Terms v1 for Иван.</main>" {
+		t.Fatalf("Wrong output as of v1: %s", out.String())
+	}
+	if _, err = tpls.RenderAsOf(&out, "terms", nil, "v0"); err == nil {
 		t.Fatal("No error - this is unexpected!")
 	}
+}
 
-	out.Reset()
-	if _, err := tpls.Execute(&out, "nosuchfile"); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "could not be read") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error: errstr")
+func TestAddRoot(t *testing.T) {
+	tpls, _ := New(includePaths[:1], filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	render := func() string {
+		out.Reset()
+		if _, err := tpls.Execute(&out, "book"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
 		}
-	} else {
+		tpls.wg.Wait()
+		return out.String()
+	}
+	if strings.Contains(render(), "<title>black") {
+		t.Fatal("The theme should not be used yet")
+	}
+	if err := tpls.AddRoot("/ala/bala", true); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err := tpls.AddRoot(includePaths[0], true); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err := tpls.AddRoot(includePaths[1], false); err != nil {
+		t.Fatalf("Error AddRoot: %s", err.Error())
+	}
+	if strings.Contains(render(), "<title>black") {
+		t.Fatal("An appended root should be searched last")
+	}
+	if err := tpls.SetRoots([]string{includePaths[1], "/ala/bala"}); err == nil {
 		t.Fatal("No error - this is unexpected!")
 	}
+	if len(tpls.Roots) != 2 {
+		t.Fatal("Roots should not be changed on error")
+	}
+	if err := tpls.SetRoots([]string{includePaths[1], includePaths[0]}); err != nil {
+		t.Fatalf("Error SetRoots: %s", err.Error())
+	}
+	if !strings.Contains(render(), "<title>black") {
+		t.Fatal("Stale template was served after SetRoots")
+	}
+	_ = tpls.SetRoots(includePaths[:1])
+	if err := tpls.AddRoot(includePaths[1], true); err != nil {
+		t.Fatalf("Error AddRoot: %s", err.Error())
+	}
+	if !strings.Contains(render(), "<title>black") {
+		t.Fatal("Stale template was served after AddRoot")
+	}
+	_ = tpls.ClearCache(true)
+}
 
-	out.Reset()
-	if _, err := tpls.Execute(&out, "no_include"); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "could not be read") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error:%s", errstr)
+func TestNamedRoots(t *testing.T) {
+	if _, err := New([]string{"theme::/ala/bala"}, filesExt, tagsPair, false); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	tpls, err := New([]string{"theme::" + includePaths[1], "base::" + includePaths[0]},
+		filesExt, tagsPair, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	// Only the named file is taken from the named root. Its wrapper and
+	// included files are still searched in all roots.
+	for path, black := range map[string]bool{"book": true, "base::book": false, "theme::book": true} {
+		out.Reset()
+		if _, err := tpls.Execute(&out, path); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		if strings.Contains(out.String(), `<div class="black book">`) != black {
+			t.Fatalf("Wrong root for %s:\n%s", path, out.String())
 		}
-	} else {
-		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
 	}
 	out.Reset()
-	if _, err := tpls.Execute(&out, "incl_no_wrapper.htm"); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "could not be read") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error:%s", errstr)
-		}
-	} else {
-		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	if _, err := tpls.Execute(&out, "namespaced"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	// The base layout wraps the theme book, wrapped by the theme layout.
+	outstr := out.String()
+	if strings.Index(outstr, "<title>"+data["title"].(string)) > strings.Index(outstr, "<title>black") ||
+		!strings.Contains(outstr, `<div class="black book">`) {
+		t.Fatalf("Wrong roots for explicit directives:\n%s", outstr)
 	}
-
 	out.Reset()
-	if _, err := tpls.Execute(&out, "incl_no_include.htm"); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "could not be read") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error:%s", errstr)
-		}
-	} else {
-		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	if _, err := tpls.Execute(&out, "nobody::book"); err == nil {
+		t.Fatal("No error for an unknown root name")
 	}
+	_ = tpls.ClearCache(true)
+}
 
-	absRoot, err := filepath.Abs(includePaths[0])
+func TestFreeze(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, text string) {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("page.htm", "${wrapper layout}Page v1")
+	write("layout.htm", "<main>${content}</main>")
+	FreezeAfterStartup = true
+	defer func() { FreezeAfterStartup = false }()
+	tpls, err := New([]string{root}, filesExt, tagsPair, true)
 	if err != nil {
-		t.Fatalf("Error finding absolute path: %s", err.Error())
+		t.Fatalf("Error New: %s", err.Error())
 	}
-	_ = tpls.findRoots([]string{absRoot})
-	if tpls.Roots[0] == absRoot {
-		t.Logf("Right root: %s", tpls.Roots)
-	} else {
-		t.Logf("Wrong root: Got: %s\n Expected: %s", tpls.Roots[0], absRoot)
+	tpls.Logger = logger
+	write("page.htm", "${wrapper layout}Page v2")
+	write("new.htm", "New page")
+	out.Reset()
+	if _, err = tpls.Execute(&out, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if out.String() != "<main>Page v1</main>" {
+		t.Fatalf("Frozen template should be served from memory: %s", out.String())
+	}
+	if _, err = tpls.Execute(&out, "new"); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Expected ErrFrozen, got: %v", err)
+	}
+	if err = tpls.ClearCache(false); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Expected ErrFrozen, got: %v", err)
+	}
+	if err = tpls.Invalidate("page"); !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Expected ErrFrozen, got: %v", err)
+	}
+	if isReadable(filepath.Join(root, "page.htm"+CompiledSuffix)) {
+		t.Fatal("Compiled files should not be stored when frozen")
 	}
+}
 
-	if err = tpls.findRoots([]string{"../ala/bala"}); err != nil {
-		errstr := err.Error()
-		if strings.Contains(errstr, "does not exist!") {
-			t.Logf("Right error: %s", err.Error())
-		} else {
-			t.Fatalf("Wrong error:%s", errstr)
-		}
+func TestDefaultsFS(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "layout.htm"),
+		[]byte("<custom>${content}</custom>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.DefaultsFS = fstest.MapFS{
+		"page.htm":            {Data: []byte("${wrapper layout}${include partials/footer}")},
+		"layout.htm":          {Data: []byte("<default>${content}</default>")},
+		"partials/footer.htm": {Data: []byte("<footer>${name}</footer>")},
+	}
+	tpls.Stash["name"] = "Краси"
+	out.Reset()
+	if _, err := tpls.Execute(&out, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if out.String() != "<custom><footer>Краси</footer></custom>" {
+		t.Fatalf("The file on disk should override the default one: %s", out.String())
+	}
+	tpls.wg.Wait()
+	if path := tpls.toFullPath("page"); path != "defaults:page.htm" || isReadable(path+CompiledSuffix) {
+		t.Fatalf("Wrong path or compiled default template stored on disk: %s", path)
+	}
+	if _, err := tpls.Execute(&out, "missing"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err := tpls.Freeze(); err != nil {
+		t.Fatalf("Error Freeze: %s", err.Error())
+	}
+	if _, ok := tpls.files.get("defaults:partials/footer.htm"); !ok {
+		t.Fatal("Freeze should load the default templates")
 	}
 }
 
-func expectPanic(t *testing.T, f func()) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("MISSING PANIC")
-		} else {
-			t.Log(r)
+func TestDefaultsTTL(t *testing.T) {
+	root := t.TempDir()
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defaults := fstest.MapFS{
+		"page.htm":   {Data: []byte("${wrapper layout}page")},
+		"layout.htm": {Data: []byte("<v1>${content}</v1>")},
+	}
+	tpls.DefaultsFS = defaults
+	execute := func() string {
+		var b strings.Builder
+		if _, err := tpls.Execute(&b, "page"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
 		}
-	}()
-	f()
+		return b.String()
+	}
+	if got := execute(); got != "<v1>page</v1>" {
+		t.Fatalf("Wrong output: %s", got)
+	}
+	defaults["layout.htm"] = &fstest.MapFile{Data: []byte("<v2>${content}</v2>")}
+	if got := execute(); got != "<v1>page</v1>" {
+		t.Fatal("Without a TTL the templates should not be checked")
+	}
+	tpls.SetTTL("layout", time.Nanosecond)
+	if got := execute(); got != "<v2>page</v2>" {
+		t.Fatalf("The changed wrapper should be used after its TTL: %s", got)
+	}
+	// modification times are compared, if DefaultsFS reports them
+	tpls.DefaultsTTL = time.Nanosecond
+	modTime := time.Now()
+	defaults["page.htm"] = &fstest.MapFile{Data: []byte("${wrapper layout}new"), ModTime: modTime}
+	_ = tpls.ClearCache(false)
+	_ = execute()
+	defaults["page.htm"].Data = []byte("${wrapper layout}same time")
+	if got := execute(); got != "<v2>new</v2>" {
+		t.Fatalf("The same modification time means no change: %s", got)
+	}
+	defaults["page.htm"].ModTime = modTime.Add(time.Second)
+	if got := execute(); got != "<v2>same time</v2>" {
+		t.Fatalf("A new modification time means a change: %s", got)
+	}
+	// Only the changed template and its dependents are compiled again.
+	defaults["other.htm"] = &fstest.MapFile{Data: []byte("other")}
+	_, _ = tpls.Execute(io.Discard, "other")
+	cached := func(path string) *compiledFile {
+		tpls.mu.RLock()
+		defer tpls.mu.RUnlock()
+		return tpls.compiled[compiledKey(tpls, path)]
+	}
+	other, page := cached("other"), cached("page")
+	defaults["layout.htm"] = &fstest.MapFile{Data: []byte("<v3>${content}</v3>")}
+	if got := execute(); got != "<v3>same time</v3>" {
+		t.Fatalf("The changed wrapper should be used after its TTL: %s", got)
+	}
+	if cached("other") != other || cached("page") == page {
+		t.Fatal("Only the wrapped page should be compiled again")
+	}
+	// Only a template, which does not exist anymore, is a change.
+	tpls.DefaultsFS = brokenFS{defaults}
+	if tpls.sourceChanged("defaults:layout.htm", &source{}) {
+		t.Fatal("The templates should be kept, while DefaultsFS fails")
+	}
+	tpls.DefaultsFS = defaults
+	delete(defaults, "layout.htm")
+	if _, err := tpls.Execute(io.Discard, "page"); err == nil {
+		t.Fatal("The removed wrapper should not be used")
+	}
+}
+
+// brokenFS is a DefaultsFS, whose storage cannot be reached.
+type brokenFS struct{ fs.FS }
+
+func (brokenFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("connection refused")}
+}
+
+func TestSelfTest(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	fixture := Stash{"other_books": "<li>Книга</li>", "a": "А", "b": "Б"}
+	for _, k := range []string{"title", "author", "isbn", "issuer", "price"} {
+		fixture["book_"+k] = k
+	}
+	if err := tpls.SelfTest(map[string]Stash{"view": nil, "book": fixture}); err != nil {
+		t.Fatalf("Error SelfTest: %s", err.Error())
+	}
+	err := tpls.SelfTest(map[string]Stash{"view": nil, "book": nil})
+	if err == nil || !strings.Contains(err.Error(), "'book'") ||
+		!strings.Contains(err.Error(), "other_books") {
+		t.Fatalf("Unresolved tags should be reported: %v", err)
+	}
+	if err = tpls.SelfTest(map[string]Stash{"missing": nil}); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	fixture["other_books"] = 1
+	if err = tpls.SelfTest(map[string]Stash{"book": fixture}); err == nil ||
+		!strings.Contains(err.Error(), "panic") {
+		t.Fatalf("Panics should be reported: %v", err)
+	}
+}
+
+func TestRootLoss(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "tpls")
+	_ = os.Mkdir(root, 0700)
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${title}</p>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	var logs bytes.Buffer
+	tpls.Logger = NewStdLogger(log.New(&logs, "", 0))
+	tpls.Stash["title"] = "Гледки"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	tpls.wg.Wait()
+	_ = os.Rename(root, root+".gone")
+	if _, err := tpls.Execute(&b, "page"); !errors.Is(err, ErrRootMissing) {
+		t.Fatalf("Expected ErrRootMissing, got: %v", err)
+	}
+	if err := tpls.Ready(); !errors.Is(err, ErrRootMissing) {
+		t.Fatalf("Ready should report the missing root, got: %v", err)
+	}
+	tpls.KeepServingOnRootLoss = true
+	b.Reset()
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<p>Гледки</p>" {
+		t.Fatalf("The cached template should be served: %q, %v", b.String(), err)
+	}
+	if h := tpls.RootHealth(); len(h.Missing) != 1 || h.Missing[0] != root || h.Losses != 1 ||
+		strings.Count(logs.String(), root) != 1 {
+		t.Fatalf("Wrong root health: %#v\n%s", h, logs.String())
+	}
+	_ = os.Rename(root+".gone", root)
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if h := tpls.RootHealth(); len(h.Missing) != 0 || h.Losses != 1 {
+		t.Fatalf("The root should be back: %#v", h)
+	}
+}
+
+func TestOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tpls")
+	for path, text := range map[string]string{
+		"secret.htm":           "secret",
+		"tpls_x/secret.htm":    "secret",
+		"tpls/up.htm":          "${include ../secret}",
+		"tpls/wrapped.htm":     "${wrapper ../secret}",
+		"tpls/prefix.htm":      "${include " + root + "_x/secret}",
+		"tpls/missing.htm":     "${include ../../missing}",
+		"tpls/ok.htm":          "${include partials/ok}",
+		"tpls/partials/ok.htm": "${include partials/../inside}",
+		"tpls/inside.htm":      "inside",
+		"tpls/cwd.htm":         "${include secret}",
+		"tpls/cwd_wrapped.htm": "${wrapper secret}",
+	} {
+		path = filepath.Join(dir, path)
+		_ = os.MkdirAll(filepath.Dir(path), 0750)
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	for _, path := range []string{"up", "wrapped", "prefix", "missing"} {
+		if _, err := tpls.Compile(path); !errors.Is(err, ErrOutsideRoot) {
+			t.Fatalf("Expected ErrOutsideRoot for %s, got: %v", path, err)
+		}
+	}
+	// Dots are allowed while the file is inside the roots.
+	if text, err := tpls.Compile("ok"); err != nil || text != "inside" {
+		t.Fatalf("Error Compile: %v", err)
+	}
+	// A file, which is in no root, is not read from the working directory.
+	wd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer os.Chdir(wd)
+	for _, path := range []string{"cwd", "cwd_wrapped", "secret"} {
+		if text, err := tpls.Compile(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Expected fs.ErrNotExist for %s, got: %q, %v", path, text, err)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["other_books"] = otherBooks(tpls)
+	for _, path := range []string{"view", "book"} {
+		if _, err := tpls.Compile(path); err != nil {
+			t.Fatalf("Error Compile: %s", err.Error())
+		}
+	}
+	state, err := tpls.State()
+	if err != nil {
+		t.Fatalf("Error State: %s", err.Error())
+	}
+	restarted, _ := New(includePaths, filesExt, tagsPair, false)
+	restarted.Logger = logger
+	restarted.MergeStash(tpls.Stash)
+	if err = restarted.RestoreState([]byte("{")); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err = restarted.RestoreState([]byte(`{"version":0}`)); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err = restarted.RestoreState(state); err != nil {
+		t.Fatalf("Error RestoreState: %s", err.Error())
+	}
+	if restarted.files.len() != tpls.files.len() || len(restarted.compiled) != len(tpls.compiled) {
+		t.Fatal("The caches should be restored")
+	}
+	// Included files are shared after the restore too.
+	footer := restarted.compiled[compiledKey(restarted, "partials/footer")]
+	for _, path := range []string{"view", "book"} {
+		c := restarted.compiled[compiledKey(restarted, path)]
+		if !slices.ContainsFunc(c.segments, func(s segment) bool { return s.file == footer }) &&
+			!slices.ContainsFunc(c.segments, func(s segment) bool {
+				return s.file != nil && slices.ContainsFunc(s.file.segments,
+					func(s segment) bool { return s.file == footer })
+			}) {
+			t.Fatalf("%s should include the shared footer", path)
+		}
+		var b1, b2 bytes.Buffer
+		_, _ = tpls.Execute(&b1, path)
+		_, _ = restarted.Execute(&b2, path)
+		if b1.String() != b2.String() {
+			t.Fatalf("Different output after restore:\n%s\n%s", b1.String(), b2.String())
+		}
+	}
+
+	// Missing and failed optional includes are kept as their text.
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		"<p>${include? widget}</p>${include missing}<p>${title}</p>"), 0600)
+	tpls, _ = New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.OnIncludeError = func(string, error) {}
+	tpls.OnMissingInclude = CommentOnMissing
+	tpls.Stash["title"] = "OK"
+	var b1, b2 bytes.Buffer
+	if _, err = tpls.Execute(&b1, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	if state, err = tpls.State(); err != nil {
+		t.Fatalf("Error State: %s", err.Error())
+	}
+	restarted, _ = New([]string{root}, filesExt, tagsPair, false)
+	restarted.Logger = logger
+	restarted.Stash["title"] = "OK"
+	if err = restarted.RestoreState(state); err != nil {
+		t.Fatalf("Error RestoreState: %s", err.Error())
+	}
+	if len(restarted.compiled) != len(tpls.compiled) {
+		t.Fatal("The compiled page should be restored")
+	}
+	if _, err = restarted.Execute(&b2, "page"); err != nil || b1.String() != b2.String() {
+		t.Fatalf("Different output after restore (%v):\n%s\n%s", err, b1.String(), b2.String())
+	}
+	_ = tpls.Close()
+}
+
+func TestOptionalInclude(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		"<p>${include? widget}</p><p>${include? loop}</p><p>${include? ok}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "loop.htm"), []byte("${include loop}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "ok.htm"), []byte("${title}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	tpls.Stash["title"] = "OK"
+	var failed []string
+	tpls.OnIncludeError = func(path string, err error) {
+		failed = append(failed, path)
+	}
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<p><!-- failed include: widget --></p><p><!-- failed include: loop --></p><p>OK</p>"
+	if b.String() != expected {
+		t.Fatalf("\nexpected:%s\ngot:%s", expected, b.String())
+	}
+	if strings.Join(failed, ",") != "widget,loop" {
+		t.Fatalf("Wrong failed includes: %v", failed)
+	}
+	if deps, err := tpls.Dependencies("page"); err != nil || len(deps) != 1 {
+		t.Fatalf("Only the included file should be a dependency: %v, %v", deps, err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		"gledki:tags <% %>\n<p><%title%> ${client}</p><%include item%>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.htm"), []byte(
+		"---\ntags: {{ }}\n---\n<i>{{title}}</i>${title}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<p>Title ${client}</p><i>Title</i>${title}"
+	if b.String() != expected {
+		t.Fatalf("\nexpected:%s\ngot:%s", expected, b.String())
+	}
+	if p, err := tpls.Placeholders("page"); err != nil || len(p) != 1 || p[0] != "title" {
+		t.Fatalf("Wrong placeholders: %v", p)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.html"), []byte(
+		"<p>${include item}</p>${include note}${include item.htm}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.htm"), []byte("${title}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.html"), []byte("html"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "note.txt"), []byte("note"), 0600)
+	tpls, err := New([]string{root}, []string{".htm", ".html", ".txt"}, tagsPair, true)
+	if err != nil {
+		t.Fatalf("Error in New: %s", err.Error())
+	}
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	if len(tpls.base().files.files) != 4 {
+		t.Fatalf("All files should be loaded")
+	}
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if b.String() != "<p>Title</p>noteTitle" {
+		t.Fatalf("Wrong output: %s", b.String())
+	}
+}
+
+func TestExclude(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "blog", "_drafts"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("${title}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "page.bak.htm"), []byte("old"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "blog", "post.htm"), []byte("post"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "blog", "_drafts", "next.htm"), []byte("next"), 0600)
+	defer func(e []string) { Exclude = e }(Exclude)
+	Exclude = []string{"**/_drafts/**", "**/*.bak.htm"}
+	tpls, err := New([]string{root}, filesExt, tagsPair, true)
+	if err != nil {
+		t.Fatalf("Error in New: %s", err.Error())
+	}
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	loaded := slices.Sorted(maps.Keys(tpls.base().files.files))
+	expected := []string{filepath.Join(root, "blog", "post.htm"), filepath.Join(root, "page.htm")}
+	if !slices.Equal(loaded, expected) {
+		t.Fatalf("\nexpected:%v\ngot:%v", expected, loaded)
+	}
+	for name, ok := range map[string]bool{
+		"_drafts":           true,
+		"a/b/_drafts/c.htm": true,
+		"a/_drafts.htm":     false,
+		"x.bak.htm":         true,
+		"a/x.htm":           false,
+	} {
+		if tpls.excluded(name) != ok {
+			t.Errorf("excluded(%q) should be %v", name, ok)
+		}
+	}
+	if paths, err := tpls.listTemplates(root); err != nil || strings.Join(paths, ",") != "blog/post.htm,page.htm" {
+		t.Fatalf("The excluded templates should not be listed: %v, %v", paths, err)
+	}
+}
+
+func TestCompiledNotSources(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("${include item}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.tpl"), []byte("${title}"), 0600)
+	tpls, _ := New([]string{root}, []string{".htm", ".tpl"}, tagsPair, false)
+	tpls.Logger = logger
+	tpls.CompiledSuffix = ".tpl"
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "Title" {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+	tpls.wg.Wait()
+	if !isReadable(filepath.Join(root, "page.htm.tpl")) {
+		t.Fatal("The compiled template should be stored")
+	}
+	if err := tpls.Freeze(); err != nil {
+		t.Fatalf("Error in Freeze: %s", err.Error())
+	}
+	if _, ok := tpls.base().files.get(filepath.Join(root, "page.htm.tpl")); ok {
+		t.Fatal("A compiled template should not be loaded as a source")
+	}
+	if err := tpls.removeCompiled(tpls.Roots); err != nil || isReadable(filepath.Join(root, "page.htm.tpl")) ||
+		!isReadable(filepath.Join(root, "item.tpl")) {
+		t.Fatalf("Only the compiled templates should be removed: %v", err)
+	}
+}
+
+func TestLoadFilesConcurrently(t *testing.T) {
+	root := t.TempDir()
+	for i := range 100 {
+		_ = os.WriteFile(filepath.Join(root, spf("p%d.htm", i)), []byte(spf("page %d", i)), 0600)
+	}
+	tpls, err := New([]string{root}, filesExt, tagsPair, true)
+	if err != nil || len(tpls.base().files.files) != 100 {
+		t.Fatalf("All files should be loaded: %v", err)
+	}
+	_ = os.Symlink(filepath.Join(root, "none1"), filepath.Join(root, "broken1.htm"))
+	_ = os.Symlink(filepath.Join(root, "none2"), filepath.Join(root, "broken2.htm"))
+	_, err = New([]string{root}, filesExt, tagsPair, true)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "broken1.htm") ||
+		!strings.Contains(err.Error(), "broken2.htm") {
+		t.Fatalf("All errors should be returned, got: %v", err)
+	}
+	tpls, _ = New([]string{root}, filesExt, tagsPair, false)
+	tpls.LoadWorkers = 1
+	if err = tpls.Freeze(); !errors.Is(err, fs.ErrNotExist) ||
+		!strings.Contains(err.Error(), "broken1.htm") || !strings.Contains(err.Error(), "broken2.htm") {
+		t.Fatalf("All errors should be returned by one worker, got: %v", err)
+	}
+}
+
+// flushRecorder records the length of the output at every flush.
+type flushRecorder struct {
+	strings.Builder
+	flushes []int
+}
+
+func (r *flushRecorder) Flush() { r.flushes = append(r.flushes, r.Len()) }
+
+func TestFlushEvery(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "report.htm"), []byte(
+		strings.Repeat("<tr><td>${row}</td></tr>\n", 100)), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	tpls.Stash["row"] = "0123456789"
+	var r flushRecorder
+	if _, err := tpls.Execute(&r, "report"); err != nil || len(r.flushes) != 0 {
+		t.Fatalf("The output should not be flushed by default: %v, %v", r.flushes, err)
+	}
+	tpls.FlushEvery = 1000
+	r = flushRecorder{}
+	n, err := tpls.Execute(&r, "report")
+	if err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if len(r.flushes) != 3 || r.flushes[0] < 1000 || r.flushes[2] != int(n) {
+		t.Fatalf("Wrong flushes for %d bytes: %v", n, r.flushes)
+	}
+	var b strings.Builder
+	w := bufio.NewWriterSize(&b, 4096)
+	if n, err = tpls.Execute(w, "report"); err != nil || b.Len() != int(n) {
+		t.Fatalf("A bufio.Writer should be flushed: %d, %v", b.Len(), err)
+	}
+}
+
+func TestCachedTagFunc(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "books.htm"), []byte("${other_books}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	queries := 0
+	books := func(w io.Writer, tag string) (int, error) {
+		queries++
+		if queries == 3 {
+			return 0, errors.New("database is down")
+		}
+		return w.Write([]byte(spf("books %d", queries)))
+	}
+	tpls.Stash["other_books"] = tpls.CachedTagFunc("other_books", time.Hour, books)
+	var b strings.Builder
+	render := func() string {
+		b.Reset()
+		_, _ = tpls.Execute(&b, "books")
+		return b.String()
+	}
+	if render() != "books 1" || render() != "books 1" || queries != 1 {
+		t.Fatalf("The output should be cached: %s, %d", b.String(), queries)
+	}
+	tpls.ExpireCachedTagFunc("other_books")
+	if render() != "books 2" || queries != 2 {
+		t.Fatalf("The output should be produced again: %s", b.String())
+	}
+	tpls.ExpireCachedTagFunc("other_books")
+	if render() != "" || render() != "books 4" || render() != "books 4" {
+		t.Fatalf("An error should not be cached: %s", b.String())
+	}
+	short := tpls.CachedTagFunc("short", time.Millisecond, books)
+	_, _ = short(&b, "short")
+	time.Sleep(2 * time.Millisecond)
+	b.Reset()
+	if _, _ = short(&b, "short"); b.String() != "books 6" {
+		t.Fatalf("The output should expire: %s", b.String())
+	}
+}
+
+// mapStore is a CompiledStore, shared by several instances.
+type mapStore struct {
+	sync.Mutex
+	m map[string]string
+}
+
+func (s *mapStore) Get(key string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	text, ok := s.m[key]
+	return text, ok
+}
+
+func (s *mapStore) Set(key, text string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.m[key] = text
+	return nil
+}
+
+func (s *mapStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func TestCompiledStore(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "page.htm")
+	_ = os.WriteFile(page, []byte("<p>${include item}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.htm"), []byte("${title}"), 0600)
+	store := &mapStore{m: make(map[string]string)}
+	newTpls := func() *Gledki {
+		tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+		tpls.Logger = logger
+		tpls.CompiledStore = store
+		tpls.Stash["title"] = "Title"
+		return tpls
+	}
+	tpls := newTpls()
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<p>Title</p>" {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+	tpls.wg.Wait()
+	if len(store.m) != 2 || isReadable(page+CompiledSuffix) {
+		t.Fatalf("The compiled templates should be only in the store: %v", store.m)
+	}
+	store.m[page] = "<b>${include item}</b>"
+	pod := newTpls()
+	b.Reset()
+	if _, err := pod.Execute(&b, "page"); err != nil || b.String() != "<b>Title</b>" {
+		t.Fatalf("The compiled template should be taken from the store: %s, %v", b.String(), err)
+	}
+	if dr, err := pod.ExecuteDryRun("page", nil); err != nil || !dr.Stored {
+		t.Fatalf("The template should be reported as stored: %v", err)
+	}
+	if err := pod.Invalidate("page"); err != nil || len(store.m) != 1 {
+		t.Fatalf("The template should be deleted from the store: %v, %v", store.m, err)
+	}
+	if err := pod.ClearCache(true); err != nil || len(store.m) != 0 {
+		t.Fatalf("All templates should be deleted from the store: %v, %v", store.m, err)
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	ci, prod := t.TempDir(), t.TempDir()
+	for _, root := range []string{ci, prod} {
+		_ = os.MkdirAll(filepath.Join(root, "partials"), 0750)
+		_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+			"${wrapper partials/layout}<p>${include partials/item}</p>"), 0600)
+		_ = os.WriteFile(filepath.Join(root, "partials", "layout.htm"), []byte(
+			"<main>${content}</main>"), 0600)
+		_ = os.WriteFile(filepath.Join(root, "partials", "item.htm"), []byte("${title}"), 0600)
+	}
+	tpls, _ := New([]string{ci}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	var bundle bytes.Buffer
+	if err := tpls.WriteBundle(&bundle); err != nil {
+		t.Fatalf("Error in WriteBundle: %s", err.Error())
+	}
+	// The bundle is used, not the changed source.
+	_ = os.WriteFile(filepath.Join(prod, "page.htm"), []byte("changed"), 0600)
+	tpls, _ = New([]string{prod}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	if err := tpls.ReadBundle(bytes.NewReader(bundle.Bytes())); err != nil {
+		t.Fatalf("Error in ReadBundle: %s", err.Error())
+	}
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<main><p>Title</p></main>" {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+	tpls.wg.Wait()
+	if isReadable(filepath.Join(prod, "page.htm"+CompiledSuffix)) {
+		t.Fatal("The templates from the bundle should not be stored")
+	}
+	if err := tpls.Invalidate("page"); err != nil {
+		t.Fatalf("Error in Invalidate: %s", err.Error())
+	}
+	b.Reset()
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "changed" {
+		t.Fatalf("An invalidated template should be compiled again: %s, %v", b.String(), err)
+	}
+	if err := tpls.ReadBundle(strings.NewReader("junk")); err == nil {
+		t.Fatal("ReadBundle should fail for a bad bundle")
+	}
+}
+
+func TestGenerateGo(t *testing.T) {
+	src, empty := t.TempDir(), t.TempDir()
+	_ = os.MkdirAll(filepath.Join(src, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(src, "page.htm"), []byte("<p>${include partials/item}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(src, "partials", "item.htm"), []byte("${title}"), 0600)
+	tpls, _ := New([]string{src}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if err := tpls.GenerateGo(io.Discard, "not valid", "views"); err == nil {
+		t.Fatal("An invalid package name should be rejected")
+	}
+	if err := tpls.GenerateGo(io.Discard, "views", ""); err == nil {
+		t.Fatal("An empty bundle name should be rejected")
+	}
+	var code strings.Builder
+	if err := tpls.GenerateGo(&code, "views", "test-views"); err != nil {
+		t.Fatalf("Error in GenerateGo: %s", err.Error())
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "views.go", code.String(), 0)
+	if err != nil || f.Name.Name != "views" {
+		t.Fatalf("Invalid Go code: %v\n%s", err, code.String())
+	}
+	lit := f.Scope.Lookup("bundle").Decl.(*ast.ValueSpec).Values[0].(*ast.BasicLit)
+	bundle, _ := strconv.Unquote(lit.Value)
+	if !strings.Contains(code.String(), `gledki.RegisterBundle("test-views", bundle)`) {
+		t.Fatalf("The bundle should be registered by name:\n%s", code.String())
+	}
+	// What the init function of the generated file does.
+	RegisterBundle("test-views", bundle)
+	defer func() {
+		bundlesMu.Lock()
+		delete(bundles, "test-views")
+		bundlesMu.Unlock()
+	}()
+	expectPanic(t, func() { RegisterBundle("test-views", bundle) })
+	// The bundle is used only when asked for.
+	tpls, err = New([]string{empty}, filesExt, tagsPair, false)
+	if err != nil {
+		t.Fatalf("Error in New: %s", err.Error())
+	}
+	tpls.Logger = logger
+	if _, err := tpls.Compile("page"); err == nil {
+		t.Fatal("New should not read the registered bundles")
+	}
+	// The templates are served from the bundle without their files.
+	if err := tpls.UseBundle("test-views"); err != nil {
+		t.Fatalf("Error in UseBundle: %s", err.Error())
+	}
+	defer tpls.wg.Wait()
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<p>Title</p>" {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+}
+
+func TestUseBundleUnknown(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${title}</p>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	err := tpls.UseBundle("unknown")
+	if err == nil || !strings.Contains(err.Error(), "'unknown' is not registered") {
+		t.Fatalf("An unknown bundle should be reported: %v", err)
+	}
+	if tpls.CompiledStore != nil {
+		t.Fatal("The store should not be changed")
+	}
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<p>Title</p>" {
+		t.Fatalf("The templates should be compiled from the files: %s, %v", b.String(), err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	base, theme := t.TempDir(), t.TempDir()
+	_ = os.MkdirAll(filepath.Join(base, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(base, "page.htm"), []byte("base"), 0600)
+	_ = os.WriteFile(filepath.Join(base, "partials", "item.htm"), []byte("item"), 0600)
+	_ = os.WriteFile(filepath.Join(theme, "page.htm"), []byte("theme"), 0600)
+	_ = os.WriteFile(filepath.Join(theme, "home.htm"), []byte("home"), 0600)
+	tpls, _ := New([]string{theme, base}, filesExt, tagsPair, false)
+	paths, err := tpls.Templates()
+	expected := []string{"home.htm", "page.htm", "partials/item.htm"}
+	if err != nil || !slices.Equal(paths, expected) {
+		t.Fatalf("\nexpected:%v\ngot:%v, %v", expected, paths, err)
+	}
+}
+
+// recordingTracer records the spans as "name(parent) attributes".
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name, parent string
+	attrs        map[string]any
+	err          error
+	ended        bool
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: make(map[string]any)}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	r.spans = append(r.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)              { s.err = err }
+func (s *recordedSpan) End()                               { s.ended = true }
+
+func TestTracer(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${include item}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "item.htm"), []byte("${title}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	tracer := &recordingTracer{}
+	tpls.Tracer = tracer
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	for range 2 {
+		if _, err := tpls.Execute(&b, "page"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+	}
+	var got []string
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Fatalf("The span %s should be ended", s.name)
+		}
+		got = append(got, spf("%s(%s) %s %v %v %v", s.name, s.parent, filepath.Base(spf("%v", s.attrs[AttrTemplate])),
+			s.attrs[AttrCacheHit], s.attrs[AttrStored], s.attrs[AttrBytes]))
+	}
+	expected := []string{
+		"gledki.Execute() page <nil> <nil> 12",
+		"gledki.Compile(gledki.Execute) page.htm false false <nil>",
+		"gledki.include(gledki.Compile) item.htm false false <nil>",
+		"gledki.Execute() page <nil> <nil> 12",
+		"gledki.Compile(gledki.Execute) page.htm true <nil> <nil>",
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("\nexpected:%q\ngot:%q", expected, got)
+	}
+	tracer.spans = nil
+	if _, err := tpls.Execute(&b, "none"); err == nil || tracer.spans[0].err == nil {
+		t.Fatalf("The error should be recorded: %v", err)
+	}
+}
+
+func TestOnMissingInclude(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "page.htm"),
+		[]byte("<p>${title}</p>${include partials/todo}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	if _, err := tpls.Compile("page"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected a missing file error, got: %v", err)
+	}
+	tpls.OnMissingInclude = FailOnMissing
+	if _, err := tpls.Compile("page"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected a missing file error, got: %v", err)
+	}
+	tpls.OnMissingInclude = CommentOnMissing
+	tpls.Stash["title"] = "Заглавие"
+	out.Reset()
+	if _, err := tpls.Execute(&out, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if out.String() != "<p>Заглавие</p><!-- missing include: partials/todo.htm -->" {
+		t.Fatalf("Wrong output: %s", out.String())
+	}
+	tpls.wg.Wait()
+	compiled, _ := os.ReadFile(filepath.Join(root, "page.htm"+CompiledSuffix))
+	if !strings.Contains(string(compiled), "${include partials/todo}") {
+		t.Fatalf("The directive should be kept on disk: %s", compiled)
+	}
+	_ = tpls.ClearCache(true)
+	tpls.OnMissingInclude = func(path string, err error) (string, error) {
+		return "<p>TODO ${title}</p>", nil
+	}
+	out.Reset()
+	_, _ = tpls.Execute(&out, "page")
+	if out.String() != "<p>Заглавие</p><p>TODO Заглавие</p>" {
+		t.Fatalf("Wrong output: %s", out.String())
+	}
+}
+
+func TestHasher(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	if len(tpls.stashHash(&execution{})) != 64 {
+		t.Fatal("SHA-256 should be used by default")
+	}
+	tpls.Hasher = FastHasher
+	if len(tpls.stashHash(&execution{})) != 16 {
+		t.Fatal("FNV-1a should be used")
+	}
+	tpls.CacheKey = HashedKey(FastHasher)
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	for key := range tpls.compiled {
+		if len(key) != 16 {
+			t.Fatalf("Wrong key: %s", key)
+		}
+	}
+	if _, ok := tpls.compiled[compiledKey(tpls, "view")]; !ok {
+		t.Fatal("The template should be cached with a hashed key")
+	}
+	theme, _ := tpls.WithRoots(includePaths[1:])
+	k1, _ := tpls.cacheKey(tpls.Roots, "x")
+	k2, _ := theme.cacheKey(theme.Roots, "x")
+	if k1 == k2 {
+		t.Fatal("Different roots should give different keys")
+	}
+}
+
+func TestBrand(t *testing.T) {
+	root := t.TempDir()
+	for path, text := range map[string]string{
+		"page.htm":          "${wrapper layout}<h1>${brand.name}: ${title}</h1>${include partials/logo}",
+		"layout.htm":        `<body style="color:${brand.color}">${content}</body>`,
+		"partials/logo.htm": `<img src="${brand.logo}">`,
+	} {
+		path = filepath.Join(root, path)
+		_ = os.MkdirAll(filepath.Dir(path), 0750)
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	profile := Stash{"brand.name": "Acme", "brand.color": "#c00", "brand.logo": "/acme.png"}
+	dir := filepath.Join(t.TempDir(), "acme")
+	if err := tpls.Brand(dir, profile, false); err != nil {
+		t.Fatalf("Error Brand: %s", err.Error())
+	}
+	if err := tpls.Brand(dir, profile, false); err == nil {
+		t.Fatal("An existing directory should not be overwritten")
+	}
+	page, _ := os.ReadFile(filepath.Join(dir, "page.htm"))
+	if string(page) != "${wrapper layout}<h1>Acme: ${title}</h1>${include partials/logo}" {
+		t.Fatalf("Wrong branded file: %s", page)
+	}
+	logo, _ := os.ReadFile(filepath.Join(dir, "partials", "logo.htm"))
+	if string(logo) != `<img src="/acme.png">` {
+		t.Fatalf("Wrong branded file: %s", logo)
+	}
+	bundle := filepath.Join(t.TempDir(), "acme")
+	if err := tpls.Brand(bundle, profile, true); err != nil {
+		t.Fatalf("Error Brand: %s", err.Error())
+	}
+	page, _ = os.ReadFile(filepath.Join(bundle, "page.htm"))
+	if string(page) != `<body style="color:#c00"><h1>Acme: ${title}</h1><img src="/acme.png"></body>` {
+		t.Fatalf("Wrong branded bundle: %s", page)
+	}
+}
+
+func TestExecuteDryRun(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	_ = tpls.ClearCache(true)
+	tpls.MergeStash(data)
+	delete(tpls.Stash, "generator")
+	tpls.SetConstants(Stash{"lang": "bg"})
+	if _, err := tpls.ExecuteDryRun("missing", nil); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	r, err := tpls.ExecuteDryRun("view", Stash{"body": "тяло"})
+	if err != nil {
+		t.Fatalf("Error ExecuteDryRun: %s", err.Error())
+	}
+	if r.Cached || r.Stored || r.Path != tpls.toFullPath("view") {
+		t.Fatalf("Wrong cache decisions: %#v", r)
+	}
+	trace := strings.Join(r.Trace, "\n")
+	for _, v := range []string{"template view => " + r.Path, "  wrapper layout => ",
+		"  include partials/footer => " + tpls.toFullPath("partials/footer")} {
+		if !strings.Contains(trace, v) {
+			t.Fatalf("Trace does not contain %s:\n%s", v, trace)
+		}
+	}
+	if !slices.Contains(r.Replaced, "body") || !slices.Contains(r.Replaced, "lang") ||
+		!slices.Contains(r.Replaced, "title") {
+		t.Fatalf("Wrong replaced tags: %v", r.Replaced)
+	}
+	if !slices.Contains(r.Remaining, "generator") || slices.Contains(r.Remaining, "body") {
+		t.Fatalf("Wrong remaining tags: %v", r.Remaining)
+	}
+	tpls.wg.Wait()
+	if r, _ = tpls.ExecuteDryRun("view", nil); !r.Cached || !r.Stored {
+		t.Fatalf("Wrong cache decisions: %#v", r)
+	}
+}
+
+func TestShadows(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	if _, err := tpls.Shadows(includePaths[0], "/ala/bala"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if _, err := tpls.Shadows("/ala/bala", includePaths[1]); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	r, err := tpls.Shadows(includePaths[0], includePaths[1])
+	if err != nil {
+		t.Fatalf("Error Shadows: %s", err.Error())
+	}
+	if strings.Join(r.Shadowed, ",") != "book.htm,layout.htm" {
+		t.Fatalf("Wrong shadowed templates: %v", r.Shadowed)
+	}
+	if len(r.OnlyTheme) > 0 {
+		t.Fatalf("Wrong templates only in the theme: %v", r.OnlyTheme)
+	}
+	if !slices.Contains(r.OnlyBase, "partials/footer.htm") ||
+		!slices.Contains(r.OnlyBase, "theme/book.htm") {
+		t.Fatalf("Wrong templates only in the base: %v", r.OnlyBase)
+	}
+}
+
+func TestScaffoldTheme(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	theme := filepath.Join(t.TempDir(), "dark")
+	r, err := tpls.ScaffoldTheme(includePaths[0], theme, []string{"layout", "partials/footer.htm"}, false)
+	if err != nil {
+		t.Fatalf("Error ScaffoldTheme: %s", err.Error())
+	}
+	if strings.Join(r.Shadowed, ",") != "layout.htm,partials/footer.htm" || len(r.OnlyTheme) > 0 {
+		t.Fatalf("Wrong report: %#v", r)
+	}
+	copied, _ := os.ReadFile(filepath.Join(theme, "partials/footer.htm"))
+	if original, _ := tpls.LoadFile("partials/footer"); string(copied) != original {
+		t.Fatalf("Wrong copy: %s", copied)
+	}
+	if _, err = tpls.ScaffoldTheme(includePaths[0], theme, []string{"layout"}, false); err == nil {
+		t.Fatal("Existing files should not be overwritten")
+	}
+	r, err = tpls.ScaffoldTheme(includePaths[0], theme, []string{"view"}, true)
+	if err != nil {
+		t.Fatalf("Error ScaffoldTheme: %s", err.Error())
+	}
+	if fi, err := os.Lstat(filepath.Join(theme, "view.htm")); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		t.Fatal("view.htm should be a symbolic link")
+	}
+	if len(r.Shadowed) != 3 {
+		t.Fatalf("Wrong report: %#v", r)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	for _, path := range []string{"../outside", "partials/../../outside", outside, ""} {
+		_, err = tpls.ScaffoldTheme(includePaths[0], filepath.Join(filepath.Dir(outside), "theme"), []string{path}, false)
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("The path '%s' should be refused: %v", path, err)
+		}
+	}
+	if _, err = os.Stat(outside + ".htm"); err == nil {
+		t.Fatal("No file should be written outside the theme")
+	}
+}
+
+func TestIncludeLimitPanic(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Stash = Stash{
+		"title":     "Possibly recursive inclusions",
+		"generator": "Gledki",
+		"included":  "included",
+	}
+	level := 0
+	tpls.Stash["level"] = TagFunc(func(w io.Writer, tag string) (int, error) {
+		level++
+		return w.Write([]byte(spf("%d", level)))
+	})
+	var out strings.Builder
+	expectPanic(t, func() { _, _ = tpls.Execute(&out, "includes.htm") })
+}
+
+func TestWrapperChain(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Stash = Stash{"title": "Chained"}
+	var out strings.Builder
+	if _, err := tpls.Execute(&out, "chained"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<html><body><section><p>Chained</p></section></body></html>"
+	if out.String() != expected {
+		t.Fatalf("\nexpected:%s\ngot:%s", expected, out.String())
+	}
+
+	bad, _ := New([]string{includePaths[0] + "/../tpls_bad"}, filesExt, tagsPair, false)
+	expectPanic(t, func() { _, _ = bad.Execute(&out, "wrapper_loop") })
+}
+
+func TestOtherPanics(t *testing.T) {
+
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	path := "/ff/a.htm"
+	expectPanic(t, func() { tpls.MustLoadFile(path) })
+	expectPanic(t, func() { Must([]string{"/aaa/bbb"}, filesExt, tagsPair, false) })
+}
+
+func TestReady(t *testing.T) {
+	root := t.TempDir()
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	var logs bytes.Buffer
+	tpls.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	// Storing errors are not panics anymore, but are reported.
+	tpls.wg.Add(1)
+	tpls.storeCompiled("/ff/a.htm", "bla")
+	h := tpls.CacheHealth()
+	if h.Failed != 1 || h.LastError == nil || !strings.Contains(logs.String(), "/ff/a.htm") {
+		t.Fatalf("Wrong cache health: %#v", h)
+	}
+	if err := tpls.Ready(); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	tpls.wg.Add(1)
+	tpls.storeCompiled(filepath.Join(root, "a.htm"), "bla")
+	if err := tpls.Ready(); err != nil || tpls.CacheHealth().Stored != 1 {
+		t.Fatalf("Ready after a successful store: %v", err)
+	}
+	tpls.MaxClockSkew = -1
+	if err := tpls.Ready(); err == nil || !strings.Contains(err.Error(), "clock skew") {
+		t.Fatalf("Clock skew should be reported: %v", err)
+	}
+	tpls.MaxClockSkew = MaxClockSkew
+	if os.Geteuid() == 0 {
+		t.Log("Permission errors cannot be tested as root")
+		return
+	}
+	_ = os.Chmod(root, 0500)
+	defer os.Chmod(root, 0700)
+	if err := tpls.Ready(); err == nil {
+		t.Fatal("Permission error should be reported")
+	}
+}
+
+// pingStore is a CompiledStore, which implements Pinger.
+type pingStore struct {
+	mapStore
+	err error
+}
+
+func (s *pingStore) Ping() error { return s.err }
+
+func TestReadyCompiledStore(t *testing.T) {
+	root := t.TempDir()
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	file := filepath.Join(root, "file")
+	_ = os.WriteFile(file, nil, 0600)
+	// The directory cannot be created, but it is not used with a store.
+	tpls.CompiledNextToTemplates = false
+	tpls.CompiledDir = filepath.Join(file, "compiled")
+	if err := tpls.Ready(); err == nil {
+		t.Fatal("The directory should be probed")
+	}
+	tpls.CompiledStore = &mapStore{m: map[string]string{}}
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("The disk should not be probed with a store: %s", err.Error())
+	}
+	store := &pingStore{mapStore: mapStore{m: map[string]string{}}}
+	tpls.CompiledStore = store
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	store.err = errors.New("connection refused")
+	if err := tpls.Ready(); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("The failed ping should be reported: %v", err)
+	}
+}
+
+func TestIncludeLimitNoPanic(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+
+	tpls.Stash = Stash{
+		"title":     "Possibly recursive inclusions",
+		"generator": "Gledki",
+		"included":  "included",
+	}
+	level := 0
+	tpls.Stash["level"] = TagFunc(func(w io.Writer, tag string) (int, error) {
+		level++
+		return w.Write([]byte(spf("%d", level)))
+	})
+
+	tpls.IncludeLimit = 7
+	level = 0
+	out.Reset()
+	_, err := tpls.Execute(&out, "includes")
+	if err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	outstr := out.String()
+	t.Log(outstr)
+
+	if !strings.Contains(outstr, "4 4") {
+		t.Fatalf("output does not contain expected value 4 4")
+	}
+}
+
+func TestClearCache(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.Stash = data
+	// Make sure that templates are compiled from the sources.
+	_ = tpls.ClearCache(true)
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	compiled := tpls.toFullPath("view") + CompiledSuffix
+	if tpls.files.len() == 0 || len(tpls.compiled) == 0 {
+		t.Fatal("templates should be loaded and compiled")
+	}
+	if err := tpls.ClearCache(false); err != nil {
+		t.Fatalf("Error ClearCache: %s", err.Error())
+	}
+	if tpls.files.len() > 0 || len(tpls.compiled) > 0 {
+		t.Fatal("templates should not be loaded")
+	}
+	if !isReadable(compiled) {
+		t.Fatalf("compiled file %s should be kept", compiled)
+	}
+	if err := tpls.ClearCache(true); err != nil {
+		t.Fatalf("Error ClearCache: %s", err.Error())
+	}
+	if isReadable(compiled) {
+		t.Fatalf("compiled file %s should be deleted", compiled)
+	}
+}
+
+func TestClearCacheConcurrent(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	// Store the compiled template first, so ClearCache does not wait for
+	// the storing, while the template is being executed.
+	if _, err := tpls.Execute(io.Discard, "view"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	tpls.wg.Wait()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var b strings.Builder
+			if _, err := tpls.Execute(&b, "view"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = tpls.ClearCache(false)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInvalidate(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.Stash = data
+	// Make sure that templates are compiled from the sources.
+	_ = tpls.ClearCache(true)
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	path := tpls.toFullPath("view")
+	if err := tpls.Invalidate("view"); err != nil {
+		t.Fatalf("Error Invalidate: %s", err.Error())
+	}
+	if _, ok := tpls.compiled[compiledKey(tpls, path)]; ok {
+		t.Fatalf("%s should not be compiled", path)
+	}
+	if _, ok := tpls.files.get(path); ok {
+		t.Fatalf("%s should not be loaded", path)
+	}
+	if isReadable(path + CompiledSuffix) {
+		t.Fatalf("compiled file %s should be deleted", path+CompiledSuffix)
+	}
+	if _, ok := tpls.files.get(tpls.toFullPath("layout")); !ok {
+		t.Fatal("layout should still be loaded")
+	}
+	// Invalidating a not compiled template is not an error.
+	if err := tpls.Invalidate("view"); err != nil {
+		t.Fatalf("Error Invalidate: %s", err.Error())
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	text, _ := tpls.Evaluate("view")
+	compiled, _ := tpls.Compile("view")
+	if text != compiled {
+		t.Fatal("Without constants Evaluate should return the compiled template")
+	}
+	tpls.SetConstants(Stash{"lang": "bg", "generator": "Гледки"})
+	text, err := tpls.Evaluate("view")
+	if err != nil {
+		t.Fatalf("Error Evaluate: %s", err.Error())
+	}
+	for _, v := range []string{`lang="bg"`, `content="Гледки"`, "${title}", "${body}"} {
+		if !strings.Contains(text, v) {
+			t.Fatalf("evaluated template does not contain %s:\n%s", v, text)
+		}
+	}
+	if _, ok := tpls.evaluated[compiledKey(tpls, "view")]; !ok {
+		t.Fatal("evaluated template should be cached")
+	}
+	tpls.Stash = Stash{"title": "Заглавие", "body": "Тяло", "included": "вложена"}
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	for _, v := range []string{`lang="bg"`, "Заглавие", "Тяло"} {
+		if !strings.Contains(out.String(), v) {
+			t.Fatalf("output does not contain %s:\n%s", v, out.String())
+		}
+	}
+	tpls.SetConstants(Stash{"lang": "en"})
+	if len(tpls.evaluated) > 0 {
+		t.Fatal("SetConstants should drop evaluated templates")
+	}
+}
+
+func TestPerInstanceCaching(t *testing.T) {
+	views, _ := New(includePaths, filesExt, tagsPair, false)
+	views.Logger = logger
+	emails, _ := New(includePaths, filesExt, tagsPair, false)
+	emails.Logger = logger
+	emails.CacheTemplates = false
+	emails.CompiledSuffix = "x"
+	_ = views.ClearCache(true)
+	if _, err := emails.Compile("view"); err != nil {
+		t.Fatalf("Error Compile: %s", err.Error())
+	}
+	path := emails.toFullPath("view")
+	if len(emails.compiled) > 0 || isReadable(path+"x") || isReadable(path+CompiledSuffix) {
+		t.Fatal("compiled template should not be cached")
+	}
+	if _, err := views.Compile("view"); err != nil {
+		t.Fatalf("Error Compile: %s", err.Error())
+	}
+	views.wg.Wait()
+	if _, ok := views.compiled[compiledKey(views, path)]; !ok || !isReadable(path+CompiledSuffix) {
+		t.Fatal("compiled template should be cached")
+	}
+}
+
+func TestSharedIncludes(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	_ = tpls.ClearCache(true)
+	for _, path := range []string{"view", "book"} {
+		if _, err := tpls.Compile(path); err != nil {
+			t.Fatalf("Error Compile: %s", err.Error())
+		}
+	}
+	tpls.wg.Wait()
+	footer := tpls.compiled[compiledKey(tpls, "partials/footer")]
+	if footer == nil {
+		t.Fatal("included partial should be compiled separately")
+	}
+	var refs int
+	for _, c := range tpls.compiled {
+		for _, s := range c.segments {
+			if s.file == footer {
+				refs++
+			} else if s.file != nil && s.file.path == footer.path {
+				t.Fatalf("%s references a copy of the footer", c.path)
+			}
+		}
+	}
+	// view includes it twice and partials/_book once.
+	if refs != 3 {
+		t.Fatalf("footer should be referenced 3 times, got %d", refs)
+	}
+	stored, err := os.ReadFile(tpls.toFullPath("view") + CompiledSuffix)
+	if err != nil {
+		t.Fatalf("Error reading compiled file: %s", err.Error())
+	}
+	if !strings.Contains(string(stored), "${include partials/footer}") ||
+		strings.Contains(string(stored), "<footer>") {
+		t.Fatalf("compiled file should contain only references to includes:\n%s", stored)
+	}
+	// Loaded from disk, the compiled template is the same.
+	compiled, _ := tpls.Compile("view")
+	_ = tpls.ClearCache(false)
+	if fromDisk, _ := tpls.Compile("view"); fromDisk != compiled {
+		t.Fatalf("compiled template from disk differs:\n%s\n%s", fromDisk, compiled)
+	}
+}
+
+func TestCacheFragment(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	renders := 0
+	tpls.MergeStash(data)
+	tpls.Stash["generator"] = TagFunc(func(w io.Writer, tag string) (int, error) {
+		renders++
+		return w.Write([]byte(spf("Gledki %d", renders)))
+	})
+	tpls.CacheFragment("partials/footer", 0)
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	// Once in the layout and once for both footers.
+	if renders != 2 || strings.Count(out.String(), "с Gledki 2") != 2 {
+		t.Fatalf("footer should be rendered once, renders: %d\n%s", renders, out.String())
+	}
+	tpls.ExpireFragment("partials/footer")
+	out.Reset()
+	_, _ = tpls.Execute(&out, "view")
+	if renders != 4 || strings.Count(out.String(), "с Gledki 4") != 2 {
+		t.Fatalf("expired footer should be rendered again, renders: %d\n%s", renders, out.String())
+	}
+
+	tpls.CacheFragment("partials/footer", time.Millisecond)
+	out.Reset()
+	_, _ = tpls.Execute(&out, "view")
+	time.Sleep(2 * time.Millisecond)
+	out.Reset()
+	_, _ = tpls.Execute(&out, "view")
+	if renders != 8 {
+		t.Fatalf("footer should be rendered again after its ttl, renders: %d", renders)
+	}
+}
+
+func TestCacheFragmentConcurrent(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	tpls.MergeStash(data)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var b strings.Builder
+			if _, err := tpls.Execute(&b, "view"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			tpls.CacheFragment("partials/footer", time.Millisecond)
+			tpls.ExpireFragment("partials/footer")
+			_ = tpls.Invalidate("partials/footer")
+		}()
+	}
+	wg.Wait()
+}
+
+type ctxKey string
+
+func TestExecuteContext(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["body"] = TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		return w.Write([]byte(spf("<p>%s</p>", ctx.Value(ctxKey("user")))))
+	})
+	ctx := context.WithValue(context.Background(), ctxKey("user"), "Краси")
+	out.Reset()
+	if _, err := tpls.ExecuteContext(ctx, &out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.ExecuteContext: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), "<p>Краси</p>") {
+		t.Fatalf("output does not contain value from the context:\n%s", out.String())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	tpls.Stash["title"] = TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		cancel()
+		return 0, nil
+	})
+	out.Reset()
+	if _, err := tpls.ExecuteContext(ctx, &out, "view"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestUse(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	var calls []string
+	logging := func(name string) Middleware {
+		return func(next ExecuteFunc) ExecuteFunc {
+			return func(ctx context.Context, w io.Writer, path string) (int64, error) {
+				calls = append(calls, name+":"+path)
+				return next(ctx, w, path)
+			}
+		}
+	}
+	errForbidden := errors.New("forbidden")
+	_ = tpls.Use("*", logging("all"))
+	_ = tpls.Use("partials/*", func(next ExecuteFunc) ExecuteFunc {
+		return func(ctx context.Context, w io.Writer, path string) (int64, error) {
+			return 0, errForbidden
+		}
+	})
+	_ = tpls.Use("view", logging("view"))
+	if err := tpls.Use("[", logging("bad")); err == nil {
+		t.Fatal("No error for a malformed pattern")
+	}
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view.htm"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if strings.Join(calls, ",") != "all:view.htm,view:view.htm" {
+		t.Fatalf("Wrong calls: %v", calls)
+	}
+	if _, err := tpls.Execute(&out, "partials/footer"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected errForbidden, got: %v", err)
+	}
+	// The middlewares cannot be bypassed with another execute method.
+	_ = tpls.Use("reader:", func(next ExecuteFunc) ExecuteFunc {
+		return func(ctx context.Context, w io.Writer, path string) (int64, error) {
+			return 0, errForbidden
+		}
+	})
+	for name, execute := range map[string]func() error{
+		"ExecuteWithRoots": func() error {
+			_, err := tpls.ExecuteWithRoots(&out, "partials/footer", nil)
+			return err
+		},
+		"ExecuteStats": func() error {
+			_, err := tpls.ExecuteStats(context.Background(), &out, "partials/footer")
+			return err
+		},
+		"ExecuteReader": func() error {
+			_, err := tpls.ExecuteReader(&out, strings.NewReader("${title}"), nil)
+			return err
+		},
+		"RenderAsOf": func() error {
+			_, err := tpls.RenderAsOf(&out, "partials/footer", nil, "v1")
+			return err
+		},
+	} {
+		if err := execute(); !errors.Is(err, errForbidden) {
+			t.Errorf("%s: expected errForbidden, got: %v", name, err)
+		}
+	}
+	for _, tc := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"pages/*", "pages/a", true},
+		{"pages/*", "pages/a/b", true},
+		{"pages/*", "pages", false},
+		{"pages/**/edit", "pages/a/b/edit", true},
+		{"pages/**/edit", "pages/a/b/view", false},
+		{"view", "views", false},
+	} {
+		if (middleware{pattern: tc.pattern}).match(tc.name) != tc.match {
+			t.Errorf("%s should match %s: %v", tc.pattern, tc.name, tc.match)
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	defer tpls.wg.Wait()
+	rel := func(paths []string) string {
+		for i, p := range paths {
+			paths[i], _ = filepath.Rel(tpls.Roots[0], p)
+		}
+		return strings.Join(paths, ",")
+	}
+	deps, err := tpls.Dependencies("view")
+	if err != nil {
+		t.Fatalf("Error Dependencies: %s", err.Error())
+	}
+	if got := rel(deps); got != "layout.htm,partials/footer.htm,partials/header.htm" {
+		t.Fatalf("Wrong dependencies: %s", got)
+	}
+	tpls.Stash["body"] = TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		text, err := tpls.CompileContext(ctx, "partials/_book_item")
+		if err != nil {
+			return 0, err
+		}
+		n, err := tpls.FtExecStd(text, w, Stash{"book_title": "Под игото", "book_author": "Вазов"})
+		return int(n), err
+	})
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), "<li>Под игото от Вазов</li>") {
+		t.Fatalf("The partial was not rendered:\n%s", out.String())
+	}
+	deps, _ = tpls.Dependencies("view")
+	if got := rel(deps); got != "layout.htm,partials/_book_item.htm,partials/footer.htm,partials/header.htm" {
+		t.Fatalf("The partial, compiled at runtime, should be a dependency: %s", got)
+	}
+	// the partial is cached and invalidated like any other template
+	key := compiledKey(tpls, "partials/_book_item")
+	tpls.mu.RLock()
+	_, ok := tpls.compiled[key]
+	tpls.mu.RUnlock()
+	if !ok {
+		t.Fatal("The partial, compiled at runtime, should be cached")
+	}
+	if err = tpls.Invalidate("partials/_book_item"); err != nil {
+		t.Fatalf("Error Invalidate: %s", err.Error())
+	}
+	tpls.mu.RLock()
+	_, ok = tpls.compiled[key]
+	tpls.mu.RUnlock()
+	if ok {
+		t.Fatal("The partial should be invalidated")
+	}
+	// The partials, recorded during the executions, are dropped with the page.
+	runtimeDeps := func() int {
+		tpls.mu.RLock()
+		defer tpls.mu.RUnlock()
+		return len(tpls.runtimeDeps)
+	}
+	_ = tpls.Invalidate("view")
+	if n := runtimeDeps(); n != 0 {
+		t.Fatalf("The partials of the invalidated page should be dropped: %d", n)
+	}
+	_, _ = tpls.Execute(io.Discard, "view")
+	_ = tpls.ClearCache(false)
+	if n := runtimeDeps(); n != 0 {
+		t.Fatalf("The partials should be dropped with the cache: %d", n)
+	}
+	_, _ = tpls.Execute(io.Discard, "view")
+	_ = tpls.SetRoots(includePaths)
+	if n := runtimeDeps(); n != 0 {
+		t.Fatalf("The partials should be dropped with the roots: %d", n)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	defer tpls.wg.Wait()
+	if err := tpls.Alias("home", "./home.htm"); err == nil {
+		t.Fatal("An alias of itself should be an error")
+	}
+	_ = tpls.Alias("./home.htm", "view")
+	_ = tpls.Alias("footer", "partials/footer")
+	if a := tpls.Aliases(); len(a) != 2 || a["home"] != "view" {
+		t.Fatalf("Wrong aliases: %#v", a)
+	}
+	var b1, b2 strings.Builder
+	if _, err := tpls.Execute(&b1, "home"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if _, err := tpls.Execute(&b2, "view"); err != nil || b1.String() != b2.String() {
+		t.Fatalf("The alias and the path should render the same: %v", err)
+	}
+	if len(tpls.compiled) != 3 {
+		t.Fatalf("The template should be cached once, got %d compiled", len(tpls.compiled))
+	}
+	r, err := tpls.ExecuteDryRun("home", nil)
+	if err != nil || !strings.HasPrefix(r.Trace[0], "template home (alias of view) => ") {
+		t.Fatalf("Wrong trace: %v, %v", r, err)
+	}
+	// aliases work in include directives too
+	if tpls.findPath(tpls.Roots, "footer") != tpls.toFullPath("partials/footer") {
+		t.Fatal("The alias should be resolved for includes")
+	}
+}
+
+func TestWithStash(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.HandlePrefixCtx("user.", func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		return w.Write([]byte(spf("<b>%s</b>", StashFromContext(ctx)["user"])))
+	})
+	ctx := WithStash(context.Background(), Stash{"title": "За заявката", "user": "Краси"})
+	out.Reset()
+	if _, err := tpls.ExecuteContext(ctx, &out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.ExecuteContext: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), "<title>За заявката</title>") {
+		t.Fatalf("output does not contain the title from the context:\n%s", out.String())
+	}
+	if tpls.Stash["title"] != data["title"] {
+		t.Fatal("The Stash should not be changed")
+	}
+	var b strings.Builder
+	if _, err := tpls.execute(newExecution(ctx), &b,
+		&compiledFile{segments: []segment{{text: "${user.name}"}}}); err != nil ||
+		b.String() != "<b>Краси</b>" {
+		t.Fatalf("Wrong output from the prefix handler: %q, %v", b.String(), err)
+	}
+	out.Reset()
+	if _, err := tpls.ExecuteWithRootsContext(ctx, &out, "view", nil); err != nil ||
+		!strings.Contains(out.String(), "За заявката") {
+		t.Fatalf("ExecuteWithRootsContext should use the data from the context: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	nodes := tpls.Parse("<h1>${title}</h1>${include partials/footer}${unclosed")
+	expected := []Node{
+		{Kind: TextNode, Pos: 0, Raw: "<h1>", Text: "<h1>"},
+		{Kind: TagNode, Pos: 4, Raw: "${title}", Text: "title"},
+		{Kind: TextNode, Pos: 12, Raw: "</h1>", Text: "</h1>"},
+		{Kind: DirectiveNode, Pos: 17, Raw: "${include partials/footer}",
+			Text: "include partials/footer", Name: "include", Arg: "partials/footer"},
+		{Kind: TextNode, Pos: 43, Raw: "${unclosed", Text: "${unclosed"},
+	}
+	if len(nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes, got: %#v", len(expected), nodes)
+	}
+	for i, n := range nodes {
+		if n.Kind != expected[i].Kind || n.Pos != expected[i].Pos || n.Raw != expected[i].Raw ||
+			n.Text != expected[i].Text || n.Name != expected[i].Name || n.Arg != expected[i].Arg {
+			t.Fatalf("Node %d:\nexpected: %#v\ngot: %#v", i, expected[i], n)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Stash = Stash{"title": "Comments"}
+	if n := tpls.Parse("${# note}"); len(n) != 1 || n[0].Kind != CommentNode {
+		t.Fatalf("Expected a comment node, got: %#v", n)
+	}
+	var out strings.Builder
+	if _, err := tpls.Execute(&out, "comments"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<p>Comments</p>\n<p>end</p>"
+	if out.String() != expected {
+		t.Fatalf("\nexpected:%q\ngot:%q", expected, out.String())
+	}
+}
+
+func TestTrimMarkers(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Stash = Stash{"title": "Trimmed", "item": "one"}
+	var out strings.Builder
+	if _, err := tpls.Execute(&out, "trim"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<main><ul><li>one</li></ul>\n<pre>Trimmed</pre></main>"
+	if out.String() != expected {
+		t.Fatalf("\nexpected:%q\ngot:%q", expected, out.String())
+	}
+	// markers must be separated by whitespace
+	text := "a ${-x} ${y-} ${ - } b"
+	if got := tpls.trimMarkers(text); got != text {
+		t.Fatalf("Text without markers should not change, got: %q", got)
+	}
+}
+
+func TestMinify(t *testing.T) {
+	text := "<ul>\n  <li>${a}</li>\n  <!-- note -->\n  <li><b>x</b> <i>y</i>   z</li>\n</ul>\n" +
+		"<!--[if IE]>ie<![endif]-->\n<pre>\n  keep  <!-- me -->\n</pre>\n<p>\n  text\n</p>"
+	expected := "<ul><li>${a}</li><li><b>x</b> <i>y</i> z</li></ul>" +
+		"<!--[if IE]>ie<![endif]--><pre>\n  keep  <!-- me -->\n</pre><p>\ntext\n</p>"
+	if got := MinifyHTML(text); got != expected {
+		t.Fatalf("\nexpected:%q\ngot:%q", expected, got)
+	}
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "min.htm"), []byte(text), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	defer tpls.wg.Wait()
+	tpls.Minify = true
+	tpls.PostCompile = func(fullPath, text string) string {
+		return strings.ReplaceAll(text, "${a}", "${b}")
+	}
+	compiled, err := tpls.Compile("min")
+	if err != nil {
+		t.Fatalf("Error Compile: %s", err.Error())
+	}
+	if compiled != strings.ReplaceAll(expected, "${a}", "${b}") {
+		t.Fatalf("Wrong compiled template: %q", compiled)
+	}
+}
+
+func TestAST(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	nodes, err := tpls.AST("view")
+	if err != nil {
+		t.Fatalf("Error AST: %s", err.Error())
+	}
+	var includes []Node
+	for _, n := range nodes {
+		if n.Kind == DirectiveNode {
+			includes = append(includes, n)
+		}
+		if n.Name == "wrapper" || n.Text == "content" {
+			t.Fatalf("The wrapper should be applied already: %#v", n)
+		}
+	}
+	if len(includes) != 3 {
+		t.Fatalf("Expected 3 include nodes, got %d", len(includes))
+	}
+	footer := includes[2]
+	if footer.Path != tpls.toFullPath("partials/footer") || len(footer.Nodes) == 0 {
+		t.Fatalf("Include node should have the nodes of the included file: %#v", footer)
+	}
+	if _, err = tpls.AST("nosuchfile"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+}
+
+func TestShareFiles(t *testing.T) {
+	base, _ := New(includePaths[:1], filesExt, tagsPair, true)
+	base.Logger = logger
+	themed, _ := New([]string{includePaths[1], includePaths[0]}, filesExt, tagsPair, false)
+	themed.Logger = logger
+	themed.ShareFiles(base)
+	if themed.files != base.files || base.files.refs != 2 {
+		t.Fatal("files should be shared")
+	}
+	_ = base.ClearCache(false)
+	themed.ShareFiles(base)
+	text, err := themed.LoadFile("layout")
+	if err != nil {
+		t.Fatalf("Error LoadFile: %s", err.Error())
+	}
+	if !strings.Contains(text, "black") {
+		t.Fatalf("the theme layout should be loaded:\n%s", text)
+	}
+	if _, ok := base.files.get(themed.toFullPath("layout")); !ok {
+		t.Fatal("the theme layout should be visible to the other instance")
+	}
+	_ = themed.ClearCache(false)
+	if themed.files != base.files || base.files.refs != 2 || base.files.len() != 0 {
+		t.Fatal("ClearCache should clear the shared cache")
+	}
+}
+
+func TestRecoverTagFuncs(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	tpls.Stash["body"] = TagFunc(func(w io.Writer, tag string) (int, error) {
+		panic("buggy helper")
+	})
+	expectPanic(t, func() { _, _ = tpls.Execute(&out, "view") })
+	tpls.RecoverTagFuncs = true
+	out.Reset()
+	_, err := tpls.Execute(&out, "view")
+	if err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	errstr := err.Error()
+	for _, v := range []string{"buggy helper", "'body'", tpls.toFullPath("view")} {
+		if !strings.Contains(errstr, v) {
+			t.Fatalf("Error should contain %s: %s", v, errstr)
+		}
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	var logs bytes.Buffer
+	tpls.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	tpls.MergeStash(data)
+	tpls.Stash["body"] = strings.Repeat("x", 100)
+	tpls.MaxValueSize = 99
+	out.Reset()
+	if _, err := tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), tpls.Stash["body"].(string)) {
+		t.Fatalf("Oversized value should be written:\n%s", out.String())
+	}
+	if !strings.Contains(logs.String(), "'body'") {
+		t.Fatalf("No warning for oversized value: %s", logs.String())
+	}
+	tpls.MaxValueSizeError = true
+	out.Reset()
+	_, err := tpls.Execute(&out, "view")
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Expected ErrValueTooLarge, got: %v", err)
+	}
+	if strings.Contains(out.String(), tpls.Stash["body"].(string)) {
+		t.Fatalf("Oversized value should not be written:\n%s", out.String())
+	}
+	tpls.MaxValueSize = 100
+	if _, err = tpls.Execute(&out, "view"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+}
+
+func TestHandlePrefix(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.HandlePrefix("i18n.", func(w io.Writer, tag string) (int, error) {
+		return w.Write([]byte("short:" + tag))
+	})
+	tpls.HandlePrefix("i18n.long.", func(w io.Writer, tag string) (int, error) {
+		return w.Write([]byte("wrong"))
+	})
+	tpls.HandlePrefix("i18n.long.", func(w io.Writer, tag string) (int, error) {
+		return w.Write([]byte("long:" + tag))
+	})
+	tpls.Stash = Stash{"i18n.title": "from stash"}
+	text := "${i18n.title}|${i18n.body}|${i18n.long.body}|${other}"
+	var b strings.Builder
+	if _, err := tpls.execute(&execution{ctx: context.Background()}, &b,
+		&compiledFile{segments: []segment{{text: text}}}); err != nil {
+		t.Fatalf("Error execute: %s", err.Error())
+	}
+	expected := "from stash|short:i18n.body|long:i18n.long.body|"
+	if b.String() != expected {
+		t.Fatalf("Expected: %s\nGot: %s", expected, b.String())
+	}
+	if len(tpls.prefixHandlers) != 2 {
+		t.Fatal("registering a prefix again should replace its handler")
+	}
+}
+
+type author struct {
+	Name  string
+	Books int
+	email string
+}
+
+func TestDottedTags(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.HandlePrefix("book.", func(w io.Writer, tag string) (int, error) {
+		return w.Write([]byte("missing"))
+	})
+	tpls.Stash = Stash{
+		"user":         Stash{"name": "Краси", "address": map[string]any{"city": "София"}},
+		"book":         map[string]any{"Author": &author{Name: "Николай Гочев", Books: 3, email: "n@g"}},
+		"tags":         map[string]string{"first": "история"},
+		"literal.path": "literal",
+	}
+	text := "${user.name}|${user.address.city}|${book.Author.Name}|${book.Author.Books}|" +
+		"${book.Author.email}|${tags.first}|${literal.path}|${user.nothing}|${user.name.x}"
+	var b strings.Builder
+	if _, err := tpls.execute(&execution{ctx: context.Background()}, &b,
+		&compiledFile{segments: []segment{{text: text}}}); err != nil {
+		t.Fatalf("Error execute: %s", err.Error())
+	}
+	expected := "Краси|София|Николай Гочев|3|missing|история|literal||"
+	if b.String() != expected {
+		t.Fatalf("Expected: %s\nGot: %s", expected, b.String())
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.RegisterFilter("upper", strings.ToUpper)
+	tpls.RegisterFilter("trim", strings.TrimSpace)
+	tpls.Stash = Stash{
+		"name": "  Гледки ",
+		"func": TagFunc(func(w io.Writer, tag string) (int, error) {
+			return w.Write([]byte(" от функция "))
+		}),
+		"user": Stash{"name": "краси"},
+	}
+	text := "[${name | upper | trim}][${func|trim|upper}][${user.name | upper}][${none | upper}]"
+	var b strings.Builder
+	if _, err := tpls.execute(&execution{ctx: context.Background()}, &b,
+		&compiledFile{segments: []segment{{text: text}}}); err != nil {
+		t.Fatalf("Error execute: %s", err.Error())
+	}
+	expected := "[ГЛЕДКИ][ОТ ФУНКЦИЯ][КРАСИ][]"
+	if b.String() != expected {
+		t.Fatalf("Expected: %s\nGot: %s", expected, b.String())
+	}
+	_, err := tpls.execute(&execution{ctx: context.Background()}, &b,
+		&compiledFile{segments: []segment{{text: "${name | nofilter}"}}})
+	if err == nil || !strings.Contains(err.Error(), "nofilter") {
+		t.Fatalf("Expected error for unknown filter, got: %v", err)
+	}
+}
+
+func TestExecuteStats(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "stats.htm"), []byte("[${title | trim}][${lying}]"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	defer tpls.wg.Wait()
+	tpls.Logger = logger
+	tpls.RegisterFilter("trim", strings.TrimSpace)
+	tpls.Stash = Stash{
+		"title": "  Гледки  ",
+		"lying": TagFunc(func(w io.Writer, tag string) (int, error) {
+			_, err := w.Write([]byte("ab"))
+			return 1000, err
+		}),
+	}
+	var b strings.Builder
+	stats, err := tpls.ExecuteStats(context.Background(), &b, "stats")
+	if err != nil {
+		t.Fatalf("Error ExecuteStats: %s", err.Error())
+	}
+	if stats.Written != int64(b.Len()) || stats.Unfiltered != stats.Written+4 {
+		t.Fatalf("Wrong stats for %q: %#v", b.String(), stats)
+	}
+	b.Reset()
+	if n, _ := tpls.Execute(&b, "stats"); n != int64(b.Len()) {
+		t.Fatalf("Execute returned %d, but %d bytes were written", n, b.Len())
+	}
+}
+
+func TestAutoEscape(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${v}|${v | upper}|${raw}|${f}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "data.json.htm"), []byte(`{"v": "${v}"}`), 0600)
+	_ = os.WriteFile(filepath.Join(root, "note.txt.htm"), []byte(`${v}`), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	defer tpls.wg.Wait()
+	tpls.Logger = logger
+	tpls.RegisterFilter("upper", strings.ToUpper)
+	tpls.Stash = Stash{
+		"v":   `<a href="x">'&'</a>`,
+		"raw": Raw("<b>raw</b>"),
+		"f": TagFunc(func(w io.Writer, tag string) (int, error) {
+			return w.Write([]byte("<i>func</i>"))
+		}),
+	}
+	execute := func(path string) string {
+		var b strings.Builder
+		if _, err := tpls.Execute(&b, path); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		return b.String()
+	}
+	if got := execute("page"); got != `<p><a href="x">'&'</a>|<A HREF="X">'&'</A>|<b>raw</b>|<i>func</i></p>` {
+		t.Fatalf("Nothing should be escaped by default: %s", got)
+	}
+	tpls.AutoEscape = true
+	expected := `<p>&lt;a href=&#34;x&#34;&gt;&#39;&amp;&#39;&lt;/a&gt;|&lt;A HREF=&#34;X&#34;&gt;&#39;&amp;&#39;&lt;/A&gt;|<b>raw</b>|<i>func</i></p>`
+	if got := execute("page"); got != expected {
+		t.Fatalf("\nexpected:%s\ngot:%s", expected, got)
+	}
+	if got := execute("data.json"); got != `{"v": "\u003ca href=\"x\"\u003e'\u0026'\u003c/a\u003e"}` {
+		t.Fatalf("Wrong JSON: %s", got)
+	}
+	if got := execute("note.txt"); got != tpls.Stash["v"] {
+		t.Fatalf("Text should not be escaped: %s", got)
+	}
+	// The output modes of an instance are its own.
+	tpls.OutputModes[".txt"] = EscapeJSON
+	if OutputModes[".txt"] != nil {
+		t.Fatal("The global OutputModes should not be changed")
+	}
+}
+
+func TestMeta(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		"---\ntitle: Home\nlayout: layout\n# a comment\nmode: '.txt'\n---\n<p>${v}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "layout.htm"), []byte(
+		"+++\nkind = \"layout\"\n+++\n<main>${content}</main>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "plain.htm"), []byte("---\n<hr>"), 0600)
+	for i := range 2 {
+		// the second time the compiled template is loaded from disk
+		tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+		tpls.Logger = logger
+		tpls.AutoEscape = true
+		tpls.Stash["v"] = "<b>"
+		meta, err := tpls.Meta("page")
+		if err != nil {
+			t.Fatalf("Error Meta: %s", err.Error())
+		}
+		if len(meta) != 3 || meta["title"] != "Home" || meta["mode"] != ".txt" {
+			t.Fatalf("Wrong meta %d: %#v", i, meta)
+		}
+		var b strings.Builder
+		if _, err = tpls.Execute(&b, "page"); err != nil || b.String() != "<main><p><b></p></main>" {
+			t.Fatalf("Wrong output %d: %q, %v", i, b.String(), err)
+		}
+		if meta, _ = tpls.Meta("plain"); meta != nil {
+			t.Fatalf("A file without closing delimiter has no front matter: %#v", meta)
+		}
+		tpls.wg.Wait()
+	}
+}
+
+func TestFrontMatterOff(t *testing.T) {
+	root := t.TempDir()
+	text := "---\nSubject: Your order\n---\nHello, ${name}!"
+	_ = os.WriteFile(filepath.Join(root, "email.htm"), []byte(text), 0600)
+	for i := range 2 {
+		// the second time the compiled template is loaded from disk
+		tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+		tpls.Logger = logger
+		tpls.FrontMatter = false
+		tpls.Stash["name"] = "Краси"
+		var b strings.Builder
+		if _, err := tpls.Execute(&b, "email"); err != nil || b.String() != "---\nSubject: Your order\n---\nHello, Краси!" {
+			t.Fatalf("The literal --- lines should be kept %d: %q, %v", i, b.String(), err)
+		}
+		if meta, _ := tpls.Meta("email"); meta != nil {
+			t.Fatalf("No front matter should be read %d: %#v", i, meta)
+		}
+		tpls.wg.Wait()
+	}
+	if compiled, _ := os.ReadFile(filepath.Join(root, "email.htm"+CompiledSuffix)); string(compiled) != "---\n---\n"+text {
+		t.Fatalf("The compiled file should keep the text intact: %q", compiled)
+	}
+}
+
+func TestDynamicWrapper(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(
+		"---\nlayout: a\n---\n${wrapper ${layout}}\n<p>page</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "nolayout.htm"), []byte("${wrapper ${layout}}\n<p/>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "a.htm"), []byte("<a>${content}</a>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "b.htm"), []byte("<b>${content}</b>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	execute := func() string {
+		var b strings.Builder
+		if _, err := tpls.Execute(&b, "page"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		return b.String()
+	}
+	if got := execute(); got != "<a><p>page</p></a>" {
+		t.Fatalf("The layout from the front matter should be used: %s", got)
+	}
+	tpls.Stash["layout"] = "b"
+	if _, err := tpls.Execute(io.Discard, "page"); err == nil || !strings.Contains(err.Error(), "not in Gledki.Layouts") {
+		t.Fatalf("A layout, which is not allowed, should be refused: %v", err)
+	}
+	tpls.Layouts = []string{"b"}
+	if got := execute(); got != "<b><p>page</p></b>" {
+		t.Fatalf("The layout from the Stash should be used: %s", got)
+	}
+	tpls.Stash["layout"] = "a"
+	if got := execute(); got != "<a><p>page</p></a>" {
+		t.Fatalf("The layout from the Stash should be used: %s", got)
+	}
+	if len(tpls.compiled) != 2 {
+		t.Fatalf("The template should be cached for every wrapper, got %d", len(tpls.compiled))
+	}
+	for _, layout := range []string{"./b", "b.htm", "nolayout"} {
+		tpls.Stash["layout"] = layout
+		if _, err := tpls.Execute(io.Discard, "page"); err == nil {
+			t.Fatalf("The layout %s should be refused", layout)
+		}
+	}
+	if len(tpls.compiled) != 2 {
+		t.Fatalf("Only the allowed wrappers should be cached, got %d", len(tpls.compiled))
+	}
+	tpls.Stash["layout"] = "a"
+	tpls.wg.Wait()
+	if isReadable(filepath.Join(root, "page.htm"+CompiledSuffix)) {
+		t.Fatal("A template with a wrapper from the Stash should not be stored on disk")
+	}
+	delete(tpls.Stash, "layout")
+	if _, err := tpls.Execute(&out, "nolayout"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	// The regular expression is compiled once for the same tags.
+	other := &Gledki{Tags: [2]string{"<%", "%>"}}
+	if tpls.dynamicWrapperRe() != tpls.Clone().dynamicWrapperRe() ||
+		other.dynamicWrapperRe() == tpls.dynamicWrapperRe() ||
+		!other.dynamicWrapperRe().MatchString("<%wrapper <%layout%>%>") {
+		t.Fatal("The regular expression should be cached by the tags")
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	names, err := tpls.Placeholders("view")
+	if err != nil {
+		t.Fatalf("Error Placeholders: %s", err.Error())
+	}
+	expected := "body,generator,included,lang,title"
+	if strings.Join(names, ",") != expected {
+		t.Fatalf("Expected: %s\nGot: %s", expected, names)
+	}
+	if _, err = tpls.Placeholders("nosuchfile"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+}
+
+func TestLoadTranslations(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if err := tpls.LoadTranslations("testdata/tpls_bad"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+	if err := tpls.LoadTranslations("testdata/i18n"); err != nil {
+		t.Fatalf("Error LoadTranslations: %s", err.Error())
+	}
+	for lang, expected := range map[string][]string{
+		"bg": {"Добре дошли, Краси!", ">Начало<", ">За нас<"},
+		"en": {"Welcome, Краси!", ">Home<", ">menu.about<"},
+		"de": {">welcome_message<", ">menu.home<"},
+	} {
+		tpls.Stash = Stash{"lang": lang, "name": "Краси"}
+		out.Reset()
+		if _, err := tpls.Execute(&out, "l10n"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		for _, v := range expected {
+			if !strings.Contains(out.String(), v) {
+				t.Fatalf("lang %s: output does not contain %s:\n%s", lang, v, out.String())
+			}
+		}
+	}
+}
+
+func TestGettext(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if err := tpls.LoadTranslations("testdata/gettext"); err != nil {
+		t.Fatalf("Error LoadTranslations: %s", err.Error())
+	}
+	for _, tc := range []struct {
+		lang     string
+		count    int
+		expected []string
+	}{
+		{"bg", 1, []string{"Добре дошли, Краси!", ">Начало<", ">menu.about<", ">1 книга<"}},
+		{"bg", 5, []string{">5 книги<"}},
+		{"en", 1, []string{"Welcome, Краси!", ">Home<", ">1 book<"}},
+		{"en", 0, []string{">0 books<"}},
+		{"ru", 21, []string{">21 книга<"}},
+		{"ru", 3, []string{">3 книги<"}},
+		{"ru", 11, []string{">11 книг<"}},
+	} {
+		tpls.SetLocale(tc.lang)
+		tpls.Stash = Stash{"name": "Краси", "count": strconv.Itoa(tc.count)}
+		out.Reset()
+		if _, err := tpls.Execute(&out, "gettext"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		for _, v := range tc.expected {
+			if !strings.Contains(out.String(), v) {
+				t.Fatalf("lang %s: output does not contain %s:\n%s", tc.lang, v, out.String())
+			}
+		}
+	}
+	// The language in the Stash wins over the locale.
+	tpls.SetLocale("ru")
+	tpls.Stash = Stash{"lang": "en", "count": "2"}
+	out.Reset()
+	_, _ = tpls.Execute(&out, "gettext")
+	if !strings.Contains(out.String(), ">2 books<") {
+		t.Fatalf("Stash language is not used:\n%s", out.String())
+	}
+	if text := tpls.Plural("books", 5); text != "${count} книг" {
+		t.Fatalf("Wrong plural form: %s", text)
+	}
+	if text := tpls.Plural("missing", 5); text != "missing" {
+		t.Fatalf("Wrong text for missing translation: %s", text)
+	}
+	for _, expr := range []string{"n ==", "(n", "n ? 1", "n $ 1"} {
+		if _, err := parsePluralRule(expr); err == nil {
+			t.Fatalf("No error for plural rule '%s'", expr)
+		}
+	}
+}
+
+func TestPlural(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	for _, tc := range []struct {
+		lang, count, pages string
+		expected           []string
+	}{
+		{"en", "1", "0", []string{"1 book<", "0 страници<"}},
+		{"bg", "2", "1", []string{"2 books<", "1 страница<"}},
+		{"ru", "21", "5", []string{"21 books<", "5 страници<"}},
+	} {
+		tpls.Stash = Stash{"lang": tc.lang, "count": tc.count, "pages": tc.pages}
+		out.Reset()
+		if _, err := tpls.Execute(&out, "plural"); err != nil {
+			t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+		}
+		for _, v := range tc.expected {
+			if !strings.Contains(out.String(), v) {
+				t.Fatalf("lang %s: output does not contain %s:\n%s", tc.lang, v, out.String())
+			}
+		}
+	}
+	// The rule from a loaded catalog wins.
+	_ = tpls.LoadTranslations("testdata/gettext")
+	tpls.Stash = Stash{"lang": "ru", "count": "21", "pages": "5"}
+	out.Reset()
+	_, _ = tpls.Execute(&out, "plural")
+	for _, v := range []string{"21 book<", "5 страници<"} {
+		if !strings.Contains(out.String(), v) {
+			t.Fatalf("output does not contain %s:\n%s", v, out.String())
+		}
+	}
+	var b bytes.Buffer
+	_, err := tpls.execute(&execution{ctx: context.Background()}, &b,
+		&compiledFile{segments: []segment{{text: "${plural count}"}}})
+	if err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	stats, err := tpls.Describe("book")
+	if err != nil {
+		t.Fatalf("Error Describe: %s", err.Error())
+	}
+	compiled, _ := tpls.Compile("book")
+	// book, partials/_book and partials/footer
+	if stats.Files != 3 || stats.Includes != 2 || stats.Depth != 2 ||
+		stats.Bytes != len(compiled) || stats.Path != tpls.toFullPath("book") {
+		t.Fatalf("Wrong stats: %#v", stats)
+	}
+	stats, _ = tpls.Describe("view")
+	if stats.Files != 3 || stats.Includes != 3 || stats.Depth != 1 {
+		t.Fatalf("Wrong stats: %#v", stats)
+	}
+	if _, err = tpls.Describe("nosuchfile"); err == nil {
+		t.Fatal("No error - this is unexpected!")
+	}
+}
+
+func TestFtExecString(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	partial := `<div class="pager">${prev}${next}</div>`
+	out := tpls.FtExecString(partial, Stash{`prev`: `previous`})
+	if strings.Contains(out, "next") {
+		t.Fatal("String should not contain unused placeholder 'next'!")
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	if _, err := New([]string{"/ala/bala/nica"}, filesExt, tagsPair, false); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "does not exist") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error: errstr")
+		}
+	} else {
+		t.Fatal("No error - this is unexpected!")
+	}
+	tpls, _ := New([]string{includePaths[0] + "/../tpls_bad"}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	out.Reset()
+	if _, err := tpls.Execute(&out, "no_wrapper"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "could not be read") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error: errstr")
+		}
+	} else {
+		t.Fatal("No error - this is unexpected!")
+	}
+
+	out.Reset()
+	if _, err := tpls.Execute(&out, "nosuchfile"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "could not be read") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error: errstr")
+		}
+	} else {
+		t.Fatal("No error - this is unexpected!")
+	}
+
+	out.Reset()
+	if _, err := tpls.Execute(&out, "no_include"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "could not be read") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	} else {
+		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	}
+	out.Reset()
+	if _, err := tpls.Execute(&out, "incl_no_wrapper.htm"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "could not be read") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	} else {
+		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	}
+
+	out.Reset()
+	if _, err := tpls.Execute(&out, "incl_no_include.htm"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "could not be read") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	} else {
+		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	}
+
+	out.Reset()
+	if _, err := tpls.Execute(&out, "wrapper_no_content"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "partials/no_content.htm has no ${content}") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	} else {
+		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	}
+
+	out.Reset()
+	if _, err := tpls.Execute(&out, "two_wrappers"); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "2 wrapper directives in") &&
+			strings.Contains(errstr, "two_wrappers.htm") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	} else {
+		t.Fatalf("No error - this is unexpected! Output: %s", out.String())
+	}
+
+	absRoot, err := filepath.Abs(includePaths[0])
+	if err != nil {
+		t.Fatalf("Error finding absolute path: %s", err.Error())
+	}
+	_ = tpls.findRoots([]string{absRoot})
+	if tpls.Roots[0] == absRoot {
+		t.Logf("Right root: %s", tpls.Roots)
+	} else {
+		t.Logf("Wrong root: Got: %s\n Expected: %s", tpls.Roots[0], absRoot)
+	}
+
+	if err = tpls.findRoots([]string{"../ala/bala"}); err != nil {
+		errstr := err.Error()
+		if strings.Contains(errstr, "does not exist!") {
+			t.Logf("Right error: %s", err.Error())
+		} else {
+			t.Fatalf("Wrong error:%s", errstr)
+		}
+	}
+}
+
+func expectPanic(t *testing.T, f func()) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("MISSING PANIC")
+		} else {
+			t.Log(r)
+		}
+	}()
+	f()
+}
+
+// compiledKey returns the key in Gledki.compiled for the template path,
+// compiled with the default roots.
+func compiledKey(tpls *Gledki, path string) string {
+	key, _ := tpls.cacheKey(tpls.Roots, tpls.toFullPath(path))
+	return key
+}
+
+func TestDebug(t *testing.T) {
+	dir := t.TempDir()
+	for path, text := range map[string]string{
+		"site/page.htm":                "${wrapper layout}<main>${include partials/_header}</main>",
+		"site/layout.htm":              "<body>${content}</body>",
+		"theme/partials/_header.htm":   "<h1>${title}</h1>",
+		"site/data.json.htm":           "${wrapper object.json}${include partials/_item.json}",
+		"site/object.json.htm":         "{${content}}",
+		"site/partials/_item.json.htm": `"title": "${title}"`,
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0700)
+		_ = os.WriteFile(filepath.Join(dir, path), []byte(text), 0600)
+	}
+	tpls, _ := New([]string{"theme::" + filepath.Join(dir, "theme"), filepath.Join(dir, "site")},
+		filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.Stash["title"] = "Гледки"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil || b.String() != "<body><main><h1>Гледки</h1></main></body>" {
+		t.Fatalf("No comments without Debug: %q, %v", b.String(), err)
+	}
+	tpls.Debug = true
+	b.Reset()
+	n, err := tpls.Execute(&b, "page")
+	if err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+	expected := "<body><!-- begin page (site) --><main><!-- begin partials/_header (theme) -->" +
+		"<h1>Гледки</h1><!-- end partials/_header --></main><!-- end page --></body>"
+	if b.String() != expected || n != int64(len(expected)) {
+		t.Fatalf("Wrong boundaries (%d bytes):\n%s", n, b.String())
+	}
+	b.Reset()
+	if _, err := tpls.Execute(&b, "data.json"); err != nil || b.String() != `{"title": "Гледки"}` {
+		t.Fatalf("No comments should be written in JSON: %q, %v", b.String(), err)
+	}
+	tpls.Debug = false
+	b.Reset()
+	if _, err := tpls.Execute(&b, "page"); err != nil || strings.Contains(b.String(), "<!--") {
+		t.Fatalf("The annotated template should not be served without Debug: %q, %v", b.String(), err)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	root := t.TempDir()
+	for path, text := range map[string]string{
+		"page.htm":         "---\ntitle: Page\n---\n${wrapper layout}\n<p>${include partials/ok}</p>",
+		"layout.htm":       "<body>\n${content}\n${include partials/missing}\n</body>",
+		"twice.htm":        "${wrapper layout}\n${wrapper partials/ok}",
+		"nested.htm":       "<main>\n\n${include partials/bad}</main>",
+		"partials/ok.htm":  "ok",
+		"partials/bad.htm": "<aside>\n${include ../../outside}</aside>",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0700)
+		_ = os.WriteFile(filepath.Join(root, path), []byte(text), 0600)
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	defer tpls.wg.Wait()
+	for path, expected := range map[string]SourceError{
+		"page":   {Path: "layout.htm", Line: 3, Directive: "${include partials/missing}"},
+		"twice":  {Path: "twice.htm", Line: 2, Directive: "${wrapper partials/ok}"},
+		"nested": {Path: "partials/bad.htm", Line: 2, Directive: "${include ../../outside}"},
+	} {
+		_, err := tpls.Compile(path)
+		var se *SourceError
+		if !errors.As(err, &se) || se.Path != filepath.Join(root, expected.Path) ||
+			se.Line != expected.Line || se.Directive != expected.Directive {
+			t.Fatalf("Wrong source of the error for %s: %v", path, err)
+		}
+	}
+	if _, err := tpls.Compile("page"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("SourceError should wrap the error: %v", err)
+	}
+	if _, err := tpls.Compile("nested"); !errors.Is(err, ErrOutsideRoot) {
+		t.Fatalf("SourceError should wrap the error: %v", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"),
+		[]byte("<h1>${title}</h1>${user.name}${body}<p>${title | upper}</p>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.RegisterFilter("upper", strings.ToUpper)
+	tpls.Stash["body"] = "body"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Unknown tags should be replaced with nothing: %v", err)
+	}
+	tpls.Strict = true
+	b.Reset()
+	_, err := tpls.Execute(&b, "page")
+	if !errors.Is(err, ErrUnknownTags) || !strings.HasSuffix(err.Error(), ": title, user.name") {
+		t.Fatalf("Wrong error for the unknown tags: %v", err)
+	}
+	if b.String() != "<h1></h1>body<p></p>" {
+		t.Fatalf("The output should be written anyway: %q", b.String())
+	}
+	tpls.Stash["title"] = "Гледки"
+	tpls.Stash["user.name"] = "Краси"
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error executing Gledki.Execute: %s", err.Error())
+	}
+}
+
+func TestLint(t *testing.T) {
+	root := t.TempDir()
+	for path, text := range map[string]string{
+		"page.htm": "---\ntitle: Page\n---\n${wrapper empty}\n${wrapper layout}\n${include missing}\n" +
+			"${include? gone}\n${includ partials/ok}\n<p>${title | upper} ${l10n Hello} ${title</p>",
+		"layout.htm":      "<body>${content}</body>",
+		"empty.htm":       "<body></body>",
+		"loop.htm":        "${include loop}",
+		"wrapped.htm":     "${wrapper wrapped}\n<p>${content}</p>",
+		"partials/ok.htm": "<p>${title}</p>",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0700)
+		_ = os.WriteFile(filepath.Join(root, path), []byte(text), 0600)
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	var logs bytes.Buffer
+	tpls.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	var got []string
+	for _, p := range tpls.Lint() {
+		rel, _ := filepath.Rel(root, p.Path)
+		got = append(got, spf("%s:%d:%s", rel, p.Line, p.Rule))
+	}
+	expected := []string{"loop.htm:0:too-deep", "page.htm:4:missing-content",
+		"page.htm:5:multiple-wrappers", "page.htm:6:unreachable", "page.htm:8:unknown-directive",
+		"page.htm:9:delimiters", "wrapped.htm:0:too-deep"}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("Wrong problems:\n%s", strings.Join(got, "\n"))
+	}
+	if logs.Len() > 0 {
+		t.Fatalf("The problems should not be logged:\n%s", logs.String())
+	}
+	if problems := tpls.Lint("partials/ok", "missing"); len(problems) != 1 ||
+		problems[0].Rule != LintUnreadable {
+		t.Fatalf("Only the missing template should be reported: %v", problems)
+	}
+	tpls.wg.Wait()
+}
+
+func TestAddString(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "layout.htm"), []byte("<body>${content}</body>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "footer.htm"), []byte("<footer>disk</footer>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("${include partials/promo}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if err := tpls.AddString("../up", "x"); err == nil {
+		t.Fatal("A name outside of the roots should be rejected")
+	}
+	_ = tpls.AddString("pages/promo", "${wrapper layout}<h1>${title}</h1>${include footer}")
+	_ = tpls.AddString("partials/promo.htm", "<p>${title}</p>")
+	_ = tpls.AddString("footer", "<footer>memory</footer>")
+	tpls.Stash["title"] = "Гледки"
+	for path, expected := range map[string]string{
+		"pages/promo": "<body><h1>Гледки</h1><footer>memory</footer></body>",
+		"page":        "<p>Гледки</p>",
+	} {
+		var b strings.Builder
+		if _, err := tpls.Execute(&b, path); err != nil || b.String() != expected {
+			t.Fatalf("Wrong output for %s: %q, %v", path, b.String(), err)
+		}
+	}
+	_ = tpls.AddString("pages/promo", "<h2>${title}</h2>")
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "pages/promo"); err != nil || b.String() != "<h2>Гледки</h2>" {
+		t.Fatalf("The template should be replaced: %q, %v", b.String(), err)
+	}
+	// The templates, which include or are wrapped by an added template or by
+	// the file it shadows, are compiled again.
+	_ = os.WriteFile(filepath.Join(root, "home.htm"), []byte("${wrapper layout}${include footer}"), 0600)
+	b.Reset()
+	_, _ = tpls.Execute(&b, "home")
+	_ = tpls.AddString("layout", "<main>${content}</main>")
+	_ = tpls.AddString("footer", "<footer>again</footer>")
+	_ = tpls.AddString("partials/promo", "<b>${title}</b>")
+	for path, expected := range map[string]string{
+		"home": "<main><footer>again</footer></main>",
+		"page": "<b>Гледки</b>",
+	} {
+		b.Reset()
+		if _, err := tpls.Execute(&b, path); err != nil || b.String() != expected {
+			t.Fatalf("Wrong output for %s: %q, %v", path, b.String(), err)
+		}
+	}
+	tpls.wg.Wait()
+	_ = os.Remove(filepath.Join(root, "home.htm"+CompiledSuffix))
+	_ = os.Remove(filepath.Join(root, "home.htm"))
+	// only page.htmc is stored
+	if entries, _ := os.ReadDir(root); len(entries) != 4 {
+		t.Fatalf("Nothing should be stored on disk for the added templates: %v", entries)
+	}
+}
+
+func TestExecuteReader(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "layout.htm"), []byte("<body>${content}</body>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "footer.htm"), []byte("<footer>${site}</footer>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "loop.htm"), []byte("${include loop}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.SetConstants(Stash{"site": "Гледки"})
+	tpls.Stash["title"] = "Stash"
+	for text, expected := range map[string]string{
+		"${wrapper layout}<h1>${title}</h1>${include footer}": "<body><h1>Row</h1><footer>Гледки</footer></body>",
+		"<h2>${site}</h2>": "<h2>Гледки</h2>",
+	} {
+		var b strings.Builder
+		if _, err := tpls.ExecuteReader(&b, strings.NewReader(text), Stash{"title": "Row"}); err != nil ||
+			b.String() != expected {
+			t.Fatalf("Wrong output: %q, %v", b.String(), err)
+		}
+	}
+	var b strings.Builder
+	if _, err := tpls.ExecuteReader(&b, strings.NewReader("${include loop}"), nil); err == nil ||
+		!strings.Contains(err.Error(), "Limit of") {
+		t.Fatalf("A too deep chain should be an error: %v", err)
+	}
+	tpls.wg.Wait()
+}
+
+func TestClone(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.MergeStash(data)
+	var wg sync.WaitGroup
+	outputs := make([]string, 8)
+	for i := range outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := tpls.Clone()
+			c.Stash["title"] = strconv.Itoa(i)
+			var b strings.Builder
+			if _, err := c.Execute(&b, "view"); err != nil {
+				t.Errorf("Error executing Gledki.Execute: %s", err.Error())
+			}
+			outputs[i] = b.String()
+		}()
+	}
+	wg.Wait()
+	for i, out := range outputs {
+		if !strings.Contains(out, "<title>"+strconv.Itoa(i)+"</title>") {
+			t.Fatalf("Every clone should have its own Stash:\n%s", out)
+		}
+	}
+	if tpls.Stash["title"] != data["title"] {
+		t.Fatalf("The Stash of the original should not change: %v", tpls.Stash["title"])
+	}
+	tpls.base().mu.RLock()
+	_, ok := tpls.compiled[compiledKey(tpls, "view")]
+	tpls.base().mu.RUnlock()
+	if !ok {
+		t.Fatal("The compiled templates should be shared")
+	}
+	c := tpls.Clone()
+	c.Roots[0] = "/elsewhere"
+	if tpls.Roots[0] == c.Roots[0] {
+		t.Fatal("The roots should be copied")
+	}
+	tpls.wg.Wait()
+}
+
+// nopMetrics is a Metrics, which is only compared.
+type nopMetrics struct{ Metrics }
+
+func TestCloneSettings(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.CompiledStore = &mapStore{m: map[string]string{}}
+	tpls.Archive = DirArchive(t.TempDir())
+	tpls.DefaultsFS = fstest.MapFS{}
+	tpls.Tracer = &recordingTracer{}
+	tpls.Metrics = nopMetrics{}
+	// Set all other exported settings, so a setting, forgotten in view, is
+	// noticed.
+	bv := reflect.ValueOf(tpls).Elem()
+	for i := range bv.NumField() {
+		f, sf := bv.Field(i), bv.Type().Field(i)
+		if !sf.IsExported() || !f.IsZero() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(7)
+		case reflect.String:
+			f.SetString("x")
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case reflect.Map:
+			f.Set(reflect.MakeMap(f.Type()))
+		case reflect.Func:
+			f.Set(reflect.MakeFunc(f.Type(), func([]reflect.Value) []reflect.Value {
+				return nil
+			}))
+		default:
+			t.Fatalf("Set %s in the test", sf.Name)
+		}
+	}
+	cv := reflect.ValueOf(tpls.Clone()).Elem()
+	for i := range bv.NumField() {
+		f, sf := bv.Field(i), bv.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		c := cv.Field(i)
+		same := reflect.DeepEqual(f.Interface(), c.Interface())
+		if f.Kind() == reflect.Func {
+			same = f.Pointer() == c.Pointer()
+		}
+		if !same {
+			t.Errorf("Clone should copy %s: %v != %v", sf.Name, f, c)
+		}
+	}
+}
+
+func TestPushStash(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"),
+		[]byte("${title}|${set}${title}|${user.name}|${count}${pop}|${title}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.Stash["title"] = "Stash"
+	tpls.Stash["user"] = map[string]any{"name": "Ана"}
+	tpls.Stash["set"] = TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		PushStash(ctx, Stash{"title": "Scope"})
+		PushStash(ctx, Stash{"title": "TagFunc", "user": map[string]any{"name": "Краси"},
+			"count": StashFromContext(ctx)["n"]})
+		return 0, nil
+	})
+	tpls.Stash["pop"] = TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		if data := PopStash(ctx); data["title"] != "TagFunc" {
+			t.Errorf("Wrong scope popped: %v", data)
+		}
+		return 0, nil
+	})
+	if PushStash(context.Background(), Stash{}) || PopStash(context.Background()) != nil {
+		t.Fatal("There is no execution outside of Execute")
+	}
+	// Concurrent requests with a Clone each see only their own scopes.
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var b strings.Builder
+			n := strconv.Itoa(i)
+			ctx := WithStash(context.Background(), Stash{"n": n})
+			if _, err := tpls.Clone().ExecuteWithRootsContext(ctx, &b, "page", nil); err != nil {
+				t.Errorf("Error executing Gledki.Execute: %s", err.Error())
+			}
+			if expected := "Stash|TagFunc|Краси|" + n + "|Scope"; b.String() != expected {
+				t.Errorf("Wrong values from the scopes: %s", b.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if tpls.Stash["title"] != "Stash" {
+		t.Fatalf("The Stash should not change: %v", tpls.Stash["title"])
+	}
+	tpls.wg.Wait()
+}
+func TestStashGetters(t *testing.T) {
+	s := Stash{"title": "Гледки", "raw": Raw("<b>"), "bytes": []byte("b"), "n": int64(42),
+		"sn": " 7 ", "f": 2.9, "yes": true, "sb": "1", "tags": []string{"a", "b"},
+		"ids": [2]int{1, 2}, "any": []any{"x"}}
+	if s.GetString("title", "") != "Гледки" || s.GetString("raw", "") != "<b>" ||
+		s.GetString("bytes", "") != "b" || s.GetString("n", "def") != "def" ||
+		s.GetString("missing", "def") != "def" {
+		t.Fatal("Wrong GetString")
+	}
+	if s.GetInt("n", 0) != 42 || s.GetInt("sn", 0) != 7 || s.GetInt("f", 0) != 2 ||
+		s.GetInt("title", -1) != -1 || s.GetInt("missing", -1) != -1 {
+		t.Fatal("Wrong GetInt")
+	}
+	if !s.GetBool("yes", false) || !s.GetBool("sb", false) || !s.GetBool("title", true) ||
+		s.GetBool("missing", false) {
+		t.Fatal("Wrong GetBool")
+	}
+	if !slices.Equal(s.GetSlice("tags", nil), []any{"a", "b"}) ||
+		!slices.Equal(s.GetSlice("ids", nil), []any{1, 2}) ||
+		!slices.Equal(s.GetSlice("any", nil), []any{"x"}) ||
+		s.GetSlice("bytes", nil) != nil || s.GetSlice("title", []any{}) == nil ||
+		s.GetSlice("missing", nil) != nil {
+		t.Fatal("Wrong GetSlice")
+	}
+}
+
+func TestStashFromJSON(t *testing.T) {
+	s, err := StashFromJSON([]byte(`{"title": "Гледки", "user": {"name": "Ана", "admin": true},
+		"price": 12.50, "tags": ["a", {"id": 10000000000000001}], "none": null}`))
+	if err != nil {
+		t.Fatalf("Error StashFromJSON: %s", err.Error())
+	}
+	expected := Stash{"title": "Гледки", "user.name": "Ана", "user.admin": "true", "price": "12.50",
+		"tags.0": "a", "tags.1.id": "10000000000000001", "none": ""}
+	if !maps.Equal(s, expected) {
+		t.Fatalf("Wrong Stash: %#v", s)
+	}
+	if _, err := StashFromJSON([]byte(`["a"]`)); err == nil {
+		t.Fatal("Only objects should be accepted")
+	}
+	if _, err := StashFromJSON([]byte(`{"a":`)); err == nil {
+		t.Fatal("Invalid JSON should be rejected")
+	}
+}
+
+func TestMergeStashDeep(t *testing.T) {
+	tpls, _ := New(includePaths, filesExt, tagsPair, false)
+	site := Stash{"title": "Site", "meta": Stash{"lang": "bg", "og": map[string]any{"type": "website"}}}
+	tpls.MergeStashDeep(site)
+	tpls.MergeStashDeep(Stash{"meta": Stash{"og": map[string]any{"image": "/a.png"}}})
+	tpls.MergeStashDeep(Stash{"title": "Page", "meta": map[string]any{"lang": "en"}})
+	expected := Stash{"title": "Page", "meta": Stash{"lang": "en",
+		"og": map[string]any{"type": "website", "image": "/a.png"}}}
+	if !reflect.DeepEqual(tpls.Stash, expected) {
+		t.Fatalf("Wrong merged Stash: %#v", tpls.Stash)
+	}
+	if !reflect.DeepEqual(site["meta"], Stash{"lang": "bg", "og": map[string]any{"type": "website"}}) {
+		t.Fatalf("The merged maps should not be modified: %#v", site["meta"])
+	}
+	tpls.MergeStashDeep(Stash{"meta": "none"})
+	if tpls.Stash["meta"] != "none" {
+		t.Fatalf("Values, which are not maps, should override: %#v", tpls.Stash["meta"])
+	}
+}
+
+func TestDefaultStash(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("${site.name}|${year}|${title}"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.DefaultStash = Stash{"site": Stash{"name": "Гледки"}, "year": "2026", "title": "Default"}
+	tpls.Stash["year"] = "2027"
+	for data, expected := range map[string]string{
+		"":      "Гледки|2027|Default",
+		"title": "Гледки|2027|Data",
+	} {
+		var b strings.Builder
+		var stash Stash
+		if data != "" {
+			stash = Stash{data: "Data"}
+		}
+		if _, err := tpls.Clone().ExecuteWithRoots(&b, "page", stash); err != nil || b.String() != expected {
+			t.Fatalf("Wrong output: %q, %v", b.String(), err)
+		}
+	}
+	tpls.wg.Wait()
+}
+
+func TestSetCompiled(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("${include partial}|${title}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "partial.htm"), []byte("<b>${name}</b>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	if _, ok := tpls.Compiled("page"); ok {
+		t.Fatal("page should not be compiled yet")
+	}
+	if _, err := tpls.Compile("page"); err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := tpls.Compiled("page"); !ok || text != "<b>${name}</b>|${title}" {
+		t.Fatalf("Wrong compiled text: %q, %v", text, ok)
+	}
+	if err := tpls.SetCompiled("fixture", "---\nmode: txt\n---\n${include partial}:${title}"); err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := tpls.Compiled("fixture"); !ok || text != "<b>${name}</b>:${title}" {
+		t.Fatalf("Wrong compiled fixture: %q, %v", text, ok)
+	}
+	var b strings.Builder
+	if _, err := tpls.ExecuteWithRoots(&b, "fixture", Stash{"name": "Гледки", "title": "T"}); err != nil ||
+		b.String() != "<b>Гледки</b>:T" {
+		t.Fatalf("Wrong output: %q, %v", b.String(), err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "fixture.htm")); err == nil {
+		t.Fatal("fixture should not be written to disk")
+	}
+	if err := tpls.SetCompiled("fixture", "${include missing}"); err == nil {
+		t.Fatal("expected an error for a missing include")
+	}
+	tpls.wg.Wait()
+}
+
+func TestUnicodePaths(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "части"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "страница.htm"),
+		[]byte("${wrapper части/оформление}${include части\\подвал}|${include? части/няма}"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "части", "оформление.htm"), []byte("<main>${content}</main>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "части", "подвал.htm"), []byte("<footer>${година}</footer>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	for text, arg := range map[string]string{
+		"${include части/подвал}":         "части/подвал",
+		"${include части\\подвал}":        "части/подвал",
+		"${wrapper café-naïve_2.htm}":     "café-naïve_2.htm",
+		"${include? ..\\споделени\\меню}": "../споделени/меню",
+	} {
+		if n := tpls.Parse(text); len(n) != 1 || n[0].Kind != DirectiveNode || n[0].Arg != arg {
+			t.Fatalf("Wrong node for %s: %#v", text, n)
+		}
+	}
+	var b strings.Builder
+	if _, err := tpls.ExecuteWithRoots(&b, "страница", Stash{"година": "2026"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<main><footer>2026</footer>|<!-- failed include: части/няма --></main>"; b.String() != expected {
+		t.Fatalf("Wrong output: %q", b.String())
+	}
+	tpls.wg.Wait()
+}
+
+// slowStore is a CompiledStore, which stores slowly or fails.
+type slowStore struct {
+	mapStore
+	err error
+}
+
+func (s *slowStore) Set(key, text string) error {
+	time.Sleep(50 * time.Millisecond)
+	if s.err != nil {
+		return s.err
+	}
+	return s.mapStore.Set(key, text)
+}
+
+func TestClose(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${title}</p>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	store := &slowStore{mapStore: mapStore{m: make(map[string]string)}}
+	tpls.CompiledStore = store
+	var b strings.Builder
+	// The compiled template is stored by a view, but the instance waits for it.
+	if _, err := tpls.Clone().ExecuteWithRoots(&b, "page", Stash{"title": "Close"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpls.Close(); err != nil {
+		t.Fatalf("Error Close: %s", err.Error())
+	}
+	if _, ok := store.Get(filepath.Join(root, "page.htm")); !ok {
+		t.Fatal("Close should wait for the compiled template to be stored")
+	}
+	if _, ok := tpls.Compiled("page"); ok {
+		t.Fatal("Close should drop the templates from memory")
+	}
+	_ = store.Delete(filepath.Join(root, "page.htm"))
+	store.err = errors.New("disk full")
+	if _, err := tpls.Compile("page"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpls.Close(); err == nil || err.Error() != "disk full" {
+		t.Fatalf("Close should return the error while storing: %v", err)
+	}
+}
+
+// blockingStore is a CompiledStore, which stores only after release is
+// closed.
+type blockingStore struct {
+	mapStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Set(key, text string) error {
+	<-s.release
+	if strings.Contains(key, "broken") {
+		return errors.New("cannot store " + key)
+	}
+	return s.mapStore.Set(key, text)
+}
+
+func TestStoreQueue(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a", "b", "broken"} {
+		_ = os.WriteFile(filepath.Join(root, name+".htm"), []byte("<p>${title}</p>"), 0600)
+	}
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	store := &blockingStore{mapStore: mapStore{m: make(map[string]string)}, release: make(chan struct{})}
+	tpls.CompiledStore = store
+	var failed []string
+	tpls.OnStoreError = func(fullPath string, err error) { failed = append(failed, fullPath) }
+	for _, name := range []string{"a", "b", "broken"} {
+		var b strings.Builder
+		// Execute returns, while the compiled templates cannot be stored yet.
+		if _, err := tpls.Clone().ExecuteWithRoots(&b, name, Stash{"title": name}); err != nil ||
+			b.String() != "<p>"+name+"</p>" {
+			t.Fatalf("Wrong output: %q, %v", b.String(), err)
+		}
+	}
+	close(store.release)
+	if err := tpls.Close(); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Close should return the error while storing: %v", err)
+	}
+	if len(store.m) != 2 || !slices.Equal(failed, []string{filepath.Join(root, "broken.htm")}) {
+		t.Fatalf("Wrong stored templates: %v, failed: %v", store.m, failed)
+	}
+}
+
+func TestCompiledDir(t *testing.T) {
+	root, dir := t.TempDir(), t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "partials", "item.htm"), []byte("<p>${title}</p>"), 0600)
+	tpls, _ := New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.CompiledNextToTemplates = false
+	tpls.CompiledDir = dir
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	if _, err := tpls.Compile("partials/item"); err != nil {
+		t.Fatal(err)
+	}
+	_ = tpls.Close()
+	if _, err := os.Stat(filepath.Join(root, "partials", "item.htm"+CompiledSuffix)); err == nil {
+		t.Fatal("The compiled file should not be next to the template")
+	}
+	compiled, _ := filepath.Glob(filepath.Join(dir, "*", "partials", "item.htm"+CompiledSuffix))
+	if len(compiled) != 1 {
+		t.Fatalf("The compiled file should be in %s: %v", dir, compiled)
+	}
+	if !tpls.isStored(filepath.Join(root, "partials", "item.htm")) {
+		t.Fatal("The compiled file should be found in CompiledDir")
+	}
+	if err := tpls.ClearCache(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(compiled[0]); err == nil {
+		t.Fatal("ClearCache should delete the compiled file in CompiledDir")
+	}
+	// By default the compiled templates are stored in the cache directory of
+	// the user, so the roots may be read-only.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	userDir, _ := UserCompiledDir()
+	tpls, _ = New([]string{root}, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.CompiledNextToTemplates = false
+	if tpls.CompiledDir != "" || dirExists(userDir) {
+		t.Fatal("New should not change CompiledDir or write anything")
+	}
+	if os.Geteuid() != 0 {
+		_ = os.Chmod(filepath.Join(root, "partials"), 0500)
+		defer os.Chmod(filepath.Join(root, "partials"), 0700)
+		_ = os.Chmod(root, 0500)
+		defer os.Chmod(root, 0700)
+	}
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	if _, err := tpls.Compile("partials/item"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpls.Close(); err != nil {
+		t.Fatalf("The compiled template should be stored in UserCompiledDir: %v", err)
+	}
+	compiled, _ = filepath.Glob(filepath.Join(userDir, "*", "partials", "item.htm"+CompiledSuffix))
+	if len(compiled) != 1 || isReadable(filepath.Join(root, "partials", "item.htm"+CompiledSuffix)) {
+		t.Fatalf("The compiled file should be in %s: %v", userDir, compiled)
+	}
+	if !tpls.isStored(filepath.Join(root, "partials", "item.htm")) {
+		t.Fatal("The compiled file should be found in UserCompiledDir")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page.htmc")
+	// Separate stores, like the stores of several processes.
+	texts := make([]string, 8)
+	for i := range texts {
+		texts[i] = strings.Repeat(strconv.Itoa(i), 64<<10)
+	}
+	var wg sync.WaitGroup
+	for _, text := range texts {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := (diskStore{}).Set(path, text); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if got, ok := (diskStore{}).Get(path); ok && !slices.Contains(texts, got) {
+				t.Error("A partially written file was read")
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := (diskStore{}).Get(path); !slices.Contains(texts, got) {
+		t.Fatal("The compiled file should be written completely")
+	}
+	// A rename, which keeps failing, is retried and the temporary file is
+	// removed.
+	_ = os.MkdirAll(filepath.Join(dir, "busy", "child"), 0750)
+	if err := (diskStore{retries: 1}).Set(filepath.Join(dir, "busy"), "text"); err == nil {
+		t.Fatal("Renaming to a directory should fail")
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "busy.*")); len(tmp) != 0 {
+		t.Fatalf("The temporary files should be removed: %v", tmp)
+	}
+}
+
+func TestRootResolution(t *testing.T) {
+	defer func(r Resolution) { RootResolution = r }(RootResolution)
+	binDir, _ := findBinDir()
+	exeRoot := filepath.Join(binDir, "exe_templates")
+	if err := os.Mkdir(exeRoot, 0750); err != nil {
+		t.Skipf("Cannot create a root next to the executable: %s", err.Error())
+	}
+	defer os.RemoveAll(exeRoot)
+	for resolution, found := range map[Resolution][2]bool{
+		ResolveBoth: {true, true},
+		ResolveCwd:  {true, false},
+		ResolveExe:  {false, true},
+	} {
+		RootResolution = resolution
+		_, err := New(includePaths, filesExt, tagsPair, false)
+		if (err == nil) != found[0] {
+			t.Fatalf("Resolution %d for a root in the working directory: %v", resolution, err)
+		}
+		_, err = New([]string{"exe_templates"}, filesExt, tagsPair, false)
+		if (err == nil) != found[1] {
+			t.Fatalf("Resolution %d for a root next to the executable: %v", resolution, err)
+		}
+	}
+	// The roots, added later, are searched with the resolution of the instance.
+	RootResolution = ResolveCwd
+	tpls, _ := New(nil, filesExt, tagsPair, false)
+	if _, err := tpls.WithRoots([]string{"exe_templates"}); err == nil {
+		t.Fatal("The root next to the executable should not be found")
+	}
+	tpls.RootResolution = ResolveExe
+	if _, err := tpls.Clone().WithRoots([]string{"exe_templates"}); err != nil {
+		t.Fatalf("The root next to the executable should be found: %s", err.Error())
+	}
+}
+
+func TestNoExecutable(t *testing.T) {
+	defer func(r Resolution) { RootResolution = r }(RootResolution)
+	defer func(f func() (string, error)) { executable = f }(executable)
+	executable = func() (string, error) { return "", errors.New("not supported") }
+	RootResolution = ResolveBoth
+	tpls, err := New(nil, filesExt, tagsPair, false)
+	if err != nil {
+		t.Fatalf("Error New without roots: %s", err.Error())
+	}
+	var logs bytes.Buffer
+	tpls.Logger = NewStdLogger(log.New(&logs, "", 0))
+	for range 2 {
+		if _, err = tpls.WithRoots(includePaths); err != nil {
+			t.Fatalf("Roots should be found in the working directory: %s", err.Error())
+		}
+	}
+	if strings.Count(logs.String(), "only in the working directory") != 1 {
+		t.Fatalf("The instance should warn once: %q", logs.String())
+	}
+	RootResolution = ResolveExe
+	if _, err = New(includePaths, filesExt, tagsPair, false); err == nil ||
+		!strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("Without the executable the roots should not be searched elsewhere: %v", err)
+	}
+}
+
+func TestNoRoots(t *testing.T) {
+	tpls, err := New(nil, filesExt, tagsPair, false)
+	if err != nil {
+		t.Fatalf("Error New without roots: %s", err.Error())
+	}
+	tpls.Logger = logger
+	tpls.DefaultsFS = fstest.MapFS{
+		"page.htm":          {Data: []byte("${wrapper layout}${include partials/item}")},
+		"layout.htm":        {Data: []byte("<main>${content}</main>")},
+		"partials/item.htm": {Data: []byte("<p>${title}</p>")},
+	}
+	var b strings.Builder
+	if _, err := tpls.ExecuteWithRoots(&b, "page", Stash{"title": "FS"}); err != nil ||
+		b.String() != "<main><p>FS</p></main>" {
+		t.Fatalf("Wrong output: %q, %v", b.String(), err)
+	}
+	if problems := tpls.Lint("page"); len(problems) != 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+	if err := tpls.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dbTemplate is a row in the table with templates for TestGetterFS.
+type dbTemplate struct {
+	text    string
+	version int64
+}
+
+func TestGetterFS(t *testing.T) {
+	var mu sync.Mutex
+	table := map[string]dbTemplate{
+		"page.htm":          {"${wrapper layout}${include partials/item}", 1},
+		"layout.htm":        {"<main>${content}</main>", 1},
+		"partials/item.htm": {"<p>${title}</p>", 1},
+		"other.htm":         {"<p>other</p>", 1},
+	}
+	fsys := GetterFS(TemplateGetterFunc(func(name string) (string, int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		row, ok := table[name]
+		if !ok {
+			return "", 0, fs.ErrNotExist
+		}
+		return row.text, row.version, nil
+	}))
+	if err := fstest.TestFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	tpls, _ := New(nil, filesExt, tagsPair, false)
+	tpls.Logger = logger
+	tpls.DefaultsFS = fsys
+	tpls.DefaultsTTL = time.Nanosecond
+	update := func(name, text string, version int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		table[name] = dbTemplate{text, version}
+	}
+	render := func(expected string) {
+		t.Helper()
+		time.Sleep(time.Millisecond)
+		var b strings.Builder
+		if _, err := tpls.ExecuteWithRoots(&b, "page", Stash{"title": "DB"}); err != nil ||
+			b.String() != expected {
+			t.Fatalf("Wrong output: %q, %v", b.String(), err)
+		}
+	}
+	render("<main><p>DB</p></main>")
+	other, _ := tpls.compileMain("other")
+	// Only a new version invalidates the compiled templates.
+	update("layout.htm", "<article>${content}</article>", 1)
+	render("<main><p>DB</p></main>")
+	update("layout.htm", "<article>${content}</article>", 2)
+	render("<article><p>DB</p></article>")
+	if c, _ := tpls.compileMain("other"); c != other {
+		t.Fatal("Only the changed template and its dependents should be compiled again")
+	}
+	// Without versions the text is compared.
+	update("partials/item.htm", "<b>${title}</b>", 0)
+	render("<article><b>DB</b></article>")
+	update("partials/item.htm", "<i>${title}</i>", 0)
+	render("<article><i>DB</i></article>")
+	if _, err := tpls.Compile("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("A missing template should not exist: %v", err)
+	}
 }
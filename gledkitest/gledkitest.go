@@ -0,0 +1,170 @@
+/*
+Package gledkitest helps to write regression tests for [gledki] templates. New
+makes an instance from templates in memory and Golden compares the output of a
+template with a golden file:
+
+	func TestHome(t *testing.T) {
+		tpls := gledkitest.New(t, gledkitest.Files{
+			"home.htm":   "${wrapper layout}<h1>${title}</h1>",
+			"layout.htm": "<body>${content}</body>",
+		})
+		gledkitest.Golden(t, tpls, "home", gl.Stash{"title": "Гледки"}, "testdata/home.golden")
+	}
+
+Run the tests with -update to write the golden files with the current output:
+
+	go test ./... -update
+*/
+package gledkitest
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	gl "github.com/kberov/gledki"
+)
+
+// Tags are the tags of the instances, made by [New].
+var Tags = [2]string{"${", "}"}
+
+// updateFlag is the name of the flag for updating the golden files. It is
+// defined only if the test binary does not define it already.
+const updateFlag = "update"
+
+func init() {
+	if flag.Lookup(updateFlag) == nil {
+		flag.Bool(updateFlag, false, "write the golden files of gledkitest.Golden")
+	}
+}
+
+// updating tells if the tests are run with -update.
+func updating() bool {
+	f := flag.Lookup(updateFlag)
+	return f != nil && f.Value.String() == "true"
+}
+
+// Files are the templates for [New] – slash-separated path => text, e.g.
+// "partials/footer.htm" => "<footer>${year}</footer>".
+type Files map[string]string
+
+// FS returns files as an in-memory file system.
+func (files Files) FS() fstest.MapFS {
+	fsys := make(fstest.MapFS, len(files))
+	for name, text := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(text), Mode: 0644}
+	}
+	return fsys
+}
+
+// DefaultExt is the extension of the templates of the instances, made by [New]
+// with files without extensions.
+var DefaultExt = ".htm"
+
+// ext returns the sorted unique extensions of files or DefaultExt.
+func (files Files) ext() []string {
+	var ext []string
+	for name := range files {
+		if e := filepath.Ext(name); e != "" && !slices.Contains(ext, e) {
+			ext = append(ext, e)
+		}
+	}
+	if len(ext) == 0 {
+		return []string{DefaultExt}
+	}
+	slices.Sort(ext)
+	return ext
+}
+
+/*
+New returns a [gledki.Gledki] instance with files as its templates. They are
+served from memory as [gledki.Gledki.DefaultsFS] and the instance has no roots,
+so nothing is read from or written to disk. The extensions of the templates are the ones of
+files or [DefaultExt], if files have no extensions. The messages of the logger go to tb.Log.
+*/
+func New(tb testing.TB, files Files) *gl.Gledki {
+	tb.Helper()
+	tpls, err := gl.New(nil, files.ext(), Tags, false)
+	if err != nil {
+		tb.Fatalf("gledkitest: %s", err.Error())
+	}
+	tpls.DefaultsFS = files.FS()
+	tpls.Logger = gl.NewStdLogger(log.New(logWriter{tb}, "", 0))
+	return tpls
+}
+
+// logWriter writes to tb.Log.
+type logWriter struct {
+	tb testing.TB
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.tb.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Render returns the output of the template path, executed with data, which is
+// looked up before the Stash. It fails the test if the template cannot be
+// executed.
+func Render(tb testing.TB, tpls *gl.Gledki, path string, data gl.Stash) string {
+	tb.Helper()
+	var out bytes.Buffer
+	if _, err := tpls.ExecuteWithRoots(&out, path, data); err != nil {
+		tb.Fatalf("gledkitest: executing %s: %s", path, err.Error())
+	}
+	return out.String()
+}
+
+/*
+Golden renders the template path with data like [Render] and compares the
+output with the file golden. The test fails with the first different line if
+they differ. With -update the output is written to golden instead, creating
+its directory if needed.
+*/
+func Golden(tb testing.TB, tpls *gl.Gledki, path string, data gl.Stash, golden string) {
+	tb.Helper()
+	got := Render(tb, tpls, path, data)
+	if updating() {
+		if err := os.MkdirAll(filepath.Dir(golden), 0750); err != nil {
+			tb.Fatalf("gledkitest: %s", err.Error())
+		}
+		if err := os.WriteFile(golden, []byte(got), 0640); err != nil {
+			tb.Fatalf("gledkitest: %s", err.Error())
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		tb.Fatalf("gledkitest: %s (run the tests with -%s to create it)", err.Error(), updateFlag)
+	}
+	if got != string(want) {
+		line, g, w := firstDiff(got, string(want))
+		tb.Errorf("gledkitest: %s differs from %s at line %d:\n got: %q\nwant: %q",
+			path, golden, line, g, w)
+	}
+}
+
+// firstDiff returns the number of the first different line in got and want
+// and the lines.
+func firstDiff(got, want string) (int, string, string) {
+	g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := range max(len(g), len(w)) {
+		var gotLine, wantLine string
+		if i < len(g) {
+			gotLine = g[i]
+		}
+		if i < len(w) {
+			wantLine = w[i]
+		}
+		if gotLine != wantLine || i >= len(g) || i >= len(w) {
+			return i + 1, gotLine, wantLine
+		}
+	}
+	return 0, "", ""
+}
@@ -0,0 +1,72 @@
+package gledkitest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+)
+
+var files = Files{
+	"home.htm":            "${wrapper layout}<h1>${title}</h1>\n${include partials/footer}",
+	"layout.htm":          "<body>\n${content}\n</body>",
+	"partials/footer.htm": "<footer>${year}</footer>",
+	"mail.txt":            "Hello, ${name}!",
+}
+
+// recorder records the failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestNew(t *testing.T) {
+	tpls := New(t, files)
+	if strings.Join(tpls.Ext, ",") != ".htm,.txt" {
+		t.Fatalf("Wrong extensions: %v", tpls.Ext)
+	}
+	tpls.Stash["year"] = "2026"
+	if out := Render(t, tpls, "home", gl.Stash{"title": "Гледки"}); out !=
+		"<body>\n<h1>Гледки</h1>\n<footer>2026</footer>\n</body>" {
+		t.Fatalf("Wrong output: %q", out)
+	}
+	if out := Render(t, tpls, "mail.txt", gl.Stash{"name": "Ана"}); out != "Hello, Ана!" {
+		t.Fatalf("Wrong output: %q", out)
+	}
+	if len(tpls.Roots) != 0 {
+		t.Fatalf("The templates should not be on disk: %v", tpls.Roots)
+	}
+	if tpls = New(t, nil); strings.Join(tpls.Ext, ",") != DefaultExt {
+		t.Fatalf("Without files the extension should be %s: %v", DefaultExt, tpls.Ext)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	tpls := New(t, files)
+	golden := filepath.Join(t.TempDir(), "testdata", "home.golden")
+	data := gl.Stash{"title": "Гледки", "year": "2026"}
+	_ = flag.Set(updateFlag, "true")
+	Golden(t, tpls, "home", data, golden)
+	_ = flag.Set(updateFlag, "false")
+	if want, _ := os.ReadFile(golden); string(want) != Render(t, tpls, "home", data) {
+		t.Fatalf("The golden file should be written with -update: %q", want)
+	}
+	r := &recorder{TB: t}
+	Golden(r, tpls, "home", data, golden)
+	if len(r.errors) != 0 {
+		t.Fatalf("The output should match the golden file: %v", r.errors)
+	}
+	data["year"] = "2027"
+	Golden(r, tpls, "home", data, golden)
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "at line 3") {
+		t.Fatalf("The first different line should be reported: %v", r.errors)
+	}
+}
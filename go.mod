@@ -2,14 +2,6 @@ module github.com/kberov/gledki
 
 go 1.23.1
 
-require (
-	github.com/labstack/gommon v0.4.2
-	github.com/valyala/fasttemplate v1.2.2
-)
+require github.com/valyala/fasttemplate v1.2.2
 
-require (
-	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	golang.org/x/sys v0.25.0 // indirect
-)
+require github.com/valyala/bytebufferpool v1.0.0 // indirect
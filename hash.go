@@ -0,0 +1,46 @@
+package gledki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+// Hasher is the default value for [Gledki.Hasher]. SHA-256 is FIPS-approved.
+// Use [FastHasher] where speed matters more.
+var Hasher func() hash.Hash = sha256.New
+
+// FastHasher returns a 64-bit FNV-1a hash – fast, but not cryptographic.
+func FastHasher() hash.Hash {
+	return fnv.New64a()
+}
+
+// CacheKeyFunc returns the key of the template fullPath, compiled with roots,
+// in the cache of compiled templates. Different roots must give different
+// keys, because the included files and wrappers are searched in them. See
+// [Gledki.CacheKey].
+type CacheKeyFunc func(roots []string, fullPath string) string
+
+// JoinedKey is the default [CacheKeyFunc]. It joins the roots and the path, so
+// the keys are readable, but long.
+func JoinedKey(roots []string, fullPath string) string {
+	return strings.Join(roots, string(filepath.ListSeparator)) + "\n" + fullPath
+}
+
+// HashedKey returns a [CacheKeyFunc], which hashes the roots and the path with
+// newHash to a short key.
+func HashedKey(newHash func() hash.Hash) CacheKeyFunc {
+	return func(roots []string, fullPath string) string {
+		return fingerprint(newHash, []byte(JoinedKey(roots, fullPath)))
+	}
+}
+
+// fingerprint returns the hex encoded hash of data.
+func fingerprint(newHash func() hash.Hash, data []byte) string {
+	h := newHash()
+	_, _ = h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
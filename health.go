@@ -0,0 +1,161 @@
+package gledki
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// MaxClockSkew is the biggest allowed difference between the clock of the
+// application and the modification time of a file, written in a root, before
+// [Gledki.Ready] reports a clock skew. A skew makes the modification times of
+// the compiled files unreliable. It is the default for [Gledki.MaxClockSkew].
+var MaxClockSkew = 5 * time.Second
+
+// CacheHealth contains metrics about storing the compiled templates on disk.
+type CacheHealth struct {
+	// Number of compiled templates, stored on disk.
+	Stored uint64
+	// Number of compiled templates, which could not be stored.
+	Failed uint64
+	// The last error while storing a compiled template.
+	LastError error
+	// When LastError occurred.
+	LastErrorAt time.Time
+	// When a compiled template was stored successfully for the last time.
+	LastStoredAt time.Time
+}
+
+// cacheHealth collects CacheHealth. It is safe for concurrent use.
+type cacheHealth struct {
+	mu sync.Mutex
+	CacheHealth
+}
+
+func (h *cacheHealth) stored() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Stored++
+	h.LastStoredAt = time.Now()
+}
+
+func (h *cacheHealth) failed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Failed++
+	h.LastError = err
+	h.LastErrorAt = time.Now()
+}
+
+// CacheHealth returns the metrics about storing the compiled templates on
+// disk.
+func (t *Gledki) CacheHealth() CacheHealth {
+	h := &t.base().health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.CacheHealth
+}
+
+/*
+Ready checks if the compiled templates can be stored on disk and returns the
+found problems – e.g. a full disk, permission errors or a clock skew. A small
+file is written and deleted in [Gledki.CompiledDir] (or in every root, see
+[Gledki.CompiledNextToTemplates]) for the check. Ready returns also
+the last error while storing a compiled template, if no template was stored
+successfully after it. Use it in the readiness probe of the application
+instead of discovering the problems in the logs. It returns nil if caching is
+disabled or the templates are frozen.
+
+If [Gledki.CompiledStore] is set, the disk is not probed. The store is pinged
+instead, if it implements [Pinger].
+*/
+func (t *Gledki) Ready() error {
+	if !t.CacheTemplates || t.frozen() {
+		return nil
+	}
+	var errs []error
+	dir, store := t.compiledDir(), t.CompiledStore != nil
+	h := t.CacheHealth()
+	if h.LastError != nil && h.LastErrorAt.After(h.LastStoredAt) {
+		errs = append(errs, h.LastError)
+	}
+	for _, root := range t.Roots {
+		if !dirExists(root) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrRootMissing, root))
+			continue
+		}
+		if dir != "" || store {
+			continue
+		}
+		if err := t.probeRoot(root); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p, ok := t.CompiledStore.(Pinger); ok {
+		if err := p.Ping(); err != nil {
+			errs = append(errs, fmt.Errorf("compiled templates cannot be stored: %w", err))
+		}
+	}
+	if dir != "" && !store {
+		err := os.MkdirAll(dir, 0750)
+		if err == nil {
+			err = t.probeRoot(dir)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// probeRoot writes and deletes a file in root and checks its modification
+// time against t.MaxClockSkew.
+func (t *Gledki) probeRoot(root string) error {
+	fh, err := os.CreateTemp(root, ".gledki-probe-*")
+	if err != nil {
+		return fmt.Errorf("compiled templates cannot be stored in %s: %w", root, err)
+	}
+	defer os.Remove(fh.Name())
+	_, err = fh.Write([]byte("probe"))
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("compiled templates cannot be stored in %s: %w", root, err)
+	}
+	finfo, err := os.Stat(fh.Name())
+	if err != nil {
+		return err
+	}
+	if skew := time.Since(finfo.ModTime()).Abs(); skew > t.MaxClockSkew {
+		return fmt.Errorf("clock skew of %s in %s", skew.Round(time.Second), root)
+	}
+	return nil
+}
+
+/*
+Close is the clean shutdown point for servers. It stops the shadow rendering
+(see [Gledki.StartShadow]), waits for the compiled templates, which are being
+stored in goroutines, and for the rendered pages, which are being archived,
+and drops the templates from memory, unless they are frozen. It returns the last
+error while storing a compiled template, if no template was stored successfully
+after it. A view closes the instance it was created from. The instance may be
+used after Close – the templates are loaded again.
+*/
+func (t *Gledki) Close() error {
+	b := t.base()
+	b.StopShadow()
+	b.wg.Wait()
+	b.WaitArchive()
+	if !b.frozen() {
+		// It can fail only while deleting the compiled files.
+		_ = b.ClearCache(false)
+	}
+	h := b.CacheHealth()
+	if h.LastError != nil && h.LastErrorAt.After(h.LastStoredAt) {
+		return h.LastError
+	}
+	return nil
+}
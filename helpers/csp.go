@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	gl "github.com/kberov/gledki"
+)
+
+// nonceKey is the key for the CSP nonce in the context.
+type nonceKey struct{}
+
+// WithNonce returns a copy of ctx with a new random nonce for the
+// Content-Security-Policy of a response and the nonce. Put the nonce in the
+// header and pass the context to [gledki.Gledki.ExecuteContext], so the same
+// nonce is written in the markup by [Nonce]:
+//
+//	ctx, nonce := helpers.WithNonce(r.Context())
+//	w.Header().Set("Content-Security-Policy", "script-src 'nonce-"+nonce+"'")
+//	tpls.ExecuteContext(ctx, w, "page")
+func WithNonce(ctx context.Context) (context.Context, string) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	nonce := base64.StdEncoding.EncodeToString(b)
+	return context.WithValue(ctx, nonceKey{}, nonce), nonce
+}
+
+// NonceFromContext returns the nonce, put in ctx by [WithNonce], or "".
+func NonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// Nonce returns a TagFuncCtx, which writes the nonce, put in the context of
+// the execution by [WithNonce]. Put it in the Stash for the inline scripts:
+//
+//	tpls.Stash["nonce"] = helpers.Nonce()
+//
+// and `<script nonce="${nonce}">`. It returns an error if there is no nonce
+// in the context, because a script without it would be blocked by the
+// browsers.
+func Nonce() gl.TagFuncCtx {
+	return func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		nonce := NonceFromContext(ctx)
+		if nonce == "" {
+			return 0, errors.New("no CSP nonce in the context – see helpers.WithNonce")
+		}
+		return io.WriteString(w, nonce)
+	}
+}
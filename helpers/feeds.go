@@ -0,0 +1,149 @@
+package helpers
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+// XML escapes s for use in XML text and attribute values.
+func XML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// JoinURL joins base and path with exactly one slash between them. path is
+// returned unchanged if it is an absolute URL.
+func JoinURL(base, path string) string {
+	if u, err := url.Parse(path); err == nil && u.IsAbs() {
+		return path
+	}
+	if path == "" {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// URL is a page in a sitemap.
+type URL struct {
+	// Absolute URL or path, joined to the base URL of the site.
+	Path string
+	// When the page was modified. Optional.
+	Modified time.Time
+	// How often the page changes – "daily", "weekly", etc. Optional.
+	ChangeFreq string
+	// Priority of the page, from 0.0 to 1.0. Optional.
+	Priority float64
+}
+
+type sitemapURL struct {
+	XMLName    xml.Name `xml:"url"`
+	Loc        string   `xml:"loc"`
+	LastMod    string   `xml:"lastmod,omitempty"`
+	ChangeFreq string   `xml:"changefreq,omitempty"`
+	Priority   string   `xml:"priority,omitempty"`
+}
+
+// Sitemap returns a TagFunc, which writes the `<url>` elements of a
+// sitemap.xml for urls. The paths are joined to base. Put it in the Stash
+// for a template with the `<urlset>` element:
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">${urls}</urlset>
+func Sitemap(base string, urls []URL) gl.TagFunc {
+	return func(w io.Writer, tag string) (int, error) {
+		elements := make([]sitemapURL, len(urls))
+		for i, u := range urls {
+			elements[i] = sitemapURL{Loc: JoinURL(base, u.Path), LastMod: formatTime(u.Modified, time.RFC3339),
+				ChangeFreq: u.ChangeFreq}
+			if u.Priority > 0 {
+				elements[i].Priority = strconv.FormatFloat(u.Priority, 'f', 1, 64)
+			}
+		}
+		return writeXML(w, elements)
+	}
+}
+
+// Entry is an entry of an Atom feed or an item of an RSS feed.
+type Entry struct {
+	Title string
+	// Absolute URL or path, joined to the base URL of the site. It is used
+	// also as the unique id of the entry.
+	Path    string
+	Summary string
+	// Not written in RSS feeds, where the author must be an email address.
+	Author  string
+	Updated time.Time
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	XMLName xml.Name `xml:"entry"`
+	Title   string   `xml:"title"`
+	Link    atomLink `xml:"link"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary,omitempty"`
+	Author  string   `xml:"author>name,omitempty"`
+}
+
+// AtomEntries returns a TagFunc, which writes the `<entry>` elements of an
+// Atom feed for entries. The paths are joined to base.
+func AtomEntries(base string, entries []Entry) gl.TagFunc {
+	return func(w io.Writer, tag string) (int, error) {
+		elements := make([]atomEntry, len(entries))
+		for i, e := range entries {
+			link := JoinURL(base, e.Path)
+			elements[i] = atomEntry{Title: e.Title, Link: atomLink{link}, ID: link,
+				Updated: formatTime(e.Updated, time.RFC3339), Summary: e.Summary, Author: e.Author}
+		}
+		return writeXML(w, elements)
+	}
+}
+
+type rssItem struct {
+	XMLName     xml.Name `xml:"item"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate,omitempty"`
+	Description string   `xml:"description,omitempty"`
+}
+
+// RSSItems returns a TagFunc, which writes the `<item>` elements of an RSS
+// feed for entries. The paths are joined to base.
+func RSSItems(base string, entries []Entry) gl.TagFunc {
+	return func(w io.Writer, tag string) (int, error) {
+		elements := make([]rssItem, len(entries))
+		for i, e := range entries {
+			link := JoinURL(base, e.Path)
+			elements[i] = rssItem{Title: e.Title, Link: link, GUID: link,
+				PubDate: formatTime(e.Updated, time.RFC1123Z), Description: e.Summary}
+		}
+		return writeXML(w, elements)
+	}
+}
+
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+func writeXML(w io.Writer, v any) (int, error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(data)
+}
@@ -0,0 +1,113 @@
+/*
+Package helpers provides common filters and TagFuncs for [gledki] templates –
+dates, numbers, strings, sitemaps and feeds. Every project reimplements these, so they are
+shipped here. Use [Register] to make all filters available under their usual
+names or the factories to register filters with other settings:
+
+	tpls.RegisterFilter("short", helpers.Truncate(20))
+*/
+package helpers
+
+import (
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gl "github.com/kberov/gledki"
+)
+
+// DateLayout is the layout, used by the "date" filter, registered by
+// [Register].
+var DateLayout = "2006-01-02"
+
+// TruncateLength is the number of characters, kept by the "truncate" filter,
+// registered by [Register].
+var TruncateLength = 80
+
+// Register registers in t the filters upper, lower, trim, nl2br, comma,
+// truncate (see [TruncateLength]), date (see [DateLayout]), xml (see [XML]) and
+// the dates for feeds – rfc3339 for Atom and sitemaps and rfc1123 for RSS.
+func Register(t *gl.Gledki) {
+	t.RegisterFilter("upper", strings.ToUpper)
+	t.RegisterFilter("lower", strings.ToLower)
+	t.RegisterFilter("trim", strings.TrimSpace)
+	t.RegisterFilter("nl2br", Nl2br)
+	t.RegisterFilter("comma", Comma(","))
+	t.RegisterFilter("truncate", Truncate(TruncateLength))
+	t.RegisterFilter("date", Date(DateLayout))
+	t.RegisterFilter("xml", XML)
+	t.RegisterFilter("rfc3339", Date(time.RFC3339))
+	t.RegisterFilter("rfc1123", Date(time.RFC1123Z))
+}
+
+// Nl2br inserts `<br>` before every new line in s.
+func Nl2br(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "<br>\n")
+}
+
+// Truncate returns a filter, which cuts its input to n characters (not bytes)
+// and appends "…" if something was cut.
+func Truncate(n int) gl.Filter {
+	return func(s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		return string([]rune(s)[:n]) + "…"
+	}
+}
+
+// Comma returns a filter, which groups the digits of the integer part of a
+// number by three using sep, e.g. "-1234567.89" => "-1,234,567.89". Input,
+// which is not a number, is returned unchanged.
+func Comma(sep string) gl.Filter {
+	return func(s string) string {
+		number := strings.TrimSpace(s)
+		sign := ""
+		if strings.HasPrefix(number, "-") || strings.HasPrefix(number, "+") {
+			sign, number = number[:1], number[1:]
+		}
+		integer, fraction, hasFraction := strings.Cut(number, ".")
+		if integer == "" || strings.Trim(integer, "0123456789") != "" ||
+			strings.Trim(fraction, "0123456789") != "" {
+			return s
+		}
+		var b strings.Builder
+		b.WriteString(sign)
+		for i, digit := range integer {
+			if i > 0 && (len(integer)-i)%3 == 0 {
+				b.WriteString(sep)
+			}
+			b.WriteRune(digit)
+		}
+		if hasFraction {
+			b.WriteString("." + fraction)
+		}
+		return b.String()
+	}
+}
+
+// Date returns a filter, which parses its input as a date in RFC 3339 format
+// (with or without time) and formats it using layout. Input, which cannot be
+// parsed, is returned unchanged.
+func Date(layout string) gl.Filter {
+	return func(s string) string {
+		for _, in := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+			if d, err := time.Parse(in, strings.TrimSpace(s)); err == nil {
+				return d.Format(layout)
+			}
+		}
+		return s
+	}
+}
+
+// Now returns a TagFunc, which writes the current time formatted using
+// layout. Put it in the Stash, e.g. for the year in a copyright notice:
+//
+//	tpls.Stash["year"] = helpers.Now("2006")
+func Now(layout string) gl.TagFunc {
+	return func(w io.Writer, tag string) (int, error) {
+		return w.Write([]byte(time.Now().Format(layout)))
+	}
+}
@@ -0,0 +1,122 @@
+package helpers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+func TestFilters(t *testing.T) {
+	cases := []struct {
+		f        gl.Filter
+		in, want string
+	}{
+		{Nl2br, "a\nб\r\nв", "a<br>\nб<br>\nв"},
+		{Truncate(5), "Историософия", "Истор…"},
+		{Truncate(20), "Историософия", "Историософия"},
+		{Comma(","), "1234567", "1,234,567"},
+		{Comma(","), "-1234567.891", "-1,234,567.891"},
+		{Comma(" "), "123", "123"},
+		{Comma(","), "12a3", "12a3"},
+		{Date("02.01.2006"), "2024-09-29", "29.09.2024"},
+		{Date("02.01.2006 15:04"), "2024-09-29T10:30:00Z", "29.09.2024 10:30"},
+		{Date("02.01.2006"), "вчера", "вчера"},
+	}
+	for _, c := range cases {
+		if got := c.f(c.in); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	gl.CacheTemplates = false
+	defer func() { gl.CacheTemplates = true }()
+	tpls, err := gl.New([]string{"../testdata/tpls"}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	Register(tpls)
+	tpls.Stash = gl.Stash{
+		"title": "  Гледки ",
+		"price": "12345.50",
+		"date":  "2024-09-29",
+		"year":  Now("2006"),
+	}
+	var out strings.Builder
+	if _, err := tpls.Execute(&out, "filters"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	want := "ГЛЕДКИ|12,345.50|2024-09-29|" + time.Now().Format("2006")
+	if out.String() != want {
+		t.Fatalf("Expected: %s\nGot: %s", want, out.String())
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	if got := XML(`Tom & "Jerry" <3`); got != "Tom &amp; &#34;Jerry&#34; &lt;3" {
+		t.Errorf("Wrong XML: %s", got)
+	}
+	for _, c := range [][3]string{
+		{"https://example.com/", "/blog/", "https://example.com/blog/"},
+		{"https://example.com", "blog", "https://example.com/blog"},
+		{"https://example.com", "https://cdn.example.com/a", "https://cdn.example.com/a"},
+		{"https://example.com/", "", "https://example.com/"},
+	} {
+		if got := JoinURL(c[0], c[1]); got != c[2] {
+			t.Errorf("JoinURL(%q, %q): expected %q, got %q", c[0], c[1], c[2], got)
+		}
+	}
+	updated := time.Date(2024, 9, 29, 10, 30, 0, 0, time.UTC)
+	entries := []Entry{{Title: "Q&A", Path: "/qa", Summary: "<b>Why?</b>", Author: "Ана", Updated: updated}}
+	cases := []struct {
+		f    gl.TagFunc
+		want string
+	}{
+		{Sitemap("https://example.com/", []URL{{Path: "/", Modified: updated, Priority: 1}, {Path: "/a?b=1&c=2"}}),
+			"<url><loc>https://example.com/</loc><lastmod>2024-09-29T10:30:00Z</lastmod>" +
+				"<priority>1.0</priority></url><url><loc>https://example.com/a?b=1&amp;c=2</loc></url>"},
+		{AtomEntries("https://example.com", entries),
+			`<entry><title>Q&amp;A</title><link href="https://example.com/qa"></link>` +
+				"<id>https://example.com/qa</id><updated>2024-09-29T10:30:00Z</updated>" +
+				"<summary>&lt;b&gt;Why?&lt;/b&gt;</summary><author><name>Ана</name></author></entry>"},
+		{RSSItems("https://example.com", entries),
+			"<item><title>Q&amp;A</title><link>https://example.com/qa</link><guid>https://example.com/qa</guid>" +
+				"<pubDate>Sun, 29 Sep 2024 10:30:00 +0000</pubDate>" +
+				"<description>&lt;b&gt;Why?&lt;/b&gt;</description></item>"},
+	}
+	for _, c := range cases {
+		var b strings.Builder
+		if _, err := c.f(&b, "entries"); err != nil || b.String() != c.want {
+			t.Errorf("\nexpected:%s\ngot:%s, %v", c.want, b.String(), err)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte(`<script nonce="${nonce}"></script>`), 0600)
+	tpls, _ := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	tpls.CacheTemplates = false
+	tpls.Stash["nonce"] = Nonce()
+	ctx, nonce := WithNonce(context.Background())
+	if len(nonce) < 16 || NonceFromContext(ctx) != nonce {
+		t.Fatalf("Wrong nonce: %q", nonce)
+	}
+	if _, other := WithNonce(context.Background()); other == nonce {
+		t.Fatal("The nonces should be random")
+	}
+	var b strings.Builder
+	if _, err := tpls.ExecuteContext(ctx, &b, "page"); err != nil ||
+		b.String() != `<script nonce="`+nonce+`"></script>` {
+		t.Fatalf("Wrong output: %s, %v", b.String(), err)
+	}
+	if _, err := tpls.Execute(&b, "page"); err == nil {
+		t.Fatal("A missing nonce should be an error")
+	}
+}
@@ -0,0 +1,255 @@
+package gledki
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LangKey is the key in the [Stash], which holds the language for the
+// translations, looked up by `${l10n key}` tags.
+var LangKey = "lang"
+
+// catalog holds the translations for one language.
+type catalog struct {
+	// key => forms; the first form is the singular one
+	messages map[string][]string
+	// returns the index of the plural form for n
+	plural func(n int) int
+}
+
+// lang => catalog
+type translations map[string]*catalog
+
+/*
+LoadTranslations loads all translation files from dir. Every file holds the
+translations for one language and is named after it: `bg.json`, `en.po`,
+`de.mo`. Translations are merged into the already loaded ones.
+
+JSON files contain an object with translations as values. Nested objects are
+flattened, so `{"menu": {"home": "Начало"}}` gives the key "menu.home". An
+array of strings holds the plural forms of a message.
+
+Gettext catalogs (`.po` and `.mo`) can be edited by translators with Poedit,
+Weblate etc. The msgid is the key. If there is a msgctxt, the key is
+"msgctxt.msgid". Fuzzy entries are skipped. The plural forms and the rule
+for choosing them are taken from the catalog. For JSON files, the rule is
+taken from [PluralRules].
+
+In templates `${l10n welcome_message}` is replaced with the translation for
+the language, found in the Stash under [LangKey] or set by
+[Gledki.SetLocale]. `${l10n books count}` chooses the plural form by the
+number in the Stash under "count". If there is no translation, the key itself
+is written. Translations may contain placeholders, which are replaced like the
+placeholders in the template.
+*/
+func (t *Gledki) LoadTranslations(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.*"))
+	if err != nil {
+		return err
+	}
+	loaded := 0
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		lang := strings.TrimSuffix(filepath.Base(file), ext)
+		switch ext {
+		case ".json":
+			err = t.loadJSON(lang, file)
+		case ".po":
+			err = t.loadPO(lang, file)
+		case ".mo":
+			err = t.loadMO(lang, file)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("translation file '%s': %w", file, err)
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return fmt.Errorf("no translation files found in '%s'", dir)
+	}
+	return nil
+}
+
+// SetLocale sets the language for the translations, used when there is no
+// language in the Stash under [LangKey].
+func (t *Gledki) SetLocale(lang string) {
+	t.locale = lang
+}
+
+// Plural returns the translation for key in the language, set by
+// [Gledki.SetLocale], in the plural form for n. If there is no translation,
+// the key itself is returned. Useful in TagFuncs.
+func (t *Gledki) Plural(key string, n int) string {
+	return t.translation(t.locale, key, n)
+}
+
+// translation returns the plural form for n of the translation for key in
+// lang or the key itself.
+func (t *Gledki) translation(lang, key string, n int) string {
+	c, ok := t.translations[lang]
+	if !ok {
+		return key
+	}
+	forms, ok := c.messages[key]
+	if !ok || len(forms) == 0 {
+		return key
+	}
+	i := c.plural(n)
+	if i < 0 || i >= len(forms) {
+		i = 0
+	}
+	return forms[i]
+}
+
+func (t *Gledki) catalog(lang string) *catalog {
+	if t.translations == nil {
+		t.translations = make(translations)
+	}
+	c, ok := t.translations[lang]
+	if !ok {
+		c = &catalog{messages: make(map[string][]string), plural: pluralRule(lang)}
+		t.translations[lang] = c
+	}
+	return c
+}
+
+// PluralRules contains functions, which return the index of the plural form
+// for a number in a language. They are used by `${plural count item items}`
+// and as the default rule for translations without Plural-Forms. Add rules for
+// other languages as needed. Languages without a rule use the English one.
+var PluralRules = map[string]func(n int) int{
+	// книга, книги; 0 книги
+	"bg": oneOther,
+	// book, books; 0 books
+	"en": oneOther,
+}
+
+func oneOther(n int) int {
+	if n != 1 {
+		return 1
+	}
+	return 0
+}
+
+// pluralRule returns the rule for the plural forms in lang.
+func pluralRule(lang string) func(n int) int {
+	if rule, ok := PluralRules[lang]; ok {
+		return rule
+	}
+	return oneOther
+}
+
+// plural writes the form, chosen from the `plural` tag with arguments args,
+// to w. args is the name of a number in the Stash followed by the forms for
+// the language of the execution.
+func (t *Gledki) plural(e *execution, w io.Writer, args string) (int, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("no forms in tag 'plural %s'", args)
+	}
+	n, _ := toInt(t.lookup(e, fields[0]))
+	forms := fields[1:]
+	rule := pluralRule(t.lang(e))
+	if c, ok := t.translations[t.lang(e)]; ok {
+		rule = c.plural
+	}
+	i := min(max(rule(n), 0), len(forms)-1)
+	return w.Write([]byte(forms[i]))
+}
+
+func (t *Gledki) loadJSON(lang, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var messages map[string]any
+	if err = json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+	addJSONMessages(t.catalog(lang), "", messages)
+	return nil
+}
+
+func addJSONMessages(c *catalog, prefix string, messages map[string]any) {
+	for k, v := range messages {
+		switch v := v.(type) {
+		case map[string]any:
+			addJSONMessages(c, prefix+k+".", v)
+		case []any:
+			forms := make([]string, len(v))
+			for i, form := range v {
+				forms[i] = fmt.Sprint(form)
+			}
+			c.messages[prefix+k] = forms
+		default:
+			c.messages[prefix+k] = []string{fmt.Sprint(v)}
+		}
+	}
+}
+
+// lang returns the language for the translations in the current execution.
+func (t *Gledki) lang(e *execution) string {
+	if lang, ok := t.lookup(e, LangKey).(string); ok && lang != "" {
+		return lang
+	}
+	return t.locale
+}
+
+// translate writes the translation for the `l10n` tag with arguments args to
+// w, replacing the placeholders in it using tagFunc. args is the key,
+// optionally followed by the name of a number in the Stash for choosing the
+// plural form.
+func (t *Gledki) translate(e *execution, w io.Writer, tagFunc TagFunc, args string) (int, error) {
+	key, countKey, _ := strings.Cut(args, " ")
+	n := 1
+	if countKey = strings.TrimSpace(countKey); countKey != "" {
+		n, _ = toInt(t.lookup(e, countKey))
+	}
+	text := t.translation(t.lang(e), key, n)
+	written, err := ftExecFunc(text, t.Tags[0], t.Tags[1], w, tagFunc)
+	return int(written), err
+}
+
+// toInt converts numbers and numeric strings to int.
+func toInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	case float32:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		return n, err == nil
+	case []byte:
+		n, err := strconv.Atoi(strings.TrimSpace(string(v)))
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package gledki
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// dynamicWrapper is a `${wrapper ${key}}` directive in a template.
+type dynamicWrapper struct {
+	// the key in the Stash with the path to the wrapper
+	key string
+	// the layout from the front matter, used if there is no value for key
+	fallback string
+}
+
+// dynamicWrapperRes caches the regular expressions, returned by
+// Gledki.dynamicWrapperRe, by Gledki.Tags.
+var dynamicWrapperRes sync.Map
+
+// dynamicWrapperRe returns the regular expression for `${wrapper ${key}}`
+// with the current Gledki.Tags.
+func (t *Gledki) dynamicWrapperRe() *regexp.Regexp {
+	if re, ok := dynamicWrapperRes.Load(t.Tags); ok {
+		return re.(*regexp.Regexp)
+	}
+	start, end := regexp.QuoteMeta(t.Tags[0]), regexp.QuoteMeta(t.Tags[1])
+	re, _ := dynamicWrapperRes.LoadOrStore(t.Tags,
+		regexp.MustCompile(start+`wrapper\s+`+start+`\s*([\pL\pN_.]+)\s*`+end+`\s*`+end))
+	return re.(*regexp.Regexp)
+}
+
+// hasDynamicWrapper is a quick check for a `${wrapper ${key}}` directive.
+func (t *Gledki) hasDynamicWrapper(text string) bool {
+	return strings.Contains(text, t.Tags[0]+"wrapper "+t.Tags[0])
+}
+
+// dynamicLayout returns the path to the wrapper of the template fullPath, if
+// it has a `${wrapper ${key}}` directive, which was already seen by compile.
+func (t *Gledki) dynamicLayout(fullPath string) (string, bool, error) {
+	b := t.base()
+	b.mu.RLock()
+	d, ok := b.dynamicWrappers[fullPath]
+	b.mu.RUnlock()
+	if !ok {
+		return "", false, nil
+	}
+	layout, err := t.layout(fullPath, d)
+	return layout, true, err
+}
+
+// layout returns the value for d.key from the Stash or d.fallback. The value
+// from the Stash must be d.fallback or in Gledki.Layouts.
+func (t *Gledki) layout(fullPath string, d dynamicWrapper) (string, error) {
+	var layout string
+	switch v := t.lookup(nil, d.key).(type) {
+	case string:
+		layout = v
+	case []byte:
+		layout = string(v)
+	}
+	if layout != "" {
+		if layout != d.fallback && !slices.Contains(t.Layouts, layout) {
+			return "", fmt.Errorf("layout %s for the wrapper of %s is not in Gledki.Layouts", layout, fullPath)
+		}
+		return layout, nil
+	}
+	if d.fallback != "" {
+		return d.fallback, nil
+	}
+	return "", fmt.Errorf("no value for %s in the Stash and no layout in the front matter"+
+		" for the wrapper of %s", d.key, fullPath)
+}
+
+// resolveDynamicWrapper replaces the `${wrapper ${key}}` directive in text
+// with a `${wrapper path}` directive, where path is the value for key from the
+// Stash or the layout from meta. Returns also the path and whether there was
+// such a directive. The template is remembered, so the compiled templates are
+// cached separately for every wrapper. See Gledki.compile.
+func (t *Gledki) resolveDynamicWrapper(fullPath, text string, meta map[string]string) (string, string, bool, error) {
+	if !t.hasDynamicWrapper(text) {
+		return text, "", false, nil
+	}
+	re := t.dynamicWrapperRe()
+	m := re.FindStringSubmatchIndex(text)
+	if m == nil {
+		return text, "", false, nil
+	}
+	d := dynamicWrapper{key: text[m[2]:m[3]], fallback: meta["layout"]}
+	layout, err := t.layout(fullPath, d)
+	if err != nil {
+		return "", "", true, err
+	}
+	b := t.base()
+	b.mu.Lock()
+	if b.dynamicWrappers == nil {
+		b.dynamicWrappers = make(map[string]dynamicWrapper)
+	}
+	b.dynamicWrappers[fullPath] = d
+	b.mu.Unlock()
+	return text[:m[0]] + t.Tags[0] + "wrapper " + layout + t.Tags[1] + text[m[1]:], layout, true, nil
+}
@@ -0,0 +1,231 @@
+package gledki
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LintRule names a check, done by [Gledki.Lint].
+type LintRule string
+
+const (
+	// LintUnreadable is reported for a template, which cannot be read.
+	LintUnreadable LintRule = "unreadable"
+	// LintMultipleWrappers is reported for every `wrapper` directive after
+	// the first one in a file.
+	LintMultipleWrappers LintRule = "multiple-wrappers"
+	// LintMissingContent is reported for a `wrapper` directive, which points
+	// to a file without a `${content}` placeholder.
+	LintMissingContent LintRule = "missing-content"
+	// LintUnreachable is reported for an `include` or `wrapper` directive,
+	// which points to a missing file or to a file outside of the roots.
+	// Optional includes are not checked.
+	LintUnreachable LintRule = "unreachable"
+	// LintDelimiters is reported for a start tag without an end tag or with
+	// another start tag before the end tag.
+	LintDelimiters LintRule = "delimiters"
+	// LintTooDeep is reported for a template, which includes or is wrapped by
+	// more files in a chain than [Gledki.IncludeLimit] allows, or includes
+	// itself.
+	LintTooDeep LintRule = "too-deep"
+	// LintUnknownDirective is reported for a tag, which looks like a
+	// directive – a word followed by an argument, but is neither a directive,
+	// nor a translation, nor a plural, nor handled by a prefix handler, nor
+	// has filters.
+	LintUnknownDirective LintRule = "unknown-directive"
+)
+
+// Problem is a problem in a template, found by [Gledki.Lint].
+type Problem struct {
+	// Full path to the template.
+	Path string
+	// The line of the problem, starting from 1, or 0 if it is for the whole
+	// file or the line is not known – e.g. for a file with its own tags.
+	Line    int
+	Rule    LintRule
+	Message string
+}
+
+func (p Problem) String() string {
+	return spf("%s:%d: %s: %s", p.Path, p.Line, p.Rule, p.Message)
+}
+
+/*
+Lint checks the source files of the templates paths, or of all templates under
+the active roots if no paths are given, and returns the found problems, sorted
+by path and line. See [LintRule] for the checks. Lint is meant for CI checks and
+editors – unlike [Gledki.Compile], it does not stop at the first problem and
+tells the line of every problem. The templates are not compiled.
+*/
+func (t *Gledki) Lint(paths ...string) []Problem {
+	var problems []Problem
+	if len(paths) == 0 {
+		var err error
+		if paths, err = t.Templates(); err != nil {
+			problems = append(problems, Problem{Rule: LintUnreadable, Message: err.Error()})
+		}
+	}
+	roots := t.activeRoots()
+	for _, path := range paths {
+		problems = append(problems, t.lint(roots, t.findPath(roots, path))...)
+	}
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return problems
+}
+
+// lint returns the problems in the template fullPath.
+func (t *Gledki) lint(roots []string, fullPath string) []Problem {
+	raw, err := t.loadFile(roots, fullPath)
+	if err != nil {
+		return []Problem{{Path: fullPath, Rule: LintUnreadable, Message: err.Error()}}
+	}
+	_, source := t.frontMatter(raw)
+	text := source
+	var problems []Problem
+	add := func(pos int, rule LintRule, format string, args ...any) {
+		problems = append(problems, Problem{Path: fullPath, Line: lineOf(raw, source, pos),
+			Rule: rule, Message: spf(format, args...)})
+	}
+	// `${wrapper ${key}}` is a wrapper, not a tag with another start tag in it.
+	wrappers := 0
+	if t.hasDynamicWrapper(text) {
+		for _, m := range t.dynamicWrapperRe().FindAllStringIndex(text, -1) {
+			if wrappers++; wrappers > 1 {
+				add(m[0], LintMultipleWrappers, "only one wrapper is allowed")
+			}
+			text = text[:m[0]] + strings.Repeat(" ", m[1]-m[0]) + text[m[1]:]
+		}
+	}
+	nodes := t.Parse(text)
+	for i, n := range nodes {
+		// `${- include partials/footer -}` is a directive too.
+		if content, left, right := cutTrimMarkers(n.Text); n.Kind != TextNode && (left || right) {
+			nodes[i] = t.Parse(t.Tags[0] + content + t.Tags[1])[0]
+			nodes[i].Pos, nodes[i].Raw = n.Pos, n.Raw
+		}
+	}
+	for _, n := range nodes {
+		switch {
+		case n.Kind == TextNode:
+			if i := strings.Index(n.Text, t.Tags[0]); i >= 0 {
+				add(n.Pos+i, LintDelimiters, "%s without %s", t.Tags[0], t.Tags[1])
+			}
+		case n.Kind == TagNode && strings.Contains(n.Text, t.Tags[0]):
+			add(n.Pos, LintDelimiters, "%s in %s", t.Tags[0], n.Raw)
+		case n.Kind == TagNode && t.unknownDirective(n.Text):
+			add(n.Pos, LintUnknownDirective, "%s is not a known directive", n.Raw)
+		case n.Kind == DirectiveNode && n.Name == "wrapper":
+			if wrappers++; wrappers > 1 {
+				add(n.Pos, LintMultipleWrappers, "only one wrapper is allowed")
+			}
+			wrapper, err := t.findInRoots(roots, n.Arg)
+			if err == nil {
+				var wrapperText string
+				if wrapperText, err = t.loadFile(roots, wrapper); err == nil && !t.hasContent(wrapperText) {
+					add(n.Pos, LintMissingContent, "wrapper %s has no %scontent%s placeholder",
+						wrapper, t.Tags[0], t.Tags[1])
+				}
+			}
+			if err != nil {
+				add(n.Pos, LintUnreachable, "%s: %v", n.Raw, err)
+			}
+		case n.Kind == DirectiveNode && !n.Optional:
+			included, err := t.findInRoots(roots, n.Arg)
+			if err == nil {
+				_, err = t.loadFile(roots, included)
+			}
+			if err != nil {
+				add(n.Pos, LintUnreachable, "%s: %v", n.Raw, err)
+			}
+		}
+	}
+	if err := t.lintDepth(roots, fullPath, 0); err != nil {
+		problems = append(problems, Problem{Path: fullPath, Rule: LintTooDeep, Message: err.Error()})
+	}
+	return problems
+}
+
+// unknownDirective tells if the content of a tag looks like a directive, which
+// is not known. See LintUnknownDirective.
+func (t *Gledki) unknownDirective(tag string) bool {
+	word, _, ok := strings.Cut(strings.TrimSpace(tag), " ")
+	if !ok || strings.Contains(tag, "|") || word == "l10n" || word == "plural" ||
+		t.prefixHandler(tag) != nil {
+		return false
+	}
+	_, ok = t.Stash[tag]
+	return !ok
+}
+
+// hasContent tells if the wrapper text has a `${content}` placeholder.
+func (t *Gledki) hasContent(text string) bool {
+	_, text = t.frontMatter(text)
+	return slices.ContainsFunc(t.Parse(t.trimMarkers(text)), func(n Node) bool {
+		return n.Kind == TagNode && n.Text == "content"
+	})
+}
+
+// lintDepth returns an error for a too deep chain of includes or wrappers,
+// starting at fullPath, like the panic of Gledki.compile, but without
+// compiling and logging. depth is the level of inclusion of fullPath. Other
+// errors are found by Gledki.lint.
+func (t *Gledki) lintDepth(roots []string, fullPath string, depth int) error {
+	if depth > t.IncludeLimit {
+		return fmt.Errorf("limit of %d nested inclusions reached while trying to include %s",
+			t.IncludeLimit, fullPath)
+	}
+	// The includes in the file and in its wrappers are on the same level.
+	var includes []string
+	path := fullPath
+	for wrappers := 0; path != ""; wrappers++ {
+		if wrappers > t.IncludeLimit {
+			return fmt.Errorf("limit of %d nested wrappers reached while trying to wrap %s",
+				t.IncludeLimit, fullPath)
+		}
+		text, err := t.loadFile(roots, path)
+		if err != nil {
+			break
+		}
+		meta, text := t.frontMatter(text)
+		if t.hasDynamicWrapper(text) {
+			text = t.dynamicWrapperRe().ReplaceAllLiteralString(text,
+				t.Tags[0]+"wrapper "+meta["layout"]+t.Tags[1])
+		}
+		path = ""
+		for _, n := range t.Parse(t.trimMarkers(text)) {
+			if n.Kind != DirectiveNode || n.Arg == "" {
+				continue
+			}
+			found, err := t.findInRoots(roots, n.Arg)
+			switch {
+			case err != nil:
+			case n.Name == "wrapper":
+				path = found
+			default:
+				includes = append(includes, found)
+			}
+		}
+	}
+	for _, included := range includes {
+		if err := t.lintDepth(roots, included, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineOf returns the line in raw of the position pos in text – raw after
+// Gledki.frontMatter, or 0 if it is not known.
+func lineOf(raw, text string, pos int) int {
+	rest := text[pos:]
+	if !strings.HasSuffix(raw, rest) {
+		return 0
+	}
+	return strings.Count(raw[:len(raw)-len(rest)], "\n") + 1
+}
@@ -0,0 +1,84 @@
+package gledki
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// Logger is the logger, used by [Gledki]. It has only the methods, gledki
+// calls, so it is implemented by the adapters, returned by [NewSlogLogger] and
+// [NewStdLogger], and also by the loggers of gommon/log and Echo without an
+// adapter – `t.Logger = e.Logger` in an Echo application is enough.
+type Logger interface {
+	Error(args ...any)
+	Errorf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warn(args ...any)
+	Warnf(format string, args ...any)
+}
+
+// SlogLogger adapts a [slog.Logger] to [Logger].
+type SlogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a [Logger], which writes to l. If l is nil,
+// [slog.Default] is used.
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{l: l}
+}
+
+// Error logs args at [slog.LevelError].
+func (s *SlogLogger) Error(args ...any) { s.l.Error(fmt.Sprint(args...)) }
+
+// Errorf logs the formatted message at [slog.LevelError].
+func (s *SlogLogger) Errorf(format string, args ...any) { s.l.Error(spf(format, args...)) }
+
+// Infof logs the formatted message at [slog.LevelInfo].
+func (s *SlogLogger) Infof(format string, args ...any) { s.l.Info(spf(format, args...)) }
+
+// Warn logs args at [slog.LevelWarn].
+func (s *SlogLogger) Warn(args ...any) { s.l.Warn(fmt.Sprint(args...)) }
+
+// Warnf logs the formatted message at [slog.LevelWarn].
+func (s *SlogLogger) Warnf(format string, args ...any) { s.l.Warn(spf(format, args...)) }
+
+// StdLogger adapts a [log.Logger] from the standard library to [Logger]. The
+// messages are prefixed with their level – "ERROR: ", "WARN: " or "INFO: ".
+type StdLogger struct {
+	l *log.Logger
+}
+
+// NewStdLogger returns a [Logger], which writes to l. If l is nil,
+// [log.Default] is used.
+func NewStdLogger(l *log.Logger) *StdLogger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &StdLogger{l: l}
+}
+
+// defaultLogger returns the default [Gledki.Logger], writing to os.Stderr.
+func defaultLogger() Logger {
+	return NewStdLogger(log.New(os.Stderr, "gledki: ", log.LstdFlags))
+}
+
+// Error logs args with the prefix "ERROR: ".
+func (s *StdLogger) Error(args ...any) { s.l.Print("ERROR: " + fmt.Sprint(args...)) }
+
+// Errorf logs the formatted message with the prefix "ERROR: ".
+func (s *StdLogger) Errorf(format string, args ...any) { s.l.Print("ERROR: " + spf(format, args...)) }
+
+// Infof logs the formatted message with the prefix "INFO: ".
+func (s *StdLogger) Infof(format string, args ...any) { s.l.Print("INFO: " + spf(format, args...)) }
+
+// Warn logs args with the prefix "WARN: ".
+func (s *StdLogger) Warn(args ...any) { s.l.Print("WARN: " + fmt.Sprint(args...)) }
+
+// Warnf logs the formatted message with the prefix "WARN: ".
+func (s *StdLogger) Warnf(format string, args ...any) { s.l.Print("WARN: " + spf(format, args...)) }
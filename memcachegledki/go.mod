@@ -0,0 +1,13 @@
+module github.com/kberov/gledki/memcachegledki
+
+go 1.23.1
+
+require (
+	github.com/bradfitz/gomemcache v0.0.0-20230905024940-24af94b03874
+	github.com/kberov/gledki v0.0.0-20261017021942-7ccc56164d74
+)
+
+require (
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.2 // indirect
+)
@@ -0,0 +1,71 @@
+/*
+Package memcachegledki keeps the compiled [gledki] templates in memcached, so
+the instances of a multi-instance deployment share them, instead of each of
+them writing its own files. It is a separate module, so gledki itself does not
+depend on the memcached client:
+
+	tpls.CompiledStore = memcachegledki.New(memcache.New("localhost:11211"), "gledki:")
+
+The keys are the prefix and the SHA-256 sum of the full paths of the
+templates, because memcached does not allow spaces and long keys. The
+templates must have the same full paths on all instances. Compiled templates,
+bigger than the item size limit of memcached (1MB by default), cannot be
+stored – they are compiled from the sources on every start.
+*/
+package memcachegledki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Store implements [gledki.CompiledStore] and [gledki.Pinger] with a memcached
+// client.
+type Store struct {
+	mc     *memcache.Client
+	prefix string
+}
+
+// New returns a Store, which keeps the compiled templates in mc under keys,
+// starting with prefix.
+func New(mc *memcache.Client, prefix string) *Store {
+	return &Store{mc: mc, prefix: prefix}
+}
+
+// Key returns the memcached key for the template key.
+func (s *Store) Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return s.prefix + hex.EncodeToString(sum[:])
+}
+
+// Get implements [gledki.CompiledStore]. Errors are treated as a missing
+// template, so it is compiled from the sources.
+func (s *Store) Get(key string) (string, bool) {
+	item, err := s.mc.Get(s.Key(key))
+	if err != nil {
+		return "", false
+	}
+	return string(item.Value), true
+}
+
+// Set implements [gledki.CompiledStore].
+func (s *Store) Set(key, text string) error {
+	return s.mc.Set(&memcache.Item{Key: s.Key(key), Value: []byte(text)})
+}
+
+// Delete implements [gledki.CompiledStore].
+func (s *Store) Delete(key string) error {
+	err := s.mc.Delete(s.Key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// Ping implements [gledki.Pinger].
+func (s *Store) Ping() error {
+	return s.mc.Ping()
+}
@@ -0,0 +1,162 @@
+package memcachegledki
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	gl "github.com/kberov/gledki"
+)
+
+// server is a memcached server, which knows only the commands, used by Store.
+type server struct {
+	net.Listener
+	mu    sync.Mutex
+	items map[string][]byte
+	conns []net.Conn
+}
+
+func newServer(t *testing.T) *server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error Listen: %s", err.Error())
+	}
+	s := &server{Listener: ln, items: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(s.stop)
+	return s
+}
+
+// stop closes the listener and the open connections.
+func (s *server) stop() {
+	_ = s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			return
+		}
+		s.mu.Lock()
+		switch f[0] {
+		case "version":
+			fmt.Fprint(rw, "VERSION 1.6.0\r\n")
+		case "gets":
+			for _, key := range f[1:] {
+				if v, ok := s.items[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(v), v)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "set":
+			var size int
+			_, _ = fmt.Sscan(f[4], &size)
+			v := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, v); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			s.items[f[1]] = v[:size]
+			fmt.Fprint(rw, "STORED\r\n")
+		case "delete":
+			if _, ok := s.items[f[1]]; ok {
+				delete(s.items, f[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+		_ = rw.Flush()
+	}
+}
+
+func (s *server) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+func TestStore(t *testing.T) {
+	srv := newServer(t)
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${include partials/item}</p>"), 0600)
+	_ = os.MkdirAll(filepath.Join(root, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "partials", "item.htm"), []byte("${title}"), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	store := New(memcache.New(srv.Addr().String()), "gledki:")
+	tpls.CompiledStore = store
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	_ = tpls.Close()
+	if srv.len() != 2 {
+		t.Fatalf("The compiled templates should be stored in memcached: %v", srv.items)
+	}
+	if _, ok := store.Get(filepath.Join(root, "page.htm")); !ok {
+		t.Fatal("The compiled template should be found")
+	}
+	if _, err := os.Stat(filepath.Join(root, "page.htm"+gl.CompiledSuffix)); err == nil {
+		t.Fatal("No compiled file should be written")
+	}
+	if err := tpls.ClearCache(true); err != nil {
+		t.Fatalf("Error ClearCache: %s", err.Error())
+	}
+	if srv.len() != 0 {
+		t.Fatalf("The compiled templates should be deleted: %v", srv.items)
+	}
+	if err := store.Delete(filepath.Join(root, "page.htm")); err != nil {
+		t.Fatalf("Deleting a missing template is not an error: %s", err.Error())
+	}
+	srv.stop()
+	if err := tpls.Ready(); err == nil {
+		t.Fatal("The stopped server should be reported")
+	}
+}
+
+func TestKey(t *testing.T) {
+	key := New(nil, "gledki:").Key("/path with spaces/" + strings.Repeat("long/", 60) + "page.htm")
+	if len(key) != len("gledki:")+64 || strings.ContainsAny(key, " \r\n") {
+		t.Fatalf("Wrong key: %s", key)
+	}
+}
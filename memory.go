@@ -0,0 +1,102 @@
+package gledki
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Prefix of the full paths of the templates, added with Gledki.AddString.
+const memoryPrefix = "memory:"
+
+/*
+AddString adds a template with the given text under the virtual path name, so
+templates can come from strings – in tests, generated code or a database.
+`t.AddString("pages/promo", text)` adds "pages/promo.htm" if the first of
+[Gledki.Ext] is ".htm", and "memory:pages/promo.htm" is its full path. The
+template is executed, included and used as a wrapper by its name like the files
+in the roots and takes precedence over them. It can include and be wrapped by
+files on disk and by other added templates. The compiled template is cached
+only in memory. Adding a template again replaces it. The templates, which
+include or are wrapped by it or by the file it takes precedence over, are
+compiled again. Added templates are shared by all views.
+*/
+func (t *Gledki) AddString(name, text string) error {
+	if t.frozen() {
+		return ErrFrozen
+	}
+	name = t.withExt(path.Clean(filepath.ToSlash(name)))
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid template name: %s", name)
+	}
+	b := t.base()
+	b.mu.Lock()
+	if b.memory == nil {
+		b.memory = make(map[string]string)
+	}
+	b.memory[name] = text
+	b.mu.Unlock()
+	return t.invalidate(t.shadowedBy(name)...)
+}
+
+// shadowedBy returns the full path of the template name, added with
+// Gledki.AddString, and the full paths, name may have been resolved to before
+// – in the roots of the compiled templates, in Gledki.DefaultsFS or as the
+// relative path, if it was not found.
+func (t *Gledki) shadowedBy(name string) []string {
+	b := t.base()
+	roots := slices.Concat(b.Roots, slices.Collect(maps.Values(b.namedRoots)))
+	for _, r := range b.conditionalRoots {
+		roots = append(roots, r.path)
+	}
+	b.mu.RLock()
+	for _, c := range b.compiled {
+		roots = append(roots, c.roots...)
+	}
+	b.mu.RUnlock()
+	paths := []string{memoryPrefix + name, defaultsPrefix + name, filepath.FromSlash(name)}
+	for _, root := range slices.Compact(slices.Sorted(slices.Values(roots))) {
+		paths = append(paths, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	return paths
+}
+
+// inMemory returns the full path of the template path, added with
+// Gledki.AddString.
+func (t *Gledki) inMemory(path string) (string, bool) {
+	b := t.base()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.memory) == 0 {
+		return "", false
+	}
+	path = strings.TrimPrefix(path, memoryPrefix)
+	if _, ok := b.memory[path]; ok {
+		return memoryPrefix + path, true
+	}
+	return "", false
+}
+
+// memoryText returns the text of the template fullPath, added with
+// Gledki.AddString.
+func (t *Gledki) memoryText(fullPath string) (string, bool) {
+	rel, ok := strings.CutPrefix(fullPath, memoryPrefix)
+	if !ok {
+		return "", false
+	}
+	b := t.base()
+	b.mu.RLock()
+	text, ok := b.memory[rel]
+	b.mu.RUnlock()
+	return text, ok
+}
+
+// virtual tells if fullPath is not a file in the roots, but a template from
+// Gledki.DefaultsFS or added with Gledki.AddString.
+func virtual(fullPath string) bool {
+	return strings.HasPrefix(fullPath, defaultsPrefix) || strings.HasPrefix(fullPath, memoryPrefix)
+}
@@ -0,0 +1,195 @@
+/*
+Package metrics collects metrics about [gledki] templates and exposes them in
+the Prometheus text format, without depending on the Prometheus client:
+
+	m := metrics.New()
+	tpls.Metrics = m
+	http.Handle("/metrics/gledki", m)
+
+The metrics are labeled with the full path of the main template:
+
+	gledki_compile_duration_seconds  histogram
+	gledki_cache_hits_total          counter
+	gledki_cache_misses_total        counter
+	gledki_execute_duration_seconds  histogram
+	gledki_rendered_bytes_total      counter
+	gledki_execute_errors_total      counter
+
+Applications, which already use the Prometheus client library, can use the
+module [github.com/kberov/gledki/promgledki] instead.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of the
+// histograms, used by [New].
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+
+// Collector implements [gledki.Metrics]. It is safe for concurrent use. Every
+// template has its own lock, so the renderings of different templates do not
+// wait for each other.
+type Collector struct {
+	buckets []float64
+	// template => *series
+	series sync.Map
+}
+
+// series are the metrics of one template.
+type series struct {
+	mu                         sync.Mutex
+	compile, execute           histogram
+	hits, misses, bytes, fails uint64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// New returns a Collector with DefaultBuckets.
+func New() *Collector {
+	return NewWithBuckets(DefaultBuckets)
+}
+
+// NewWithBuckets returns a Collector with the sorted upper bounds in seconds
+// of the buckets of the histograms.
+func NewWithBuckets(buckets []float64) *Collector {
+	return &Collector{buckets: slices.Sorted(slices.Values(buckets))}
+}
+
+// ObserveCompile implements [gledki.Metrics].
+func (c *Collector) ObserveCompile(fullPath string, d time.Duration, cacheHit bool) {
+	s := c.get(fullPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compile.observe(c.buckets, d)
+	if cacheHit {
+		s.hits++
+	} else {
+		s.misses++
+	}
+}
+
+// ObserveExecute implements [gledki.Metrics].
+func (c *Collector) ObserveExecute(fullPath string, d time.Duration, bytes int64, err error) {
+	s := c.get(fullPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.execute.observe(c.buckets, d)
+	s.bytes += uint64(bytes)
+	if err != nil {
+		s.fails++
+	}
+}
+
+// get returns the series of template, creating them if needed.
+func (c *Collector) get(template string) *series {
+	s, ok := c.series.Load(template)
+	if !ok {
+		s, _ = c.series.LoadOrStore(template, &series{
+			compile: histogram{counts: make([]uint64, len(c.buckets))},
+			execute: histogram{counts: make([]uint64, len(c.buckets))}})
+	}
+	return s.(*series)
+}
+
+func (h *histogram) observe(buckets []float64, d time.Duration) {
+	seconds := d.Seconds()
+	for i, le := range buckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = c.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text format to w.
+func (c *Collector) Write(w io.Writer) error {
+	// A copy of the metrics of every template, taken under its lock.
+	compile, execute := make(map[string]*histogram), make(map[string]*histogram)
+	hits, misses, bytes, fails := make(map[string]uint64), make(map[string]uint64),
+		make(map[string]uint64), make(map[string]uint64)
+	c.series.Range(func(key, value any) bool {
+		template, s := key.(string), value.(*series)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.compile.count > 0 {
+			compile[template] = s.compile.clone()
+		}
+		if s.execute.count > 0 {
+			execute[template] = s.execute.clone()
+		}
+		setCounter(hits, template, s.hits)
+		setCounter(misses, template, s.misses)
+		setCounter(bytes, template, s.bytes)
+		setCounter(fails, template, s.fails)
+		return true
+	})
+	b := bufio.NewWriter(w)
+	c.writeHistograms(b, "gledki_compile_duration_seconds",
+		"Duration of the compilation of the main templates.", compile)
+	writeCounters(b, "gledki_cache_hits_total", "Compiled templates found in memory.", hits)
+	writeCounters(b, "gledki_cache_misses_total", "Templates compiled or loaded from the store.", misses)
+	c.writeHistograms(b, "gledki_execute_duration_seconds",
+		"Duration of the execution of the compiled templates.", execute)
+	writeCounters(b, "gledki_rendered_bytes_total", "Bytes written by the templates.", bytes)
+	writeCounters(b, "gledki_execute_errors_total", "Failed executions of the templates.", fails)
+	return b.Flush()
+}
+
+func (h *histogram) clone() *histogram {
+	return &histogram{counts: slices.Clone(h.counts), sum: h.sum, count: h.count}
+}
+
+func (c *Collector) writeHistograms(w io.Writer, name, help string, histograms map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, template := range slices.Sorted(maps.Keys(histograms)) {
+		h, label := histograms[template], quote(template)
+		for i, le := range c.buckets {
+			fmt.Fprintf(w, "%s_bucket{template=%s,le=\"%s\"} %d\n", name, label,
+				strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{template=%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(w, "%s_sum{template=%s} %s\n", name, label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{template=%s} %d\n", name, label, h.count)
+	}
+}
+
+// setCounter sets the counter of template, if it was incremented.
+func setCounter(counters map[string]uint64, template string, value uint64) {
+	if value > 0 {
+		counters[template] = value
+	}
+}
+
+func writeCounters(w io.Writer, name, help string, counters map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, template := range slices.Sorted(maps.Keys(counters)) {
+		fmt.Fprintf(w, "%s{template=%s} %d\n", name, quote(template), counters[template])
+	}
+}
+
+// quote returns the value of a label, escaped and in quotes.
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
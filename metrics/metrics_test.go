@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+func TestCollector(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "page.htm")
+	_ = os.WriteFile(page, []byte("<p>${title}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "bad.htm"), []byte("${bad}"), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CacheTemplates = false
+	m := NewWithBuckets([]float64{60, 30})
+	tpls.Metrics = m
+	tpls.Stash["title"] = "Title"
+	tpls.Stash["bad"] = 42
+	tpls.RecoverTagFuncs = true
+	var b strings.Builder
+	for range 2 {
+		if _, err := tpls.Execute(&b, "page"); err != nil {
+			t.Fatalf("Error Execute: %s", err.Error())
+		}
+	}
+	if _, err := tpls.Execute(&b, "bad"); err == nil {
+		t.Fatal("Execute should fail")
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE gledki_compile_duration_seconds histogram",
+		`gledki_compile_duration_seconds_bucket{template="` + page + `",le="30"} 2`,
+		`gledki_compile_duration_seconds_bucket{template="` + page + `",le="+Inf"} 2`,
+		`gledki_compile_duration_seconds_count{template="` + page + `"} 2`,
+		`gledki_cache_misses_total{template="` + page + `"} 2`,
+		`gledki_execute_duration_seconds_count{template="` + page + `"} 2`,
+		`gledki_rendered_bytes_total{template="` + page + `"} 24`,
+		`gledki_execute_errors_total{template="` + filepath.Join(root, "bad.htm") + `"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing line %s in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, `gledki_execute_errors_total{template="`+page) {
+		t.Errorf("There should be no errors for the page:\n%s", out)
+	}
+	if quote("a\"b\\c\n") != `"a\"b\\c\n"` {
+		t.Errorf("Wrong quoting: %s", quote("a\"b\\c\n"))
+	}
+}
+
+func TestCollectorConcurrent(t *testing.T) {
+	m := New()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				m.ObserveCompile("/t/"+strconv.Itoa(i%2)+".htm", time.Millisecond, true)
+				m.ObserveExecute("/t/"+strconv.Itoa(i%2)+".htm", time.Millisecond, 1, nil)
+			}
+			_ = m.Write(io.Discard)
+		}()
+	}
+	wg.Wait()
+	var b strings.Builder
+	_ = m.Write(&b)
+	for _, line := range []string{
+		`gledki_cache_hits_total{template="/t/0.htm"} 400`,
+		`gledki_rendered_bytes_total{template="/t/1.htm"} 400`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("Missing line %s in:\n%s", line, b.String())
+		}
+	}
+}
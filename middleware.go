@@ -0,0 +1,88 @@
+package gledki
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExecuteFunc executes the template path like [Gledki.ExecuteContext].
+type ExecuteFunc func(ctx context.Context, w io.Writer, path string) (int64, error)
+
+// Middleware wraps the execution of a template – e.g. to check the
+// permissions of the user, to measure the time or to recover from panics. It
+// calls next to execute the template or returns an error without calling it.
+// See [Gledki.Use].
+type Middleware func(next ExecuteFunc) ExecuteFunc
+
+type middleware struct {
+	pattern string
+	m       Middleware
+}
+
+/*
+Use registers m for the templates, whose paths match pattern, so cross-cutting
+concerns are not spread across every handler. The pattern is matched like
+[Gledki.Exclude] against the path, passed to [Gledki.Execute] and the other
+execute methods, without the extension and with slashes as separators. A
+pattern, matching a directory, matches all templates under it, so
+"pages/admin/*" matches "pages/admin/users" and "pages/admin/users/edit", and
+"**" matches any number of directories. The middlewares are called in the
+order of their registration, so the first registered is the outermost one.
+Example:
+
+	t.Use("pages/admin/*", func(next gledki.ExecuteFunc) gledki.ExecuteFunc {
+		return func(ctx context.Context, w io.Writer, path string) (int64, error) {
+			if !isAdmin(ctx) {
+				return 0, errForbidden
+			}
+			return next(ctx, w, path)
+		}
+	})
+
+Middlewares are shared with the views, created by [Gledki.WithRoots] after
+their registration. Returns an error only if pattern is malformed.
+*/
+func (t *Gledki) Use(pattern string, m Middleware) error {
+	for _, p := range strings.Split(pattern, "/") {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	t.middlewares = append(t.middlewares, middleware{pattern, m})
+	return nil
+}
+
+// withMiddlewares calls exec for path through the middlewares, matching it.
+// Every public execute method goes through it, so a middleware cannot be
+// bypassed by calling another one.
+func (t *Gledki) withMiddlewares(ctx context.Context, w io.Writer, path string, exec ExecuteFunc) (int64, error) {
+	if len(t.middlewares) == 0 {
+		return exec(ctx, w, path)
+	}
+	return t.chain(path, exec)(ctx, w, path)
+}
+
+// chain returns next wrapped by the middlewares, matching path.
+func (t *Gledki) chain(path string, next ExecuteFunc) ExecuteFunc {
+	name := t.trimExt(filepath.ToSlash(path))
+	for i := len(t.middlewares) - 1; i >= 0; i-- {
+		if t.middlewares[i].match(name) {
+			next = t.middlewares[i].m(next)
+		}
+	}
+	return next
+}
+
+// match reports whether the pattern matches name or one of its directories.
+func (m middleware) match(name string) bool {
+	pattern, segments := strings.Split(m.pattern, "/"), strings.Split(name, "/")
+	for i := range segments {
+		if matchGlob(pattern, segments[:i+1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package gledki
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Elements, whose content must be kept as is.
+	verbatimRe = regexp.MustCompile(`(?is)<pre\b.*?</pre>|<textarea\b.*?</textarea>|<script\b.*?</script>|<style\b.*?</style>`)
+	// HTML comments, except conditional comments like `<!--[if IE]>`.
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--([^\[].*?)?-->`)
+	// Whitespace with a line break between two tags.
+	betweenTagsRe = regexp.MustCompile(`>\s*\n\s*<`)
+	// Whitespace with a line break anywhere else.
+	lineBreaksRe = regexp.MustCompile(`\s*\n\s*`)
+	// Runs of spaces and tabs.
+	spacesRe = regexp.MustCompile(`[ \t]{2,}`)
+)
+
+// MinifyHTML removes the HTML comments and collapses the whitespace in text.
+// Whitespace with line breaks between tags is removed, elsewhere it is
+// replaced with one line break. Runs of spaces and tabs become one space, so
+// the spaces between inline elements on the same line are kept. The content
+// of pre, textarea, script and style elements is not changed. See
+// [Gledki.Minify].
+func MinifyHTML(text string) string {
+	var b strings.Builder
+	pos := 0
+	for _, loc := range verbatimRe.FindAllStringIndex(text, -1) {
+		b.WriteString(minifyHTML(text[pos:loc[0]], pos > 0, true))
+		b.WriteString(text[loc[0]:loc[1]])
+		pos = loc[1]
+	}
+	b.WriteString(minifyHTML(text[pos:], pos > 0, false))
+	return b.String()
+}
+
+// minifyHTML minifies text, which is after a verbatim element if afterTag is
+// true and before one if beforeTag is true, so the whitespace between them is
+// treated as between tags.
+func minifyHTML(text string, afterTag, beforeTag bool) string {
+	if afterTag {
+		text = ">" + text
+	}
+	if beforeTag {
+		text += "<"
+	}
+	text = htmlCommentRe.ReplaceAllString(text, "")
+	text = betweenTagsRe.ReplaceAllString(text, "><")
+	text = lineBreaksRe.ReplaceAllString(text, "\n")
+	text = spacesRe.ReplaceAllString(text, " ")
+	if afterTag {
+		text = text[1:]
+	}
+	if beforeTag {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// postCompile applies the minifier and Gledki.PostCompile to the compiled
+// text of the file fullPath.
+func (t *Gledki) postCompile(fullPath, text string) string {
+	if t.Minify {
+		text = MinifyHTML(text)
+	}
+	if t.PostCompile != nil {
+		text = t.PostCompile(fullPath, text)
+	}
+	return text
+}
@@ -0,0 +1,72 @@
+package gledki
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// MissingIncludeFunc decides what to do, when the file path in an `include`
+// directive does not exist. path is relative, because it was not found in the
+// roots. err is the error from reading the file. It returns the text to be
+// included in place of the file or an error to stop the compilation. The text
+// may contain placeholders. See [Gledki.OnMissingInclude], [FailOnMissing]
+// and [CommentOnMissing].
+type MissingIncludeFunc func(path string, err error) (string, error)
+
+// FailOnMissing returns err – the default behavior.
+func FailOnMissing(path string, err error) (string, error) {
+	return "", err
+}
+
+// CommentOnMissing renders an HTML comment in place of the missing file, so
+// pages under development render with a visible marker instead of an error.
+func CommentOnMissing(path string, err error) (string, error) {
+	return "<!-- missing include: " + html.EscapeString(path) + " -->", nil
+}
+
+// missingInclude returns a compiled file with the text, returned by
+// t.OnMissingInclude for the missing file fullPath. It is cached only as part
+// of the including templates, so invalidate them or clear the cache, when the
+// file is created. The directive is kept in the compiled files on disk and is
+// resolved again on the next run of the application.
+func (t *Gledki) missingInclude(fullPath string, err error) (*compiledFile, error) {
+	text, err := t.OnMissingInclude(fullPath, err)
+	if err != nil {
+		return nil, err
+	}
+	return &compiledFile{path: fullPath, key: "missing\n" + fullPath,
+		segments: []segment{{text: text}}}, nil
+}
+
+// compileOptional is like compile, but returns panics, like reaching the
+// Gledki.IncludeLimit, as errors.
+func (t *Gledki) compileOptional(ctx context.Context, roots []string, fullPath string, depth int) (c *compiledFile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return t.compile(ctx, roots, fullPath, depth)
+}
+
+// failedInclude reports the error of the optional include path to
+// t.OnIncludeError and returns a compiled file with a comment in place of
+// path. Like missingInclude, it is cached only as part of the including
+// templates.
+func (t *Gledki) failedInclude(path string, err error) (*compiledFile, error) {
+	if t.OnIncludeError != nil {
+		t.OnIncludeError(path, err)
+	} else {
+		t.Logger.Warnf("optional include %s failed: %v", path, err)
+	}
+	return &compiledFile{path: path, key: "failed\n" + path,
+		segments: []segment{{text: "<!-- failed include: " + html.EscapeString(path) + " -->"}}}, nil
+}
+
+// placeholder returns true if c was not compiled from a file, but stands in
+// for a missing or failed include.
+func (c *compiledFile) placeholder() bool {
+	return strings.HasPrefix(c.key, "missing\n") || strings.HasPrefix(c.key, "failed\n")
+}
@@ -0,0 +1,98 @@
+/*
+Package otelgledki traces the rendering of [gledki] templates with
+OpenTelemetry. It is a separate module, so gledki itself does not depend on
+OpenTelemetry:
+
+	otelgledki.WithTracerProvider(tpls, tracerProvider)
+
+or, with a tracer of your own:
+
+	tpls.Tracer = otelgledki.New(otel.Tracer("gledki"))
+
+The attributes of the spans (see [gledki.AttrTemplate] and the others) keep
+their types – strings, booleans and integers – so they can be queried as such
+in the tracing backend.
+*/
+package otelgledki
+
+import (
+	"context"
+	"fmt"
+
+	gl "github.com/kberov/gledki"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a [gledki.Tracer], which starts the spans with tracer.
+func New(tracer trace.Tracer) gl.Tracer {
+	return otelTracer{tracer}
+}
+
+// InstrumentationName is the name of the tracer, used by [WithTracerProvider].
+const InstrumentationName = "github.com/kberov/gledki"
+
+// WithTracerProvider sets the [gledki.Gledki.Tracer] of tpls to a tracer of
+// tp, named [InstrumentationName], and returns tpls. If tp is nil, the global
+// provider of OpenTelemetry is used.
+func WithTracerProvider(tpls *gl.Gledki, tp trace.TracerProvider) *gl.Gledki {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tpls.Tracer = New(tp.Tracer(InstrumentationName))
+	return tpls
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+// Start implements [gledki.Tracer].
+func (t otelTracer) Start(ctx context.Context, name string) (context.Context, gl.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+// SetAttribute implements [gledki.Span].
+func (s otelSpan) SetAttribute(key string, value any) {
+	s.span.SetAttributes(Attribute(key, value))
+}
+
+// RecordError implements [gledki.Span]. It also sets the status of the span
+// to Error.
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End implements [gledki.Span].
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+// Attribute returns the attribute key with value of the matching type.
+// Values of other types are formatted with fmt.Sprint.
+func Attribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
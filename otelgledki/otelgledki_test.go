@@ -0,0 +1,93 @@
+package otelgledki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${include partials/item}</p>"), 0600)
+	_ = os.MkdirAll(filepath.Join(root, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "partials", "item.htm"), []byte("${title}"), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CacheTemplates = false
+	rec := tracetest.NewSpanRecorder()
+	WithTracerProvider(tpls, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	if _, err := tpls.Execute(&b, "missing"); err == nil {
+		t.Fatal("Execute should fail")
+	}
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range rec.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	if len(spans[gl.SpanExecute]) != 2 || len(spans[gl.SpanInclude]) != 1 {
+		t.Fatalf("Wrong spans: %v", spans)
+	}
+	if scope := spans[gl.SpanExecute][0].InstrumentationScope(); scope.Name != InstrumentationName {
+		t.Fatalf("Wrong instrumentation scope: %v", scope)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[gl.SpanExecute][0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[gl.AttrTemplate].AsString() != "page" ||
+		attrs[gl.AttrBytes].Type() != attribute.INT64 || attrs[gl.AttrBytes].AsInt64() != 12 {
+		t.Fatalf("Wrong attributes: %v", attrs)
+	}
+	attrs = make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[gl.SpanCompile][0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[gl.AttrCacheHit].Type() != attribute.BOOL || attrs[gl.AttrStored].Type() != attribute.BOOL {
+		t.Fatalf("Wrong attributes: %v", attrs)
+	}
+	if failed := spans[gl.SpanExecute][1]; failed.Status().Code != codes.Error || len(failed.Events()) == 0 {
+		t.Fatalf("The error should be recorded: %v", failed.Status())
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	for _, tc := range []struct {
+		value any
+		typ   attribute.Type
+	}{
+		{"page", attribute.STRING},
+		{true, attribute.BOOL},
+		{42, attribute.INT64},
+		{int64(42), attribute.INT64},
+		{0.5, attribute.FLOAT64},
+		{[]string{"a"}, attribute.STRINGSLICE},
+		{struct{}{}, attribute.STRING},
+	} {
+		if kv := Attribute("k", tc.value); kv.Value.Type() != tc.typ {
+			t.Errorf("Wrong type of %#v: %s", tc.value, kv.Value.Type())
+		}
+	}
+}
+
+func TestWithTracerProvider(t *testing.T) {
+	tpls, err := gl.New([]string{t.TempDir()}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	if WithTracerProvider(tpls, nil) != tpls || tpls.Tracer == nil {
+		t.Fatal("The global provider should be used")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Package promgledki collects the metrics about [gledki] templates with the
+Prometheus client library. It is a separate module, so gledki itself does not
+depend on Prometheus. Use it instead of [github.com/kberov/gledki/metrics], if
+the application already registers its metrics with client_golang:
+
+	m := promgledki.New()
+	prometheus.MustRegister(m)
+	tpls.Metrics = m
+
+The metrics have the same names as in the package metrics and are labeled
+with the full path of the main template.
+*/
+package promgledki
+
+import (
+	"time"
+
+	"github.com/kberov/gledki/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements [gledki.Metrics] and [prometheus.Collector].
+type Metrics struct {
+	compile, execute           *prometheus.HistogramVec
+	hits, misses, bytes, fails *prometheus.CounterVec
+}
+
+// New returns Metrics with [metrics.DefaultBuckets].
+func New() *Metrics {
+	return NewWithBuckets(metrics.DefaultBuckets)
+}
+
+// NewWithBuckets returns Metrics with the upper bounds in seconds of the
+// buckets of the histograms.
+func NewWithBuckets(buckets []float64) *Metrics {
+	histogram := func(name, help string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: name, Help: help, Buckets: buckets}, []string{"template"})
+	}
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help},
+			[]string{"template"})
+	}
+	return &Metrics{
+		compile: histogram("gledki_compile_duration_seconds",
+			"Duration of the compilation of the main templates."),
+		hits:   counter("gledki_cache_hits_total", "Compiled templates found in memory."),
+		misses: counter("gledki_cache_misses_total", "Templates compiled or loaded from the store."),
+		execute: histogram("gledki_execute_duration_seconds",
+			"Duration of the execution of the compiled templates."),
+		bytes: counter("gledki_rendered_bytes_total", "Bytes written by the templates."),
+		fails: counter("gledki_execute_errors_total", "Failed executions of the templates."),
+	}
+}
+
+// ObserveCompile implements [gledki.Metrics].
+func (m *Metrics) ObserveCompile(fullPath string, d time.Duration, cacheHit bool) {
+	m.compile.WithLabelValues(fullPath).Observe(d.Seconds())
+	if cacheHit {
+		m.hits.WithLabelValues(fullPath).Inc()
+	} else {
+		m.misses.WithLabelValues(fullPath).Inc()
+	}
+}
+
+// ObserveExecute implements [gledki.Metrics].
+func (m *Metrics) ObserveExecute(fullPath string, d time.Duration, bytes int64, err error) {
+	m.execute.WithLabelValues(fullPath).Observe(d.Seconds())
+	m.bytes.WithLabelValues(fullPath).Add(float64(bytes))
+	if err != nil {
+		m.fails.WithLabelValues(fullPath).Inc()
+	}
+}
+
+// collectors returns the metrics in the order of the package metrics.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.compile, m.hits, m.misses, m.execute, m.bytes, m.fails}
+}
+
+// Describe implements [prometheus.Collector].
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements [prometheus.Collector].
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
@@ -0,0 +1,64 @@
+package promgledki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gl "github.com/kberov/gledki"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The interfaces, implemented by Metrics.
+var (
+	_ gl.Metrics           = (*Metrics)(nil)
+	_ prometheus.Collector = (*Metrics)(nil)
+)
+
+func TestMetrics(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "page.htm")
+	_ = os.WriteFile(page, []byte("<p>${title}</p>"), 0600)
+	_ = os.WriteFile(filepath.Join(root, "bad.htm"), []byte("${bad}"), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CacheTemplates = false
+	m := NewWithBuckets([]float64{30, 60})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(m)
+	tpls.Metrics = m
+	tpls.Stash["title"] = "Title"
+	tpls.Stash["bad"] = 42
+	tpls.RecoverTagFuncs = true
+	var b strings.Builder
+	for range 2 {
+		if _, err := tpls.Execute(&b, "page"); err != nil {
+			t.Fatalf("Error Execute: %s", err.Error())
+		}
+	}
+	if _, err := tpls.Execute(&b, "bad"); err == nil {
+		t.Fatal("Execute should fail")
+	}
+	if n := testutil.ToFloat64(m.misses.WithLabelValues(page)); n != 2 {
+		t.Errorf("Wrong cache misses: %v", n)
+	}
+	if n := testutil.ToFloat64(m.bytes.WithLabelValues(page)); n != 24 {
+		t.Errorf("Wrong rendered bytes: %v", n)
+	}
+	count, err := testutil.GatherAndCount(reg, "gledki_compile_duration_seconds")
+	if err != nil || count != 2 {
+		t.Errorf("Wrong compile histograms: %d, %v", count, err)
+	}
+	expected := `
+# HELP gledki_execute_errors_total Failed executions of the templates.
+# TYPE gledki_execute_errors_total counter
+gledki_execute_errors_total{template="` + filepath.Join(root, "bad.htm") + `"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gledki_execute_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
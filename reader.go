@@ -0,0 +1,61 @@
+package gledki
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// readerPath is the full path of the templates, executed with
+// Gledki.ExecuteReader, without the extension.
+const readerPath = "reader:"
+
+/*
+ExecuteReader reads a template from r, compiles its directives against the
+active roots and executes it with stash, which is looked up before the
+[Stash]. Use it for templates received over the network or read from a
+database row. The template is compiled for every call and is not cached, but
+the files it includes or is wrapped by are cached as usual. Its output mode is
+the one for the first of [Gledki.Ext], unless it has a `mode` in its front
+matter. A too deep chain of includes or wrappers is returned as an error
+instead of a panic, because the text is not trusted. The middlewares (see
+[Gledki.Use]) get the path "reader:".
+*/
+func (t *Gledki) ExecuteReader(w io.Writer, r io.Reader, stash Stash) (int64, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	return t.withMiddlewares(context.Background(), w, readerPath,
+		func(ctx context.Context, w io.Writer, _ string) (int64, error) {
+			c, err := t.compileReader(string(source))
+			if err != nil {
+				return 0, err
+			}
+			e := newExecution(ctx)
+			e.data = stash
+			return t.run(e, w, c)
+		})
+}
+
+// compileReader compiles source for Gledki.ExecuteReader and returns the
+// panics as errors. The compiled template has no key, so it is not cached
+// even with its constants evaluated.
+func (t *Gledki) compileReader(source string) (c *compiledFile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	roots := t.activeRoots()
+	fullPath := t.withExt(readerPath)
+	meta, text, _, err := t.compileText(roots, fullPath, source)
+	if err != nil {
+		return nil, err
+	}
+	c = &compiledFile{path: fullPath, meta: meta}
+	if err = t.include(context.Background(), roots, c, text, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,54 @@
+/*
+Package redisgledki keeps the compiled [gledki] templates in Redis, so the
+instances of a multi-instance deployment share them, instead of each of them
+writing its own files. It is a separate module, so gledki itself does not
+depend on the Redis client:
+
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	tpls.CompiledStore = redisgledki.New(rdb, "gledki:")
+
+The keys are the full paths of the templates with the prefix prepended, so the
+templates must have the same full paths on all instances.
+*/
+package redisgledki
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store implements [gledki.CompiledStore] and [gledki.Pinger] with a Redis
+// client.
+type Store struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+// New returns a Store, which keeps the compiled templates in rdb under keys,
+// starting with prefix.
+func New(rdb redis.UniversalClient, prefix string) *Store {
+	return &Store{rdb: rdb, prefix: prefix}
+}
+
+// Get implements [gledki.CompiledStore]. Errors are treated as a missing
+// template, so it is compiled from the sources.
+func (s *Store) Get(key string) (string, bool) {
+	text, err := s.rdb.Get(context.Background(), s.prefix+key).Result()
+	return text, err == nil
+}
+
+// Set implements [gledki.CompiledStore].
+func (s *Store) Set(key, text string) error {
+	return s.rdb.Set(context.Background(), s.prefix+key, text, 0).Err()
+}
+
+// Delete implements [gledki.CompiledStore].
+func (s *Store) Delete(key string) error {
+	return s.rdb.Del(context.Background(), s.prefix+key).Err()
+}
+
+// Ping implements [gledki.Pinger].
+func (s *Store) Ping() error {
+	return s.rdb.Ping(context.Background()).Err()
+}
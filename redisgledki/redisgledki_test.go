@@ -0,0 +1,51 @@
+package redisgledki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	gl "github.com/kberov/gledki"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "page.htm"), []byte("<p>${include partials/item}</p>"), 0600)
+	_ = os.MkdirAll(filepath.Join(root, "partials"), 0750)
+	_ = os.WriteFile(filepath.Join(root, "partials", "item.htm"), []byte("${title}"), 0600)
+	tpls, err := gl.New([]string{root}, []string{".htm"}, [2]string{"${", "}"}, false)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	tpls.CompiledStore = New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "gledki:")
+	if err := tpls.Ready(); err != nil {
+		t.Fatalf("Error Ready: %s", err.Error())
+	}
+	tpls.Stash["title"] = "Title"
+	var b strings.Builder
+	if _, err := tpls.Execute(&b, "page"); err != nil {
+		t.Fatalf("Error Execute: %s", err.Error())
+	}
+	_ = tpls.Close()
+	key := "gledki:" + filepath.Join(root, "page.htm")
+	if keys := mr.Keys(); len(keys) != 2 || !mr.Exists(key) {
+		t.Fatalf("The compiled templates should be stored in Redis: %v", keys)
+	}
+	if _, err := os.Stat(filepath.Join(root, "page.htm"+gl.CompiledSuffix)); err == nil {
+		t.Fatal("No compiled file should be written")
+	}
+	if err := tpls.ClearCache(true); err != nil {
+		t.Fatalf("Error ClearCache: %s", err.Error())
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("The compiled templates should be deleted: %v", keys)
+	}
+	mr.Close()
+	if err := tpls.Ready(); err == nil {
+		t.Fatal("The stopped server should be reported")
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Package remote serves [gledki] templates from a web server – e.g. a central CMS,
+from which a fleet of renderers pulls the templates:
+
+	fsys, err := remote.New("https://cms.example.com/templates/", "/var/cache/app/templates")
+	if err != nil {
+		return err
+	}
+	tpls, err := gl.New(nil, []string{".htm"}, [2]string{"${", "}"}, false)
+	tpls.DefaultsFS = fsys
+	tpls.DefaultsTTL = time.Minute
+
+The FS is used as [gledki.Gledki.DefaultsFS], so the included files and the
+wrappers are fetched through it too. Local roots, passed to [gledki.New],
+override the remote templates. A template is fetched with GET base URL + its
+slash-separated path and cached on disk with its ETag and Last-Modified
+headers. When it is needed again after [FS.MaxAge], it is requested with
+If-None-Match and If-Modified-Since, so an unchanged template is not
+downloaded again. If the server cannot be reached, the cached copy is served,
+also after a restart of the application. The FS cannot list the templates, so
+[gledki.Gledki.Freeze] does not load them in advance.
+*/
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+// DefaultMaxAge is the default value for [FS.MaxAge].
+var DefaultMaxAge = 5 * time.Second
+
+// DefaultClient is the default value for [FS.Client]. Its timeout keeps a hung
+// server from blocking the rendering of the pages for long – the cached copy
+// is served instead.
+var DefaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// FS is an [fs.FS] with the templates on a web server. It is safe for
+// concurrent use.
+type FS struct {
+	base *url.URL
+	dir  string
+	mu   sync.Mutex
+	// slash-separated path => the last known state of the template
+	entries map[string]*entry
+	// slash-separated path => the request for the template in progress
+	calls map[string]*call
+	// The client for the requests. Default: [DefaultClient].
+	Client *http.Client
+	// How long a fetched template is used without asking the server again.
+	// Set [gledki.Gledki.DefaultsTTL] to check for changes periodically –
+	// MaxAge only saves the requests for the lookups of the same template in
+	// a short time. Default: [DefaultMaxAge].
+	MaxAge time.Duration
+}
+
+// entry is a template, fetched from the server, or a missing template. It is
+// not changed after it is added to FS.entries.
+type entry struct {
+	data    []byte
+	missing bool
+	// when the template was requested for the last time
+	checked time.Time
+	// the headers for the conditional requests and the time of the last
+	// change
+	meta meta
+}
+
+// call is a request for a template, which the concurrent lookups of the same
+// template wait for.
+type call struct {
+	done chan struct{}
+	e    *entry
+	err  error
+}
+
+// meta is stored next to the cached template.
+type meta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ModTime      time.Time `json:"mod_time"`
+}
+
+// metaSuffix is appended to the path of a cached template for the file with
+// its meta.
+const metaSuffix = ".meta.json"
+
+// New returns an FS for the templates under baseURL, cached in the directory
+// dir, which is created if needed.
+func New(baseURL, dir string) (*FS, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("remote: %s is not an http(s) URL", baseURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &FS{base: base, dir: dir, entries: make(map[string]*entry),
+		calls: make(map[string]*call), MaxAge: DefaultMaxAge}, nil
+}
+
+// Open opens the template name. The root "." is an empty directory.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return gl.TemplateFile(name, nil, time.Time{}), nil
+	}
+	e, err := f.get(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return gl.TemplateFile(name, e.data, e.meta.ModTime), nil
+}
+
+// ReadFile returns the text of the template name.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := f.get(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return bytes.Clone(e.data), nil
+}
+
+// Stat returns the size and the modification time of the template name. The
+// modification time is the Last-Modified header or, if the server does not
+// send it, the time when the changed template was fetched.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.Stat()
+}
+
+// get returns the template name from memory, if it was checked in the last
+// MaxAge, or from the server, or from the cache on disk, if the server cannot
+// be reached. Only one request per template is made at a time and the lock is
+// not held during the request, so a slow template does not block the others.
+func (f *FS) get(name string) (*entry, error) {
+	f.mu.Lock()
+	e := f.entries[name]
+	if e != nil && time.Since(e.checked) < f.MaxAge {
+		f.mu.Unlock()
+		return e.found()
+	}
+	if c := f.calls[name]; c != nil {
+		f.mu.Unlock()
+		<-c.done
+		if c.err != nil {
+			return nil, c.err
+		}
+		return c.e.found()
+	}
+	c := &call{done: make(chan struct{})}
+	f.calls[name] = c
+	f.mu.Unlock()
+
+	c.e, c.err = f.refresh(name, e)
+	f.mu.Lock()
+	if c.err == nil {
+		f.entries[name] = c.e
+	}
+	delete(f.calls, name)
+	f.mu.Unlock()
+	close(c.done)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.e.found()
+}
+
+// found returns e or fs.ErrNotExist, if the template is missing.
+func (e *entry) found() (*entry, error) {
+	if e.missing {
+		return nil, fs.ErrNotExist
+	}
+	return e, nil
+}
+
+// refresh returns the template name from the server. If the server cannot be
+// reached, it returns old or the template from the cache on disk.
+func (f *FS) refresh(name string, old *entry) (*entry, error) {
+	if old == nil {
+		old = f.load(name)
+	}
+	e, err := f.fetch(name, old)
+	if err != nil {
+		if old.data == nil {
+			return nil, err
+		}
+		// Serve the cached copy and do not ask the server for MaxAge.
+		e = &entry{data: old.data, meta: old.meta, checked: time.Now()}
+	}
+	return e, nil
+}
+
+// load returns the template name from the cache on disk or an empty entry.
+func (f *FS) load(name string) *entry {
+	e := &entry{}
+	data, err := os.ReadFile(f.cachePath(name))
+	if err != nil {
+		return e
+	}
+	metaData, err := os.ReadFile(f.cachePath(name) + metaSuffix)
+	if err == nil && json.Unmarshal(metaData, &e.meta) == nil {
+		e.data = data
+	}
+	return e
+}
+
+// fetch requests the template name from the server – conditionally, if old is
+// cached – and returns its new state.
+func (f *FS) fetch(name string, old *entry) (*entry, error) {
+	u := f.base.JoinPath(name)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if old.data != nil {
+		if old.meta.ETag != "" {
+			req.Header.Set("If-None-Match", old.meta.ETag)
+		}
+		if old.meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", old.meta.LastModified)
+		}
+	}
+	client := f.Client
+	if client == nil {
+		client = DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var e *entry
+	switch {
+	case resp.StatusCode == http.StatusNotModified && old.data != nil:
+		e = &entry{data: old.data, meta: old.meta}
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		e = &entry{missing: true}
+		f.remove(name)
+	case resp.StatusCode == http.StatusOK:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		m := meta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"),
+			ModTime: old.meta.ModTime}
+		if lm, err := http.ParseTime(m.LastModified); err == nil {
+			m.ModTime = lm
+		} else if old.data == nil || !bytes.Equal(data, old.data) {
+			m.ModTime = time.Now()
+		}
+		e = &entry{data: data, meta: m}
+		f.store(name, e)
+	default:
+		return nil, fmt.Errorf("remote: GET %s: %s", u, resp.Status)
+	}
+	e.checked = time.Now()
+	return e, nil
+}
+
+// store writes the template name and its meta to the cache on disk. A failure
+// is not fatal – the template is fetched again after a restart.
+func (f *FS) store(name string, e *entry) {
+	p := f.cachePath(name)
+	metaData, _ := json.Marshal(e.meta)
+	err := os.MkdirAll(filepath.Dir(p), 0750)
+	if err == nil {
+		err = os.WriteFile(p, e.data, 0640)
+	}
+	if err == nil {
+		err = os.WriteFile(p+metaSuffix, metaData, 0640)
+	}
+	if err != nil {
+		f.remove(name)
+	}
+}
+
+// remove deletes the template name from the cache on disk.
+func (f *FS) remove(name string) {
+	p := f.cachePath(name)
+	for _, p := range []string{p + metaSuffix, p} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+}
+
+// cachePath returns the path of the cached template name.
+func (f *FS) cachePath(name string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(name))
+}
@@ -0,0 +1,143 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	gl "github.com/kberov/gledki"
+)
+
+// server serves templates with ETags and counts the requests.
+type server struct {
+	mu       sync.Mutex
+	files    map[string]string
+	requests map[string]int
+	notMod   int
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/templates/")
+	s.requests[name]++
+	text, ok := s.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(text))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		s.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write([]byte(text))
+}
+
+func (s *server) set(name, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = text
+}
+
+func TestFS(t *testing.T) {
+	s := &server{requests: make(map[string]int), files: map[string]string{
+		"page.htm":          "${wrapper layout}${include partials/item}",
+		"layout.htm":        "<main>${content}</main>",
+		"partials/item.htm": "<p>${title}</p>",
+	}}
+	ts := httptest.NewServer(s)
+	dir := t.TempDir()
+	fsys, err := New(ts.URL+"/templates", dir)
+	if err != nil {
+		t.Fatalf("Error New: %s", err.Error())
+	}
+	fsys.MaxAge = time.Hour
+	if err := fstest.TestFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	tpls, _ := gl.New(nil, []string{".htm"}, [2]string{"${", "}"}, false)
+	tpls.DefaultsFS = fsys
+	tpls.DefaultsTTL = time.Nanosecond
+	render := func(expected string) {
+		t.Helper()
+		var b strings.Builder
+		if _, err := tpls.ExecuteWithRoots(&b, "page", gl.Stash{"title": "Remote"}); err != nil ||
+			b.String() != expected {
+			t.Fatalf("Wrong output: %q, %v", b.String(), err)
+		}
+	}
+	render("<main><p>Remote</p></main>")
+	if n := s.requests["partials/item.htm"]; n != 1 {
+		t.Fatalf("The include should be requested once in MaxAge: %d", n)
+	}
+	if _, err := fs.Stat(fsys, "missing.htm"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("A missing template should not exist: %v", err)
+	}
+	// A changed template is fetched and an unchanged one is not downloaded.
+	fsys.MaxAge = 0
+	s.set("partials/item.htm", "<p>${title}!</p>")
+	time.Sleep(10 * time.Millisecond)
+	render("<main><p>Remote!</p></main>")
+	if s.notMod == 0 {
+		t.Fatal("The unchanged templates should be requested conditionally")
+	}
+	// The cached copies are served by a new FS, while the server is down.
+	ts.Close()
+	fsys, _ = New(ts.URL+"/templates", dir)
+	fsys.Client = &http.Client{Timeout: time.Second}
+	if data, err := fs.ReadFile(fsys, "partials/item.htm"); err != nil || string(data) != "<p>${title}!</p>" {
+		t.Fatalf("The cached template should be served: %q, %v", data, err)
+	}
+	if _, err := fs.ReadFile(fsys, "missing.htm"); err == nil {
+		t.Fatal("A template, which is not cached, cannot be served")
+	}
+	if _, err := New("ftp://example.com/", dir); err == nil {
+		t.Fatal("Only http(s) URLs should be accepted")
+	}
+}
+
+func TestFSConcurrent(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow.htm") {
+			requests.Add(1)
+			<-release
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+	fsys, _ := New(ts.URL, t.TempDir())
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := fs.ReadFile(fsys, "slow.htm"); err != nil || string(data) != "/slow.htm" {
+				t.Errorf("Wrong template: %q, %v", data, err)
+			}
+		}()
+	}
+	// Another template is served, while the slow one is requested.
+	if data, err := fs.ReadFile(fsys, "fast.htm"); err != nil || string(data) != "/fast.htm" {
+		t.Fatalf("Wrong template: %q, %v", data, err)
+	}
+	close(release)
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("The concurrent lookups should make one request: %d", n)
+	}
+}
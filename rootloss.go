@@ -0,0 +1,83 @@
+package gledki
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrRootMissing is wrapped by the error, returned by [Gledki.Execute] when the
+// root directory of the template has disappeared while the application runs
+// – e.g. it was unmounted or removed by a bad deploy. See
+// [Gledki.KeepServingOnRootLoss].
+var ErrRootMissing = errors.New("root directory is missing")
+
+// RootHealth contains metrics about the roots, which disappeared at runtime.
+type RootHealth struct {
+	// The roots, which are missing now, sorted.
+	Missing []string
+	// How many times a root was found missing after it was present.
+	Losses uint64
+}
+
+// rootHealth collects RootHealth. It is safe for concurrent use.
+type rootHealth struct {
+	mu sync.Mutex
+	// root => since when it is missing
+	missing map[string]time.Time
+	losses  uint64
+}
+
+// RootHealth returns the metrics about the roots, which disappeared at
+// runtime.
+func (t *Gledki) RootHealth() RootHealth {
+	h := &t.base().roots
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return RootHealth{Missing: slices.Sorted(maps.Keys(h.missing)), Losses: h.losses}
+}
+
+// checkRoot checks if the root of the template fullPath still exists. The
+// loss of a root and its return are logged once. Returns an error wrapping
+// ErrRootMissing if the root is missing, unless Gledki.KeepServingOnRootLoss
+// is set.
+func (t *Gledki) checkRoot(roots []string, fullPath string) error {
+	if t.frozen() || !filepath.IsAbs(fullPath) || t.bundled(fullPath) {
+		return nil
+	}
+	root := ""
+	for _, r := range slices.Concat(roots, slices.Collect(maps.Values(t.namedRoots))) {
+		if rel, err := filepath.Rel(r, fullPath); err == nil && filepath.IsLocal(rel) {
+			root = r
+			break
+		}
+	}
+	if root == "" {
+		return nil
+	}
+	h := &t.base().roots
+	exists := dirExists(root)
+	h.mu.Lock()
+	since, wasMissing := h.missing[root]
+	switch {
+	case exists && wasMissing:
+		delete(h.missing, root)
+		t.Logger.Infof("root %s is back after %s", root, time.Since(since).Round(time.Second))
+	case !exists && !wasMissing:
+		if h.missing == nil {
+			h.missing = make(map[string]time.Time)
+		}
+		h.missing[root] = time.Now()
+		h.losses++
+		t.Logger.Errorf("%v: %s", ErrRootMissing, root)
+	}
+	h.mu.Unlock()
+	if exists || t.KeepServingOnRootLoss {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRootMissing, root)
+}
@@ -0,0 +1,433 @@
+package gledki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// RootCondition decides if a conditional root is used for the current call.
+// It receives the [Stash] of the instance, so it can check values like the
+// language, the tenant or the type of the device.
+type RootCondition func(stash Stash) bool
+
+type conditionalRoot struct {
+	path   string
+	weight int
+	cond   RootCondition
+}
+
+/*
+AddConditionalRoot adds a root, which is searched for templates only when
+cond returns true for the current [Stash] – e.g. only for lang=bg, only for
+tenant X, only for mobile devices. The conditions are evaluated on every call
+to [Gledki.Execute], [Gledki.Compile] and [Gledki.LoadFile].
+
+Roots with a bigger weight are searched first. [Gledki.Roots] have weight 0, so
+a positive weight puts the root before them and a negative one – after them.
+Roots with equal weights are searched in the order they were added. The root
+is resolved like the roots, passed to [New].
+*/
+func (t *Gledki) AddConditionalRoot(root string, weight int, cond RootCondition) error {
+	found, err := t.findRoot(root)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i < len(t.conditionalRoots) && t.conditionalRoots[i].weight >= weight {
+		i++
+	}
+	t.conditionalRoots = slices.Insert(t.conditionalRoots, i,
+		conditionalRoot{path: found, weight: weight, cond: cond})
+	return nil
+}
+
+// activeRoots returns the roots to be searched for the current Stash.
+func (t *Gledki) activeRoots() []string {
+	if len(t.conditionalRoots) == 0 {
+		return t.Roots
+	}
+	var roots []string
+	added, active := false, false
+	for _, r := range t.conditionalRoots {
+		if r.weight <= 0 && !added {
+			roots, added = append(roots, t.Roots...), true
+		}
+		if r.cond(t.Stash) {
+			roots, active = append(roots, r.path), true
+		}
+	}
+	if !active {
+		return t.Roots
+	}
+	if !added {
+		roots = append(roots, t.Roots...)
+	}
+	return roots
+}
+
+/*
+WithRoots returns a lightweight view of t, which searches the templates in roots
+instead of [Gledki.Roots]. Use it to select a theme per request – e.g.
+["theme/dark", "templates"] for one request and ["theme/light", "templates"]
+for another – without keeping an instance per theme. The roots are resolved
+like the roots, passed to [New].
+
+The view has a copy of the [Stash] and the settings of t, so it can be modified
+for the request only. It shares with t the loaded files and all caches, so
+[Gledki.ClearCache], [Gledki.Invalidate], [Gledki.SetConstants] and
+[Gledki.CacheFragment], called on a view, affect t and all its views. Templates
+are cached by their full path and the roots they were compiled with, so views
+with different roots do not get each other's templates. Only templates,
+compiled with the roots of t, are stored on disk. Filters, prefix handlers,
+middlewares and translations are shared too, so register them on t before
+creating views.
+Views of views are views of t.
+*/
+func (t *Gledki) WithRoots(roots []string) (*Gledki, error) {
+	v := t.view()
+	if err := v.findRoots(roots); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+/*
+Clone returns a view of t with the same roots – see [Gledki.WithRoots]. Use a
+clone per request: its [Stash] and settings are a copy, so the values for one
+request are not seen by the others, while the loaded files and the compiled
+templates are shared and are not loaded and compiled again.
+
+	func handler(w http.ResponseWriter, r *http.Request) {
+		tpls := templates.Clone()
+		tpls.Stash["user"] = userName(r)
+		tpls.Execute(w, "home")
+	}
+*/
+func (t *Gledki) Clone() *Gledki {
+	v := t.view()
+	v.Roots = slices.Clone(t.Roots)
+	return v
+}
+
+// view returns a view of t without roots. See Gledki.WithRoots.
+func (t *Gledki) view() *Gledki {
+	b := t.base()
+	v := &Gledki{
+		Stash:                   maps.Clone(t.Stash),
+		DefaultStash:            t.DefaultStash,
+		origin:                  b,
+		Ext:                     t.Ext,
+		RootResolution:          t.RootResolution,
+		namedRoots:              maps.Clone(t.namedRoots),
+		conditionalRoots:        slices.Clone(t.conditionalRoots),
+		prefixHandlers:          b.prefixHandlers,
+		middlewares:             b.middlewares,
+		filters:                 b.filters,
+		translations:            b.translations,
+		locale:                  t.locale,
+		Tags:                    t.Tags,
+		FrontMatter:             t.FrontMatter,
+		CompiledSuffix:          t.CompiledSuffix,
+		CompiledStore:           t.CompiledStore,
+		CompiledDir:             t.CompiledDir,
+		CompiledNextToTemplates: t.CompiledNextToTemplates,
+		RenameRetries:           t.RenameRetries,
+		MaxClockSkew:            t.MaxClockSkew,
+		CacheTemplates:          t.CacheTemplates,
+		RecoverTagFuncs:         t.RecoverTagFuncs,
+		IncludeLimit:            t.IncludeLimit,
+		LoadWorkers:             t.LoadWorkers,
+		MaxValueSize:            t.MaxValueSize,
+		MaxValueSizeError:       t.MaxValueSizeError,
+		Strict:                  t.Strict,
+		Archive:                 t.Archive,
+		ArchiveSample:           t.ArchiveSample,
+		SnapshotsDir:            t.SnapshotsDir,
+		Snapshot:                t.Snapshot,
+		DefaultsFS:              t.DefaultsFS,
+		DefaultsTTL:             t.DefaultsTTL,
+		KeepServingOnRootLoss:   t.KeepServingOnRootLoss,
+		Hasher:                  t.Hasher,
+		Minify:                  t.Minify,
+		Debug:                   t.Debug,
+		AutoEscape:              t.AutoEscape,
+		OutputModes:             t.OutputModes,
+		PostCompile:             t.PostCompile,
+		OnMissingInclude:        t.OnMissingInclude,
+		OnIncludeError:          t.OnIncludeError,
+		OnStoreError:            t.OnStoreError,
+		CacheKey:                t.CacheKey,
+		Exclude:                 t.Exclude,
+		Layouts:                 t.Layouts,
+		FlushEvery:              t.FlushEvery,
+		Tracer:                  t.Tracer,
+		Metrics:                 t.Metrics,
+		Logger:                  t.Logger,
+	}
+	if v.Stash == nil {
+		v.Stash = make(Stash, 5)
+	}
+	return v
+}
+
+// base returns the instance, t is a view of, or t itself.
+func (t *Gledki) base() *Gledki {
+	if t.origin != nil {
+		return t.origin
+	}
+	return t
+}
+
+// ErrOutsideRoot is wrapped by the error, returned when the path in an
+// `include` or `wrapper` directive resolves to a file outside of the roots –
+// e.g. `${include ../../etc/passwd}`. This matters when the templates are
+// editable by users, for example in a CMS.
+var ErrOutsideRoot = errors.New("path is outside of the roots")
+
+// findInRoots is like findPath, but returns an error wrapping ErrOutsideRoot
+// if the found file is not inside any of roots or the named roots. A file,
+// which is in no root, is not read relative to the working directory – the
+// error wraps fs.ErrNotExist then, so Gledki.OnMissingInclude is called with
+// the returned path.
+func (t *Gledki) findInRoots(roots []string, path string) (string, error) {
+	fullPath := t.findPath(roots, path)
+	if virtual(fullPath) {
+		return fullPath, nil
+	}
+	if !filepath.IsAbs(fullPath) {
+		if !slices.Contains(strings.Split(filepath.ToSlash(fullPath), "/"), "..") {
+			return fullPath, fmt.Errorf("template file could not be read: %w",
+				&fs.PathError{Op: "open", Path: fullPath, Err: fs.ErrNotExist})
+		}
+	} else {
+		for _, root := range slices.Concat(roots, slices.Collect(maps.Values(t.namedRoots))) {
+			if rel, err := filepath.Rel(root, fullPath); err == nil && filepath.IsLocal(rel) {
+				return fullPath, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+}
+
+// cacheKey returns the key for a compiled template in Gledki.compiled – the
+// full path, prefixed by the roots, used to compile it – and whether roots are
+// the default Gledki.Roots. For views the default roots are the roots of the
+// instance they were created from.
+func (t *Gledki) cacheKey(roots []string, fullPath string) (string, bool) {
+	b := t.base()
+	keyFunc := b.CacheKey
+	if keyFunc == nil {
+		keyFunc = JoinedKey
+	}
+	return keyFunc(roots, fullPath), slices.Equal(roots, b.Roots)
+}
+
+// keyFor is like cacheKey, but the templates, compiled in debug mode, have their
+// own keys and are not stored, because the wrappers are annotated while
+// compiling. See Gledki.Debug.
+func (t *Gledki) keyFor(roots []string, fullPath string) (string, bool) {
+	key, isDefault := t.cacheKey(roots, fullPath)
+	if t.Debug {
+		return key + "\ndebug", false
+	}
+	return key, isDefault
+}
+
+/*
+AddRoot adds a root folder to [Gledki.Roots] at runtime – e.g. when a plugin or
+a theme is installed. If prepend is true, the root is searched first,
+otherwise – last. The root is resolved like the roots, passed to [New].
+
+The compiled templates are cached in memory by their full path and the roots,
+they were compiled with, so templates compiled before the change are not
+served anymore. The loaded files are cached by their full path only, because
+their content does not depend on the roots. The compiled files on disk may be
+composed from files in other roots, so they are deleted. AddRoot is not safe
+for concurrent use with [Gledki.Execute] on the same instance – use
+[Gledki.WithRoots] to change the roots for one request.
+*/
+func (t *Gledki) AddRoot(root string, prepend bool) error {
+	found, err := t.resolveRoot(root)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(t.Roots, found) {
+		return fmt.Errorf("root '%s' is already added", found)
+	}
+	roots := append([]string{}, t.Roots...)
+	if prepend {
+		roots = slices.Insert(roots, 0, found)
+	} else {
+		roots = append(roots, found)
+	}
+	return t.changeRoots(roots)
+}
+
+// SetRoots replaces [Gledki.Roots] at runtime with roots, resolved like the
+// roots, passed to [New]. See [Gledki.AddRoot].
+func (t *Gledki) SetRoots(roots []string) error {
+	found := make([]string, 0, len(roots))
+	for _, root := range roots {
+		r, err := t.resolveRoot(root)
+		if err != nil {
+			return err
+		}
+		found = append(found, r)
+	}
+	return t.changeRoots(found)
+}
+
+func (t *Gledki) changeRoots(roots []string) error {
+	b := t.base()
+	b.wg.Wait()
+	old := t.Roots
+	t.Roots = roots
+	if t.origin != nil {
+		// Views do not store compiled files with their own roots.
+		return nil
+	}
+	// The partials, compiled during the executions, may be in other roots now.
+	b.mu.Lock()
+	b.runtimeDeps = nil
+	b.mu.Unlock()
+	return t.removeCompiled(slices.Concat(old, roots))
+}
+
+// ShadowReport tells which templates in one root (a theme) shadow templates in
+// another root (the base theme). All paths are relative to the roots and
+// sorted.
+type ShadowReport struct {
+	// Templates present in both roots – the theme overrides them.
+	Shadowed []string
+	// Templates present only in the base root.
+	OnlyBase []string
+	// Templates present only in the theme root.
+	OnlyTheme []string
+}
+
+// Shadows compares the templates in the directories base and theme, resolved
+// like the roots, passed to [New], so theme authors can see at a glance what
+// their theme overrides, relative to the base theme.
+func (t *Gledki) Shadows(base, theme string) (*ShadowReport, error) {
+	baseFiles, err := t.listTemplates(base)
+	if err != nil {
+		return nil, err
+	}
+	themeFiles, err := t.listTemplates(theme)
+	if err != nil {
+		return nil, err
+	}
+	r := &ShadowReport{}
+	for _, path := range baseFiles {
+		if _, ok := slices.BinarySearch(themeFiles, path); ok {
+			r.Shadowed = append(r.Shadowed, path)
+		} else {
+			r.OnlyBase = append(r.OnlyBase, path)
+		}
+	}
+	for _, path := range themeFiles {
+		if _, ok := slices.BinarySearch(baseFiles, path); !ok {
+			r.OnlyTheme = append(r.OnlyTheme, path)
+		}
+	}
+	return r, nil
+}
+
+// Templates returns the sorted paths of all templates under the active roots,
+// relative to their roots. A template, shadowed by another in a root before
+// it, is listed once.
+func (t *Gledki) Templates() ([]string, error) {
+	var paths []string
+	err := t.eachTemplate(func(path string) error {
+		paths = append(paths, path)
+		return nil
+	})
+	slices.Sort(paths)
+	return paths, err
+}
+
+// listTemplates returns the sorted paths of all templates under root,
+// relative to it, without the excluded ones. See Gledki.Exclude.
+func (t *Gledki) listTemplates(root string) ([]string, error) {
+	root, err := t.findRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || t.excluded(rel) {
+			if err == nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() && t.isSource(path) {
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	slices.Sort(paths)
+	return paths, err
+}
+
+/*
+ScaffoldTheme creates the directory theme (if it does not exist) and puts in
+it stub copies of the templates from base, listed in paths, so the theme
+author can start overriding them. If link is true, symbolic links to the base
+templates are created instead of copies. Existing files in theme are not
+overwritten – an error is returned. The paths must be relative and must not
+leave base and theme (see [fs.ValidPath]) – an error wrapping [fs.ErrInvalid]
+is returned otherwise. The extension is appended to the paths if missing.
+Returns the [ShadowReport] for the new theme.
+*/
+func (t *Gledki) ScaffoldTheme(base, theme string, paths []string, link bool) (*ShadowReport, error) {
+	base, err := t.findRoot(base)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if !fs.ValidPath(filepath.ToSlash(path)) {
+			return nil, &fs.PathError{Op: "scaffold", Path: path, Err: fs.ErrInvalid}
+		}
+	}
+	if err = os.MkdirAll(theme, 0750); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		path = t.withExtIn(base, path)
+		src, dst := filepath.Join(base, path), filepath.Join(theme, path)
+		if isReadable(dst) {
+			return nil, fmt.Errorf("theme file '%s' already exists", dst)
+		}
+		if err = os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+			return nil, err
+		}
+		if link {
+			err = os.Symlink(src, dst)
+		} else {
+			err = copyFile(src, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.Shadows(base, theme)
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0640)
+}
@@ -0,0 +1,41 @@
+package gledki
+
+import "context"
+
+/*
+PushStash adds a scope with data on top of the [Stash] for the execution of the
+template, which passed ctx to a [TagFuncCtx]. The values in it are looked up
+before the ones in the Stash and in the scopes, pushed before it, and hide them,
+while the Stash itself is not changed. [PopStash] removes the scope and the
+hidden values are seen again. The scopes belong to the execution, so they are
+dropped after it and temporary values do not need to be deleted by hand:
+
+	tpls.Stash["items"] = gl.TagFuncCtx(func(ctx context.Context, w io.Writer, tag string) (int, error) {
+		gl.PushStash(ctx, gl.Stash{"count": strconv.Itoa(len(items))})
+		return w.Write(…)
+	})
+
+data is used as is, not copied. The data, passed to [Gledki.ExecuteWithRoots]
+and [WithStash], is still looked up first. PushStash returns false and does
+nothing if ctx is not the context of an execution.
+*/
+func PushStash(ctx context.Context, data Stash) bool {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok {
+		return false
+	}
+	e.scopes = append(e.scopes, data)
+	return true
+}
+
+// PopStash removes the last scope, added with [PushStash] to the execution of
+// ctx, and returns it. It returns nil if there are no scopes.
+func PopStash(ctx context.Context) Stash {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok || len(e.scopes) == 0 {
+		return nil
+	}
+	data := e.scopes[len(e.scopes)-1]
+	e.scopes = e.scopes[:len(e.scopes)-1]
+	return data
+}
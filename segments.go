@@ -0,0 +1,302 @@
+package gledki
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compiledFile is a compiled template. Its text is split into segments at the
+// places of the `include` directives. Included files are compiled separately
+// and referenced by the segments, so the content of a partial, included by
+// many templates, is kept only once in memory and on disk.
+type compiledFile struct {
+	// full path to the template file
+	path string
+	// key in Gledki.compiled – the full path, prefixed by the roots, used to
+	// compile the file
+	key string
+	// the wrapped template text split by include directives
+	segments []segment
+	// how deep are the nested inclusions in this file
+	height int
+	// the values from the front matter, see Gledki.Meta
+	meta map[string]string
+	// the roots, the template was compiled with, to find its wrappers, see
+	// Gledki.invalidate
+	roots []string
+}
+
+// segment is an immutable piece of a compiled template. It is either literal
+// text with placeholders or a reference to an included file. In the latter
+// case text is the include directive itself.
+type segment struct {
+	text string
+	file *compiledFile
+}
+
+// path => compiled template
+type compiledMap map[string]*compiledFile
+
+// fragment is the rendered output of an included file, reused until it
+// expires.
+type fragment struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires time.Time
+	output  []byte
+	// see execution.filtered
+	filtered int64
+}
+
+// CacheFragment makes [Gledki.Execute] render the included file path only once
+// per ttl and reuse its output in all templates which include it – like
+// fragment caching in other frameworks. Use it for partials, which are
+// expensive to render (e.g. TagFuncs querying a database) and do not depend on
+// per request data. A ttl of 0 means that the fragment never expires. The
+// cached output is dropped by [Gledki.ExpireFragment], [Gledki.Invalidate] and
+// [Gledki.ClearCache].
+func (t *Gledki) CacheFragment(path string, ttl time.Duration) {
+	fullPath := t.toFullPath(path)
+	b := t.base()
+	b.mu.Lock()
+	b.fragments[fullPath] = &fragment{ttl: ttl}
+	b.mu.Unlock()
+}
+
+// ExpireFragment drops the cached output of the included file path, so it
+// will be rendered again on the next [Gledki.Execute].
+func (t *Gledki) ExpireFragment(path string) {
+	if f, ok := t.fragment(t.toFullPath(path)); ok {
+		f.expire()
+	}
+}
+
+// fragment returns the fragment for the included file fullPath, if it is
+// cached, see Gledki.CacheFragment.
+func (t *Gledki) fragment(fullPath string) (*fragment, bool) {
+	b := t.base()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	f, ok := b.fragments[fullPath]
+	return f, ok
+}
+
+// CachedTagFunc returns a TagFunc, which writes the output of f, produced once
+// per ttl – like [Gledki.CacheFragment], but for a single TagFunc (e.g.
+// "other_books", hitting the database). The output must not depend on the
+// tag or on per request data, because it is reused for all of them. A ttl of 0
+// means that the output never expires. The output is not cached if f returns
+// an error. key identifies the output for [Gledki.ExpireCachedTagFunc]. A
+// TagFunc for an existing key shares its output.
+func (t *Gledki) CachedTagFunc(key string, ttl time.Duration, f TagFunc) TagFunc {
+	b := t.base()
+	b.mu.Lock()
+	if b.tagFragments == nil {
+		b.tagFragments = make(map[string]*fragment)
+	}
+	frag, ok := b.tagFragments[key]
+	if !ok {
+		frag = &fragment{ttl: ttl}
+		b.tagFragments[key] = frag
+	}
+	b.mu.Unlock()
+	return func(w io.Writer, tag string) (int, error) {
+		frag.mu.Lock()
+		output := frag.output
+		if output == nil || frag.ttl > 0 && time.Now().After(frag.expires) {
+			var buf bytes.Buffer
+			if _, err := f(&buf, tag); err != nil {
+				frag.mu.Unlock()
+				return 0, err
+			}
+			output = buf.Bytes()
+			frag.output = output
+			frag.expires = time.Now().Add(frag.ttl)
+		}
+		frag.mu.Unlock()
+		return w.Write(output)
+	}
+}
+
+// ExpireCachedTagFunc drops the cached output for key, so it will be produced
+// again on the next use of the TagFunc, returned by [Gledki.CachedTagFunc].
+func (t *Gledki) ExpireCachedTagFunc(key string) {
+	b := t.base()
+	b.mu.RLock()
+	frag, ok := b.tagFragments[key]
+	b.mu.RUnlock()
+	if ok {
+		frag.expire()
+	}
+}
+
+// expireFragments drops the output of all fragments. They are expired after
+// the lock is released, because a fragment is locked while it is rendered,
+// which may need the lock.
+func (t *Gledki) expireFragments() {
+	t.mu.RLock()
+	fragments := slices.Collect(maps.Values(t.fragments))
+	t.mu.RUnlock()
+	for _, f := range fragments {
+		f.expire()
+	}
+}
+
+func (f *fragment) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.output = nil
+}
+
+// CompileStats describes the composition of a compiled template, so budgets
+// like "no page may pull in more than 40 partials / 500 KB of template source"
+// can be enforced.
+type CompileStats struct {
+	// Full path to the main template.
+	Path string
+	// Number of distinct files the template is composed of, including itself.
+	// Wrappers are part of the files they wrap and are not counted.
+	Files int
+	// Number of include directives resolved, including repeated ones.
+	Includes int
+	// Size of the composed template in bytes.
+	Bytes int
+	// How deep the nested inclusions go, starting from 0 in the main template.
+	Depth int
+}
+
+// Describe compiles (if needed) the template and returns statistics about
+// its composition.
+func (t *Gledki) Describe(path string) (*CompileStats, error) {
+	c, err := t.compileMain(path)
+	if err != nil {
+		return nil, err
+	}
+	stats := &CompileStats{Path: c.path, Depth: c.height}
+	seen := make(map[*compiledFile]bool)
+	var walk func(c *compiledFile)
+	walk = func(c *compiledFile) {
+		if !seen[c] {
+			seen[c] = true
+			stats.Files++
+		}
+		for _, s := range c.segments {
+			if s.file != nil {
+				stats.Includes++
+				walk(s.file)
+				continue
+			}
+			stats.Bytes += len(s.text)
+		}
+	}
+	walk(c)
+	return stats, nil
+}
+
+// String returns the full text of the compiled template with the included
+// files in place of the include directives.
+func (c *compiledFile) String() string {
+	var b strings.Builder
+	c.writeText(&b)
+	return b.String()
+}
+
+// Compiled returns the full text of the template path, compiled with the
+// active roots, with the included files in place – like [Gledki.Compile], but
+// only if it is already compiled and cached in memory. Use it in tests to
+// assert on the composed template.
+func (t *Gledki) Compiled(path string) (string, bool) {
+	roots := t.activeRoots()
+	key, _ := t.keyFor(roots, t.findPath(roots, path))
+	b := t.base()
+	b.mu.RLock()
+	c, ok := b.compiled[key]
+	b.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return c.String(), true
+}
+
+/*
+SetCompiled puts text in the memory cache as the compiled template path for the
+active roots, so it is executed instead of the file, which may not exist.
+text is treated like a compiled template, read from [Gledki.CompiledStore] – it
+may have front matter and its include directives are compiled, but its wrapper
+directives are not. Use it in tests to inject fixtures. Nothing is stored and
+the template is dropped by [Gledki.Invalidate] and [Gledki.ClearCache].
+*/
+func (t *Gledki) SetCompiled(path, text string) error {
+	if t.frozen() {
+		return ErrFrozen
+	}
+	roots := t.activeRoots()
+	fullPath := t.findPath(roots, path)
+	key, _ := t.keyFor(roots, fullPath)
+	meta, text := cutFrontMatter(text)
+	c := &compiledFile{path: fullPath, key: key, meta: meta, roots: roots}
+	if err := t.include(context.Background(), roots, c, text, 0); err != nil {
+		return err
+	}
+	b := t.base()
+	b.mu.Lock()
+	b.compiled[key] = c
+	delete(b.evaluated, key)
+	b.mu.Unlock()
+	return nil
+}
+
+func (c *compiledFile) writeText(b *strings.Builder) {
+	for _, s := range c.segments {
+		if s.file != nil {
+			s.file.writeText(b)
+			continue
+		}
+		b.WriteString(s.text)
+	}
+}
+
+// source returns the text of the compiled template with the include
+// directives kept in place. This is what is stored on disk.
+func (c *compiledFile) source() string {
+	var b strings.Builder
+	for _, s := range c.segments {
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+// evaluate returns a copy of c with the constants replaced in all segments.
+// Included files are evaluated once and shared like in c. A c without key,
+// compiled by Gledki.ExecuteReader, is evaluated every time.
+func (t *Gledki) evaluate(c *compiledFile) *compiledFile {
+	b := t.base()
+	b.mu.RLock()
+	e, ok := b.evaluated[c.key]
+	constants := b.constants
+	b.mu.RUnlock()
+	if ok {
+		return e
+	}
+	e = &compiledFile{path: c.path, key: c.key, height: c.height, meta: c.meta,
+		segments: make([]segment, len(c.segments))}
+	for i, s := range c.segments {
+		if s.file != nil {
+			e.segments[i] = segment{text: s.text, file: t.evaluate(s.file)}
+			continue
+		}
+		e.segments[i] = segment{text: t.FtExecStringStd(s.text, constants)}
+	}
+	if t.CacheTemplates && c.key != "" {
+		b.mu.Lock()
+		b.evaluated[c.key] = e
+		b.mu.Unlock()
+	}
+	return e
+}
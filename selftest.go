@@ -0,0 +1,54 @@
+package gledki
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+/*
+SelfTest renders the critical templates – the keys of entries – with the
+fixture data for them, looked up before the [Stash]. Call it at startup to
+prevent a bad deploy of templates from going live. It fails fast and returns
+the first error – a template which cannot be compiled or executed, a panic in
+a TagFunc or a tag without a value in the fixture data, the Stash or a prefix
+handler. The templates are tested in the order of their paths.
+*/
+func (t *Gledki) SelfTest(entries map[string]Stash) error {
+	for _, path := range slices.Sorted(maps.Keys(entries)) {
+		if err := t.selfTest(path, entries[path]); err != nil {
+			return fmt.Errorf("self-test of '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (t *Gledki) selfTest(path string, data Stash) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	c, err := t.compileMain(path)
+	if err != nil {
+		return err
+	}
+	var unresolved []string
+	e := &execution{ctx: context.Background(), data: data, unresolved: &unresolved}
+	// Not t.run – the rendered pages must not be archived.
+	if len(t.base().constants) > 0 {
+		c = t.evaluate(c)
+	}
+	_, err = t.execute(e, io.Discard, c)
+	t.base().wg.Wait()
+	if err != nil {
+		return err
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("unresolved tags: %s", strings.Join(unresolved, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package gledki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowDiff is the result of rendering a page against the candidate roots,
+// passed to the report function of [Gledki.StartShadow].
+type ShadowDiff struct {
+	// Path to the main template, relative to its root.
+	Path string
+	// The page as it was served.
+	Output []byte
+	// The page rendered against the candidate roots. Empty if Err is not nil.
+	Candidate []byte
+	// Offset of the first differing byte. -1 if the outputs are equal.
+	Offset int
+	// How long it took to render the served page and the candidate.
+	Duration, CandidateDuration time.Duration
+	// Error from compiling or executing the candidate.
+	Err error
+}
+
+// Equal returns true if the candidate is the same as the served page.
+func (d *ShadowDiff) Equal() bool {
+	return d.Err == nil && d.Offset < 0
+}
+
+// ShadowStats are the counters of the shadow rendering since
+// [Gledki.StartShadow].
+type ShadowStats struct {
+	// Number of pages rendered against the candidate roots.
+	Rendered uint64
+	// Number of candidates, which differ from the served pages.
+	Differed uint64
+	// Number of candidates, which failed to compile or execute.
+	Failed uint64
+}
+
+// shadowing is the state of the shadow rendering, shared by all views.
+type shadowing struct {
+	roots                      []string
+	percent                    uint64
+	report                     func(*ShadowDiff)
+	pages                      atomic.Uint64
+	wg                         sync.WaitGroup
+	rendered, differed, failed atomic.Uint64
+}
+
+/*
+StartShadow makes [Gledki.Execute] render percent of the pages additionally
+against the candidate roots in a goroutine and compare the output with the
+served page. Every comparison is passed to report, which may be nil, and
+counted in [Gledki.ShadowStats]. Use it to canary template changes on real
+traffic before switching to the candidate roots with [Gledki.SetRoots].
+
+The candidate is rendered with a copy of the [Stash] and the data for the
+execution, so TagFuncs are called twice for the shadowed pages – make sure
+they have no side effects. The context of the execution is passed without
+its cancellation. Only the served page is archived. The shadow rendering is
+shared by all views of t. Calling StartShadow again replaces the candidate
+roots and resets the counters.
+*/
+func (t *Gledki) StartShadow(roots []string, percent int, report func(*ShadowDiff)) error {
+	if percent < 1 || percent > 100 {
+		return errors.New("percent of shadowed pages must be between 1 and 100")
+	}
+	s := &shadowing{percent: uint64(percent), report: report}
+	for _, root := range roots {
+		found, err := t.resolveRoot(root)
+		if err != nil {
+			return err
+		}
+		s.roots = append(s.roots, found)
+	}
+	if old := t.base().shadow.Swap(s); old != nil {
+		old.wg.Wait()
+	}
+	return nil
+}
+
+// StopShadow stops the shadow rendering and waits for the running renders to
+// finish.
+func (t *Gledki) StopShadow() {
+	if s := t.base().shadow.Swap(nil); s != nil {
+		s.wg.Wait()
+	}
+}
+
+// ShadowStats returns the counters of the current shadow rendering.
+func (t *Gledki) ShadowStats() ShadowStats {
+	s := t.base().shadow.Load()
+	if s == nil {
+		return ShadowStats{}
+	}
+	return ShadowStats{Rendered: s.rendered.Load(), Differed: s.differed.Load(),
+		Failed: s.failed.Load()}
+}
+
+// shadowing returns the current shadow rendering if the page, being rendered,
+// is to be rendered against the candidate roots too.
+func (t *Gledki) shadowing() *shadowing {
+	s := t.base().shadow.Load()
+	if s == nil {
+		return nil
+	}
+	// spread the shadowed pages evenly: n*percent/100 grows by one for every
+	// shadowed page
+	n := s.pages.Add(1)
+	if n*s.percent/100 == (n-1)*s.percent/100 {
+		return nil
+	}
+	return s
+}
+
+// renderShadow renders the template fullPath against the candidate roots in a
+// goroutine and reports the difference from output.
+func (t *Gledki) renderShadow(s *shadowing, e *execution, fullPath string, output []byte, elapsed time.Duration) {
+	d := &ShadowDiff{Path: t.relPath(fullPath), Output: output, Offset: -1, Duration: elapsed}
+	v, err := t.WithRoots(s.roots)
+	if err != nil {
+		s.done(d, err)
+		return
+	}
+	candidate := &execution{ctx: context.WithoutCancel(e.ctx), data: maps.Clone(e.data)}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			// a broken candidate must not crash the application
+			if r := recover(); r != nil {
+				s.done(d, fmt.Errorf("panic in shadow rendering of %s: %v", d.Path, r))
+			}
+		}()
+		start := time.Now()
+		c, err := v.compile(context.Background(), v.Roots, v.findPath(v.Roots, d.Path), 0)
+		if err != nil {
+			s.done(d, err)
+			return
+		}
+		if len(v.base().constants) > 0 {
+			c = v.evaluate(c)
+		}
+		var buf bytes.Buffer
+		_, err = v.execute(candidate, &buf, c)
+		v.wg.Wait()
+		d.CandidateDuration = time.Since(start)
+		if err == nil {
+			d.Candidate = buf.Bytes()
+			d.Offset = diffOffset(d.Output, d.Candidate)
+		}
+		s.done(d, err)
+	}()
+}
+
+// done counts and reports d.
+func (s *shadowing) done(d *ShadowDiff, err error) {
+	d.Err = err
+	s.rendered.Add(1)
+	if err != nil {
+		s.failed.Add(1)
+	} else if d.Offset >= 0 {
+		s.differed.Add(1)
+	}
+	if s.report != nil {
+		s.report(d)
+	}
+}
+
+// diffOffset returns the offset of the first differing byte in a and b or -1
+// if they are equal.
+func diffOffset(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) == len(b) {
+		return -1
+	}
+	return n
+}
+
+// relPath returns fullPath relative to the root it was found in, so it can be
+// searched in other roots.
+func (t *Gledki) relPath(fullPath string) string {
+	if path, ok := strings.CutPrefix(fullPath, defaultsPrefix); ok {
+		return path
+	}
+	if path, ok := strings.CutPrefix(fullPath, memoryPrefix); ok {
+		return path
+	}
+	for _, root := range t.activeRoots() {
+		if rel, err := filepath.Rel(root, fullPath); err == nil && filepath.IsLocal(rel) {
+			return rel
+		}
+	}
+	return fullPath
+}
@@ -0,0 +1,112 @@
+package gledki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+SaveSnapshot compiles all templates under the active roots and stores their
+composed text – with the wrappers and included files in place – as a snapshot
+in the directory version under [Gledki.SnapshotsDir]. A snapshot keeps what the
+templates were at the moment, so pages can be rendered exactly like they were
+shown at a past date with [Gledki.RenderAsOf] – terms of service, invoices
+etc., even if the template files were changed since then. Set
+[Gledki.Snapshot] to version, so the archived pages know the snapshot they
+were rendered with. An existing snapshot is not overwritten – an error is
+returned. To ship the compiled templates with the application instead, see
+[Gledki.WriteBundle] and [Gledki.ReadBundle].
+*/
+func (t *Gledki) SaveSnapshot(version string) (err error) {
+	dir, err := t.snapshotDir(version)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(dir); err == nil {
+		return fmt.Errorf("snapshot '%s' already exists", version)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("snapshot '%s': %v", version, r)
+		}
+	}()
+	return t.eachTemplate(func(path string) error {
+		text, err := t.Compile(path)
+		if err != nil {
+			return err
+		}
+		return writeTemplate(dir, path, text)
+	})
+}
+
+// eachTemplate calls f for the relative path of every template under the
+// active roots. Shadowed templates are skipped.
+func (t *Gledki) eachTemplate(f func(path string) error) error {
+	seen := make(map[string]bool)
+	for _, root := range t.activeRoots() {
+		paths, err := t.listTemplates(root)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if err = f(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTemplate writes text to the relative path under dir.
+func writeTemplate(dir, path, text string) error {
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(text), 0640)
+}
+
+// RenderAsOf is like [Gledki.ExecuteWithRoots], but renders the template path
+// from the snapshot version, saved by [Gledki.SaveSnapshot], instead of the
+// current templates. data is looked up before the [Stash] and may be nil. The
+// data of an [ArchivedPage] may be used to render it again.
+func (t *Gledki) RenderAsOf(w io.Writer, path string, data Stash, version string) (int64, error) {
+	return t.withMiddlewares(context.Background(), w, path,
+		func(ctx context.Context, w io.Writer, path string) (int64, error) {
+			return t.renderAsOf(ctx, w, path, data, version)
+		})
+}
+
+// renderAsOf is RenderAsOf without the middlewares.
+func (t *Gledki) renderAsOf(ctx context.Context, w io.Writer, path string, data Stash, version string) (int64, error) {
+	dir, err := t.snapshotDir(version)
+	if err != nil {
+		return 0, err
+	}
+	path = t.withExtIn(dir, filepath.FromSlash(path))
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot '%s': %w", version, err)
+	}
+	c := &compiledFile{path: file, key: file, segments: []segment{{text: string(text)}}}
+	return t.run(&execution{ctx: ctx, data: data}, w, c)
+}
+
+func (t *Gledki) snapshotDir(version string) (string, error) {
+	if t.SnapshotsDir == "" {
+		return "", errors.New("Gledki.SnapshotsDir is not set")
+	}
+	if version == "" || version != filepath.Base(version) || version == ".." {
+		return "", fmt.Errorf("invalid snapshot version '%s'", version)
+	}
+	return filepath.Join(t.SnapshotsDir, version), nil
+}
@@ -0,0 +1,62 @@
+package gledki
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// SourceError is returned by [Gledki.Compile] and [Gledki.Execute] when an
+// `include` or `wrapper` directive fails. It tells in which source file and on
+// which line the directive is – in the template itself or in one of its
+// wrappers, which are merged with it in the compiled text.
+type SourceError struct {
+	// Full path to the file with the directive.
+	Path string
+	// Line of the directive in the file, starting from 1.
+	Line int
+	// The directive as found in the compiled text, e.g. "${include footer}".
+	Directive string
+	Err       error
+}
+
+func (e *SourceError) Error() string {
+	return spf("%s:%d: %s: %v", e.Path, e.Line, e.Directive, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// sourceError returns err as a *SourceError with the file and line of the
+// directive raw, searched in the file fullPath and then in its wrappers. Like
+// in Gledki.addWrappers, the wrappers are found in the source files, because
+// the compiled text does not reference them. err is returned as is, if it is
+// already a *SourceError from an included file, or if raw is not found – e.g.
+// the file has its own tags.
+func (t *Gledki) sourceError(roots []string, fullPath, raw string, err error) error {
+	if se := (*SourceError)(nil); errors.As(err, &se) {
+		return err
+	}
+	for range t.IncludeLimit + 1 {
+		text, lerr := t.loadFile(roots, fullPath)
+		if lerr != nil {
+			break
+		}
+		if i := strings.Index(text, raw); i >= 0 {
+			return &SourceError{Path: fullPath, Line: strings.Count(text[:i], "\n") + 1,
+				Directive: raw, Err: err}
+		}
+		nodes := t.Parse(text)
+		i := slices.IndexFunc(nodes, func(n Node) bool {
+			return n.Kind == DirectiveNode && n.Name == "wrapper"
+		})
+		if i < 0 {
+			break
+		}
+		if fullPath, lerr = t.findInRoots(roots, nodes[i].Arg); lerr != nil {
+			break
+		}
+	}
+	return err
+}
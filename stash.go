@@ -0,0 +1,124 @@
+package gledki
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+StashFromJSON returns a Stash with the values from the JSON object data. The
+nested objects and arrays are flattened into dotted keys, so
+`{"user": {"name": "Ана"}, "tags": ["a", "b"]}` gives "user.name", "tags.0" and
+"tags.1", usable in the templates as `${user.name}` and `${tags.0}`. All values
+are strings: numbers are kept as they are written, booleans are "true" or
+"false" and null is "".
+*/
+func StashFromJSON(data []byte) (Stash, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var values any
+	if err := d.Decode(&values); err != nil {
+		return nil, err
+	}
+	object, ok := values.(map[string]any)
+	if !ok {
+		return nil, errors.New("the JSON value is not an object")
+	}
+	s := make(Stash, len(object))
+	s.flatten("", object)
+	return s, nil
+}
+
+// flatten puts the value v in s under key, and its items under dotted keys if
+// v is an object or an array, decoded from JSON.
+func (s Stash) flatten(key string, v any) {
+	prefix := key
+	if prefix != "" {
+		prefix += "."
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			s.flatten(prefix+k, item)
+		}
+	case []any:
+		for i, item := range v {
+			s.flatten(prefix+strconv.Itoa(i), item)
+		}
+	case nil:
+		s[key] = ""
+	case string:
+		s[key] = v
+	case json.Number:
+		s[key] = v.String()
+	case bool:
+		s[key] = strconv.FormatBool(v)
+	}
+}
+
+// GetString returns the value for key as a string if it is a string, [Raw] or
+// []byte. Otherwise returns def. key is not resolved as a dotted path.
+func (s Stash) GetString(key, def string) string {
+	switch v := s[key].(type) {
+	case string:
+		return v
+	case Raw:
+		return string(v)
+	case []byte:
+		return string(v)
+	}
+	return def
+}
+
+// GetInt returns the value for key as an int if it is a number or a string
+// with an integer. Otherwise returns def.
+func (s Stash) GetInt(key string, def int) int {
+	if n, ok := toInt(s[key]); ok {
+		return n
+	}
+	return def
+}
+
+// GetBool returns the value for key if it is a bool or a string like "true",
+// "1" or "f", accepted by [strconv.ParseBool]. Otherwise returns def.
+func (s Stash) GetBool(key string, def bool) bool {
+	switch v := s[key].(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// GetSlice returns the value for key as []any if it is a slice or an array of
+// any type. Otherwise returns def.
+func (s Stash) GetSlice(key string, def []any) []any {
+	switch v := s[key].(type) {
+	case nil:
+		return def
+	case []any:
+		return v
+	case []string:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return items
+	}
+	rv := reflect.ValueOf(s[key])
+	if k := rv.Kind(); (k != reflect.Slice && k != reflect.Array) || rv.Type() == reflect.TypeFor[[]byte]() {
+		return def
+	}
+	items := make([]any, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items
+}
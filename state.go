@@ -0,0 +1,106 @@
+package gledki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// stateVersion is the version of the format of State.
+const stateVersion = 1
+
+// state is the serialized in-memory state of a Gledki instance.
+type state struct {
+	Version  int                      `json:"version"`
+	Files    filesMap                 `json:"files"`
+	Compiled map[string]compiledState `json:"compiled"`
+}
+
+type compiledState struct {
+	Path     string            `json:"path"`
+	Height   int               `json:"height"`
+	Roots    []string          `json:"roots,omitempty"`
+	Segments []segmentState    `json:"segments"`
+	Meta     map[string]string `json:"meta,omitempty"`
+}
+
+// segmentState is a segment. File is the key of the included file in
+// state.Compiled.
+type segmentState struct {
+	Text string `json:"text"`
+	File string `json:"file,omitempty"`
+}
+
+/*
+State serializes the loaded files and the compiled templates, so a restarted
+process can start warm with [Gledki.RestoreState] without reading and compiling
+thousands of files again. The result can be written to a file or passed to the
+new process through a socket or an inherited file descriptor during a graceful
+restart. The templates with constants, rendered fragments and settings are
+not part of the state.
+*/
+func (t *Gledki) State() ([]byte, error) {
+	b := t.base()
+	s := state{Version: stateVersion, Files: b.files.snapshot(),
+		Compiled: make(map[string]compiledState)}
+	b.mu.RLock()
+	for key, c := range b.compiled {
+		cs := compiledState{Path: c.path, Height: c.height, Roots: c.roots, Meta: c.meta,
+			Segments: make([]segmentState, len(c.segments))}
+		for i, seg := range c.segments {
+			cs.Segments[i].Text = seg.text
+			switch {
+			case seg.file == nil:
+			case seg.file.placeholder():
+				// Missing and failed includes are not in b.compiled.
+				for _, ps := range seg.file.segments {
+					cs.Segments[i].Text += ps.text
+				}
+			default:
+				cs.Segments[i].File = seg.file.key
+			}
+		}
+		s.Compiled[key] = cs
+	}
+	b.mu.RUnlock()
+	return json.Marshal(s)
+}
+
+// RestoreState adds the files and compiled templates from data, returned by
+// [Gledki.State], to the caches of t. The state must be produced by the same
+// version of gledki. Entries, already in the caches, are replaced.
+func (t *Gledki) RestoreState(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if s.Version != stateVersion {
+		return fmt.Errorf("state: unsupported version %d", s.Version)
+	}
+	compiled := make(compiledMap, len(s.Compiled))
+	for key, cs := range s.Compiled {
+		compiled[key] = &compiledFile{path: cs.Path, key: key, height: cs.Height, roots: cs.Roots, meta: cs.Meta,
+			segments: make([]segment, len(cs.Segments))}
+	}
+	for key, cs := range s.Compiled {
+		c := compiled[key]
+		for i, ss := range cs.Segments {
+			c.segments[i].text = ss.Text
+			if ss.File == "" {
+				continue
+			}
+			if c.segments[i].file = compiled[ss.File]; c.segments[i].file == nil {
+				return errors.New("state: missing included file " + ss.File)
+			}
+		}
+	}
+	b := t.base()
+	for path, text := range s.Files {
+		b.files.set(path, text)
+	}
+	b.mu.Lock()
+	maps.Copy(b.compiled, compiled)
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,244 @@
+package gledki
+
+import (
+	"crypto/sha256"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CompiledDir is the default value for [Gledki.CompiledDir].
+var CompiledDir = ""
+
+// CompiledNextToTemplates is the default value for
+// [Gledki.CompiledNextToTemplates].
+var CompiledNextToTemplates = false
+
+// UserCompiledDir returns the directory for the compiled templates in the cache
+// directory of the user – e.g. ~/.cache/gledki on Linux.
+func UserCompiledDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gledki"), nil
+}
+
+/*
+CompiledStore keeps the compiled templates between the runs of the
+application. The keys are the full paths of the templates. The default store
+writes them to disk in [Gledki.CompiledDir] or next to the templates (see
+[Gledki.CompiledNextToTemplates]).
+Implement it to share the compiled templates between the instances of a
+multi-instance deployment, instead of each of them writing its own files. The
+templates must have the same full paths on all instances. The modules
+[github.com/kberov/gledki/redisgledki] and
+[github.com/kberov/gledki/memcachegledki] implement it with Redis and
+memcached. A store of your own may look like this:
+
+	type redisStore struct{ rdb *redis.Client }
+
+	func (s redisStore) Get(key string) (string, bool) {
+		text, err := s.rdb.Get(context.Background(), "gledki:"+key).Result()
+		return text, err == nil
+	}
+
+	func (s redisStore) Set(key, text string) error {
+		return s.rdb.Set(context.Background(), "gledki:"+key, text, 0).Err()
+	}
+
+	func (s redisStore) Delete(key string) error {
+		return s.rdb.Del(context.Background(), "gledki:"+key).Err()
+	}
+*/
+type CompiledStore interface {
+	// Get returns the compiled template for key and true, or false if there
+	// is none.
+	Get(key string) (string, bool)
+	// Set stores the compiled template text for key. It is called in a
+	// goroutine. Errors are logged and reported by [Gledki.Ready].
+	Set(key, text string) error
+	// Delete removes the compiled template for key. It is not an error if
+	// there is none.
+	Delete(key string) error
+}
+
+// Pinger is implemented by the [CompiledStore] types, which can check if they
+// are reachable. [Gledki.Ready] calls Ping instead of probing the disk.
+type Pinger interface {
+	Ping() error
+}
+
+// diskStore is the default CompiledStore. It writes the compiled templates to
+// files with the suffix appended to the path of the template.
+type diskStore struct {
+	suffix string
+	// see Gledki.CompiledDir
+	dir string
+	// the roots of the templates, so their compiled files can be kept apart in
+	// dir
+	roots []string
+	// see Gledki.RenameRetries
+	retries int
+}
+
+// path returns the path of the compiled file for the template key – next to
+// the template or, if there is a dir, in dir/<hash of the root>/ with the same
+// path, relative to the root, as the template.
+func (s diskStore) path(key string) string {
+	if s.dir == "" {
+		return key + s.suffix
+	}
+	root, rel, found := filepath.Dir(key), filepath.Base(key), ""
+	for _, r := range s.roots {
+		if p, err := filepath.Rel(r, key); err == nil && filepath.IsLocal(p) && len(r) > len(found) {
+			found, root, rel = r, r, p
+		}
+	}
+	return filepath.Join(s.dir, fingerprint(sha256.New, []byte(root))[:16], rel) + s.suffix
+}
+
+func (s diskStore) Get(key string) (string, bool) {
+	data, err := os.ReadFile(s.path(key))
+	return string(data), err == nil
+}
+
+func (s diskStore) Set(key, text string) error {
+	path := s.path(key)
+	if s.dir != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, []byte(text), s.retries)
+}
+
+func (s diskStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RenameRetries is how many times the temporary file with a compiled template
+// is renamed again, if renaming it to the compiled file fails. This happens on
+// Windows, while another process reads or replaces the compiled file. It is
+// the default for [Gledki.RenameRetries].
+var RenameRetries = 5
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so
+// other instances and processes never read a partially written file. Every
+// writer has its own temporary file, so the writes of processes, which compile
+// the same template at the same time, never interleave – the last rename wins.
+// A failed rename is retried up to retries times with a growing delay.
+func writeFileAtomic(path string, data []byte, retries int) error {
+	fh, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), path)
+		for i := 0; err != nil && i < retries; i++ {
+			time.Sleep(time.Duration(i+1) * 10 * time.Millisecond)
+			err = os.Rename(fh.Name(), path)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(fh.Name())
+	}
+	return err
+}
+
+// isStored reports whether the compiled template for fullPath is stored.
+func (t *Gledki) isStored(fullPath string) bool {
+	_, ok := t.store().Get(fullPath)
+	return ok
+}
+
+// store returns Gledki.CompiledStore or the store on disk.
+func (t *Gledki) store() CompiledStore {
+	if t.CompiledStore != nil {
+		return t.CompiledStore
+	}
+	return diskStore{suffix: t.CompiledSuffix, dir: t.compiledDir(), retries: t.RenameRetries,
+		roots: slices.Concat(t.Roots, slices.Collect(maps.Values(t.namedRoots)))}
+}
+
+// compiledDir returns the directory for the compiled templates:
+// Gledki.CompiledDir, UserCompiledDir or "" for next to the templates. If the
+// cache directory of the user is not known, a warning is logged once and the
+// compiled templates are stored next to the templates.
+func (t *Gledki) compiledDir() string {
+	if t.CompiledNextToTemplates {
+		return ""
+	}
+	if t.CompiledDir != "" {
+		return t.CompiledDir
+	}
+	b := t.base()
+	b.userDirOnce.Do(func() {
+		var err error
+		if b.userDir, err = UserCompiledDir(); err != nil {
+			b.Logger.Warnf("the compiled templates are stored next to the templates: %v", err)
+		}
+	})
+	return b.userDir
+}
+
+// storeQueue holds the compiled templates, waiting to be stored. They are
+// stored one by one in a single goroutine, which runs while the queue is not
+// empty, so Gledki.Execute never waits for a slow disk.
+type storeQueue struct {
+	mu      sync.Mutex
+	jobs    []storeJob
+	running bool
+}
+
+// storeJob is a compiled template, waiting to be stored by the instance or
+// view t, which compiled it.
+type storeJob struct {
+	t              *Gledki
+	fullPath, text string
+}
+
+// enqueueStore adds the compiled template text for fullPath to the queue and
+// starts the goroutine, which stores the templates, if it is not running. Wait
+// for the queue to be stored with the wg of the base instance.
+func (t *Gledki) enqueueStore(fullPath, text string) {
+	b := t.base()
+	b.wg.Add(1)
+	q := &b.stores
+	q.mu.Lock()
+	q.jobs = append(q.jobs, storeJob{t: t, fullPath: fullPath, text: text})
+	start := !q.running
+	q.running = true
+	q.mu.Unlock()
+	if start {
+		go q.drain()
+	}
+}
+
+// drain stores the queued templates until the queue is empty.
+func (q *storeQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) == 0 {
+			q.jobs, q.running = nil, false
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		q.mu.Unlock()
+		job.t.storeCompiled(job.fullPath, job.text)
+	}
+}
@@ -0,0 +1,175 @@
+package gledki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+type prefixHandler struct {
+	prefix string
+	f      TagFuncCtx
+}
+
+// HandlePrefix registers f as a handler for all tags starting with prefix,
+// e.g. "i18n." or "asset.". [Gledki.Execute] calls it with the full tag, when
+// there is no entry for the tag in the [Stash]. This way whole families of
+// placeholders (`i18n.title`, `asset.main.css`) can be handled without
+// enumerating every key. If more than one prefix matches, the longest one
+// wins. Registering a prefix again replaces its handler.
+func (t *Gledki) HandlePrefix(prefix string, f TagFunc) {
+	t.HandlePrefixCtx(prefix, func(_ context.Context, w io.Writer, tag string) (int, error) {
+		return f(w, tag)
+	})
+}
+
+// HandlePrefixCtx is like [Gledki.HandlePrefix], but f receives also the
+// context of the execution, like a [TagFuncCtx] in the [Stash].
+func (t *Gledki) HandlePrefixCtx(prefix string, f TagFuncCtx) {
+	i := slices.IndexFunc(t.prefixHandlers, func(h prefixHandler) bool {
+		return h.prefix == prefix
+	})
+	if i >= 0 {
+		t.prefixHandlers[i].f = f
+		return
+	}
+	t.prefixHandlers = append(t.prefixHandlers, prefixHandler{prefix, f})
+	slices.SortStableFunc(t.prefixHandlers, func(a, b prefixHandler) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+}
+
+// prefixHandler returns the handler for the longest prefix of tag or nil.
+func (t *Gledki) prefixHandler(tag string) TagFuncCtx {
+	for _, h := range t.prefixHandlers {
+		if strings.HasPrefix(tag, h.prefix) {
+			return h.f
+		}
+	}
+	return nil
+}
+
+// Filter transforms the value of a placeholder. See [Gledki.RegisterFilter].
+type Filter func(string) string
+
+// RegisterFilter makes f available in the templates under name. Filters are
+// applied from left to right to the value for a tag, after it is looked up in
+// the [Stash]: `${name | upper | trim}`. Registering a name again replaces the
+// filter.
+func (t *Gledki) RegisterFilter(name string, f Filter) {
+	if t.filters == nil {
+		t.filters = make(map[string]Filter)
+	}
+	t.filters[name] = f
+}
+
+// filter renders the value for key with tagFunc and writes it to w, passed
+// through the filters, listed in pipe and escaped. The change of the size by the filters is
+// added to e.filtered.
+func (t *Gledki) filter(e *execution, w io.Writer, tagFunc TagFunc, key, pipe string) (int, error) {
+	var buf bytes.Buffer
+	// escape the filtered value, not the value for the filters
+	escape := e.escape
+	if escape != nil {
+		switch t.lookup(e, key).(type) {
+		case string, []byte:
+		default:
+			escape = nil
+		}
+	}
+	e.escape = nil
+	_, err := tagFunc(&buf, key)
+	e.escape = escape
+	if err != nil {
+		return 0, err
+	}
+	value := buf.String()
+	for _, name := range strings.Split(pipe, "|") {
+		name = strings.TrimSpace(name)
+		f, ok := t.filters[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown filter '%s' for tag '%s'", name, key)
+		}
+		value = f(value)
+	}
+	if escape != nil {
+		value = escape(value)
+	}
+	e.filtered += int64(len(value) - buf.Len())
+	return w.Write([]byte(value))
+}
+
+// lookupPath resolves a dotted tag like `user.name` or `book.Author` against
+// nested Stash and map values and exported struct fields. Values, which are
+// not of the types, supported in the Stash, are formatted with fmt.Sprint.
+func lookupPath(stash Stash, tag string) (any, bool) {
+	first, rest, found := strings.Cut(tag, ".")
+	if !found {
+		return nil, false
+	}
+	v, ok := stash[first]
+	for ok && rest != "" {
+		var key string
+		key, rest, _ = strings.Cut(rest, ".")
+		v, ok = lookupKey(v, key)
+	}
+	if !ok {
+		return nil, false
+	}
+	switch v.(type) {
+	case nil:
+		return nil, false
+	case []byte, string, Raw, TagFunc, TagFuncCtx:
+		return v, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// lookupKey returns the value for key in a map with string keys or the value
+// of the exported field key in a struct or a pointer to a struct.
+func lookupKey(v any, key string) (any, bool) {
+	switch m := v.(type) {
+	case Stash:
+		v, ok := m[key]
+		return v, ok
+	case map[string]any:
+		v, ok := m[key]
+		return v, ok
+	case map[string]string:
+		v, ok := m[key]
+		return v, ok
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		mv := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if !mv.IsValid() {
+			return nil, false
+		}
+		return mv.Interface(), true
+	case reflect.Struct:
+		f, ok := rv.Type().FieldByName(key)
+		if !ok || !f.IsExported() {
+			return nil, false
+		}
+		fv, err := rv.FieldByIndexErr(f.Index)
+		if err != nil {
+			return nil, false
+		}
+		return fv.Interface(), true
+	}
+	return nil, false
+}
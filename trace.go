@@ -0,0 +1,89 @@
+package gledki
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts the spans for [Gledki.Tracer]. The module
+// [github.com/kberov/gledki/otelgledki] adapts an OpenTelemetry tracer, so
+// gledki itself does not depend on it:
+//
+//	tpls.Tracer = otelgledki.New(otel.Tracer("gledki"))
+type Tracer interface {
+	// Start starts a span with name as a child of the span in ctx and
+	// returns a copy of ctx with the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span, started by a [Tracer].
+type Span interface {
+	// SetAttribute sets a string, bool or int64 attribute of the span.
+	SetAttribute(key string, value any)
+	// RecordError marks the span as failed.
+	RecordError(err error)
+	End()
+}
+
+// The names of the spans.
+const (
+	// [Gledki.Execute] and friends, from the compilation of the template to
+	// the last byte written
+	SpanExecute = "gledki.Execute"
+	// the compilation of the main template
+	SpanCompile = "gledki.Compile"
+	// the compilation of an included file, a child of the compilation of the
+	// including file
+	SpanInclude = "gledki.include"
+)
+
+// The attributes of the spans.
+const (
+	// The template as passed to [Gledki.Execute] or the full path of the
+	// compiled file.
+	AttrTemplate = "gledki.template"
+	// Whether the compiled template was found in memory.
+	AttrCacheHit = "gledki.cache_hit"
+	// Whether the compiled template was loaded from the [CompiledStore].
+	AttrStored = "gledki.stored"
+	// Number of bytes written by [Gledki.Execute].
+	AttrBytes = "gledki.bytes"
+)
+
+// Metrics collects metrics about the templates in [Gledki.Metrics]. The
+// package [github.com/kberov/gledki/metrics] exposes them for Prometheus and
+// the module [github.com/kberov/gledki/promgledki] registers them with the
+// Prometheus client library.
+type Metrics interface {
+	// ObserveCompile is called after the compilation of the main template
+	// fullPath, including the included files. cacheHit is true if the
+	// compiled template was found in memory.
+	ObserveCompile(fullPath string, d time.Duration, cacheHit bool)
+	// ObserveExecute is called after the execution of the compiled template
+	// fullPath with the number of bytes written and the error, if any.
+	ObserveExecute(fullPath string, d time.Duration, bytes int64, err error)
+}
+
+// startSpan starts a span with Gledki.Tracer. Returns a nil Span if there is
+// no Tracer.
+func (t *Gledki) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if t.Tracer == nil {
+		return ctx, nil
+	}
+	return t.Tracer.Start(ctx, name)
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func compileSpanName(depth int) string {
+	if depth > 0 {
+		return SpanInclude
+	}
+	return SpanCompile
+}
@@ -0,0 +1,127 @@
+package gledki
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// source is what is known about a template, loaded from Gledki.DefaultsFS.
+type source struct {
+	// modification time, reported by DefaultsFS, may be zero
+	modTime time.Time
+	// when the template was loaded or checked for changes for the last time
+	checked time.Time
+}
+
+// SetTTL sets the time after which the template path from [Gledki.DefaultsFS]
+// is checked for changes, overriding [Gledki.DefaultsTTL] for it. A ttl of 0
+// means that the template is never checked. Use it for templates which change
+// more often than others, e.g. the ones edited in a CMS.
+func (t *Gledki) SetTTL(path string, ttl time.Duration) {
+	path = t.withExt(path)
+	b := t.base()
+	b.sourcesMu.Lock()
+	defer b.sourcesMu.Unlock()
+	if b.ttls == nil {
+		b.ttls = make(map[string]time.Duration)
+	}
+	b.ttls[defaultsPrefix+filepath.ToSlash(path)] = ttl
+}
+
+// loaded records the state of the template fullPath, just loaded from
+// DefaultsFS.
+func (t *Gledki) loaded(fullPath string) {
+	s := &source{checked: time.Now()}
+	if info, err := fs.Stat(t.DefaultsFS, strings.TrimPrefix(fullPath, defaultsPrefix)); err == nil {
+		s.modTime = info.ModTime()
+	}
+	b := t.base()
+	b.sourcesMu.Lock()
+	defer b.sourcesMu.Unlock()
+	if b.sources == nil {
+		b.sources = make(map[string]*source)
+	}
+	b.sources[fullPath] = s
+}
+
+// refreshSources checks the templates from DefaultsFS, whose TTL has passed,
+// for changes. The check is conditional – the file is read again only if
+// DefaultsFS does not report modification times. The changed and removed
+// templates are invalidated with the templates, which depend on them. The
+// sources are checked without holding a lock, so a slow DefaultsFS does not
+// block the other executions.
+func (t *Gledki) refreshSources() {
+	b := t.base()
+	if t.DefaultsFS == nil || t.frozen() {
+		return
+	}
+	now := time.Now()
+	due := make(map[string]*source)
+	b.sourcesMu.Lock()
+	for path, s := range b.sources {
+		ttl, ok := b.ttls[path]
+		if !ok {
+			ttl = t.DefaultsTTL
+		}
+		if ttl <= 0 || now.Sub(s.checked) < ttl {
+			continue
+		}
+		s.checked = now
+		due[path] = s
+	}
+	b.sourcesMu.Unlock()
+	var changed []string
+	for path, s := range due {
+		if t.sourceChanged(path, s) {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	b.sourcesMu.Lock()
+	for _, path := range changed {
+		if b.sources[path] == due[path] {
+			delete(b.sources, path)
+		}
+	}
+	b.sourcesMu.Unlock()
+	if err := t.invalidate(changed...); err != nil {
+		t.Logger.Errorf("refreshing %v: %v", changed, err)
+	}
+}
+
+// sourceChanged returns true if the template fullPath in DefaultsFS is not
+// the same as when it was loaded or does not exist anymore. Other errors are
+// logged and the loaded template is kept, so a storage, which is down for a
+// while, does not break the pages.
+func (t *Gledki) sourceChanged(fullPath string, s *source) bool {
+	rel := strings.TrimPrefix(fullPath, defaultsPrefix)
+	info, err := fs.Stat(t.DefaultsFS, rel)
+	if err != nil {
+		return t.sourceGone(fullPath, err)
+	}
+	if !s.modTime.IsZero() && !info.ModTime().IsZero() {
+		return !info.ModTime().Equal(s.modTime)
+	}
+	data, err := fs.ReadFile(t.DefaultsFS, rel)
+	if err != nil {
+		return t.sourceGone(fullPath, err)
+	}
+	text, _ := t.base().files.get(fullPath)
+	return !bytes.Equal(data, []byte(text))
+}
+
+// sourceGone returns true if err tells that the template fullPath does not
+// exist and logs err otherwise.
+func (t *Gledki) sourceGone(fullPath string, err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	t.Logger.Warnf("checking %s for changes: %v", fullPath, err)
+	return false
+}
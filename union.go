@@ -0,0 +1,51 @@
+package gledki
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// Prefix of the full paths of the templates in Gledki.DefaultsFS.
+const defaultsPrefix = "defaults:"
+
+// findDefault returns the full path of the relative path in DefaultsFS or path
+// itself if it is not there.
+func (t *Gledki) findDefault(path string) string {
+	if t.DefaultsFS == nil {
+		return path
+	}
+	rel := filepath.ToSlash(path)
+	if !fs.ValidPath(rel) {
+		return path
+	}
+	if t.frozen() {
+		if _, ok := t.base().files.get(defaultsPrefix + rel); ok {
+			return defaultsPrefix + rel
+		}
+		return path
+	}
+	if _, err := fs.Stat(t.DefaultsFS, rel); err != nil {
+		return path
+	}
+	return defaultsPrefix + rel
+}
+
+// loadDefaults loads all templates from DefaultsFS, which are not overridden
+// in the roots.
+func (t *Gledki) loadDefaults() error {
+	if t.DefaultsFS == nil {
+		return nil
+	}
+	return fs.WalkDir(t.DefaultsFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if t.excluded(path) {
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if err == nil && !d.IsDir() && t.isSource(path) {
+			_, err = t.LoadFile(path)
+		}
+		return err
+	})
+}